@@ -3,29 +3,210 @@ package main
 import (
 	"fmt"
 	"os"
+	"strings"
+	"time"
+
+	"github.com/armon/go-metrics"
 
 	"github.com/kcq/poc-ipblock-pool/internal/app/server"
+	"github.com/kcq/poc-ipblock-pool/pkg/logging"
 	"github.com/kcq/poc-ipblock-pool/pkg/pool"
 )
 
 func main() {
-	fmt.Println("IP Block Allocator PoC...")
+	if len(os.Args) > 1 && os.Args[1] == "token" {
+		printToken(os.Args[2:])
+		return
+	}
+
+	logger := newLogger(logging.LevelInfo)
+	logger.Info("IP Block Allocator PoC...")
+
+	config, err := pool.ConfigFromEnv(logger)
+	if err != nil {
+		panic(err)
+	}
+
+	if token, ok := os.LookupEnv("CONSUL_HTTP_TOKEN"); ok && token != "" {
+		config.Store.Token = token
+		logger.Info("Using Consul ACL token from environment")
+	}
+
+	if tokenFile, ok := os.LookupEnv("CONSUL_HTTP_TOKEN_FILE"); ok && tokenFile != "" {
+		config.Store.TokenFile = tokenFile
+		logger.Info("Using Consul ACL token file from environment", "value", tokenFile)
+	}
+
+	if caFile, ok := os.LookupEnv("CONSUL_CACERT"); ok && caFile != "" {
+		config.Store.CAFile = caFile
+		logger.Info("Using Consul CA certificate from environment", "value", caFile)
+	}
+
+	if certFile, ok := os.LookupEnv("CONSUL_CLIENT_CERT"); ok && certFile != "" {
+		config.Store.CertFile = certFile
+		config.Store.KeyFile = os.Getenv("CONSUL_CLIENT_KEY")
+		logger.Info("Using Consul client certificate from environment", "value", certFile)
+	}
 
-	config := pool.Config{
-		StartRange:    "169.254.51.0",
-		EndRange:      "169.254.255.244",
-		PoolBlockSize: 4,
-		Store: &pool.StoreConfig{
-			Address: "127.0.0.1:8500",
-		},
+	if serverName, ok := os.LookupEnv("CONSUL_TLS_SERVER_NAME"); ok && serverName != "" {
+		config.Store.TLSServerName = serverName
+		logger.Info("Using Consul TLS server name from environment", "value", serverName)
 	}
 
-	if consulAddr, ok := os.LookupEnv("CONSUL_ADDR"); ok {
-		config.Store.Address = consulAddr
-		fmt.Println("Using Consul address from environment =", consulAddr)
+	serverConfig := &server.Config{Logger: logger}
+
+	//the pool metrics are served on /metrics
+	serverConfig.Metrics = server.NewPrometheusSink()
+	sinks := metrics.FanoutSink{serverConfig.Metrics}
+	if statsdAddr, ok := os.LookupEnv("STATSD_ADDR"); ok && statsdAddr != "" {
+		statsdSink, err := metrics.NewStatsdSink(statsdAddr)
+		if err != nil {
+			panic(err)
+		}
+
+		sinks = append(sinks, statsdSink)
+		logger.Info("Using statsd sink from environment", "value", statsdAddr)
+	}
+
+	metricsConfig := metrics.DefaultConfig("ipblock-pool")
+	metricsConfig.EnableHostname = false
+	metricsConfig.EnableRuntimeMetrics = false
+	if _, err := metrics.NewGlobal(metricsConfig, sinks); err != nil {
+		panic(err)
+	}
+
+	if apiKeys, ok := os.LookupEnv("API_KEYS"); ok && apiKeys != "" {
+		serverConfig.Credentials = map[string]string{}
+		for _, credential := range strings.Split(apiKeys, ",") {
+			parts := strings.SplitN(credential, "=", 2)
+			if len(parts) != 2 {
+				panic("invalid API_KEYS value (expected key=namespace)")
+			}
+
+			serverConfig.Credentials[parts[0]] = parts[1]
+		}
+
+		logger.Info("Using API keys from environment", "count", len(serverConfig.Credentials))
+	}
+
+	if authConfig, ok := os.LookupEnv("AUTH_CONFIG"); ok && authConfig != "" {
+		auth, err := server.LoadAuthConfig(authConfig)
+		if err != nil {
+			panic(err)
+		}
+
+		serverConfig.Auth = auth
+		logger.Info("Using auth config from environment", "value", authConfig)
+	}
+
+	if addrs, ok := os.LookupEnv("SERVER_ADDRS"); ok && addrs != "" {
+		serverConfig.Addresses = strings.Split(addrs, ",")
+		logger.Info("Using listen addresses from environment", "value", addrs)
+	}
+
+	if certFile, ok := os.LookupEnv("TLS_CERT_FILE"); ok && certFile != "" {
+		serverConfig.TLS = &server.TLSConfig{
+			CertFile:           certFile,
+			KeyFile:            os.Getenv("TLS_KEY_FILE"),
+			ClientCAFile:       os.Getenv("TLS_CLIENT_CA_FILE"),
+			ClientCertOptional: strings.ToLower(os.Getenv("TLS_CLIENT_CERT_OPTIONAL")) == "true",
+		}
+
+		if interval, ok := os.LookupEnv("TLS_RELOAD_INTERVAL"); ok && interval != "" {
+			value, err := time.ParseDuration(interval)
+			if err != nil {
+				panic(err)
+			}
+
+			serverConfig.TLS.ReloadInterval = value
+		}
+
+		logger.Info("Using TLS from environment", "value", certFile)
 	}
 
-	pmanager := pool.New(&config, nil)
-	app := server.New(pmanager)
+	pmanager := pool.New(config, nil)
+
+	v6Config, err := pool.V6ConfigFromEnv(config)
+	if err != nil {
+		panic(err)
+	}
+
+	var pair *pool.Pair
+	if v6Config != nil {
+		v6Pool := pool.New(v6Config, nil)
+
+		pair, err = pool.NewPair(pmanager, v6Pool)
+		if err != nil {
+			panic(err)
+		}
+
+		serverConfig.Pools = append(serverConfig.Pools, v6Pool)
+	}
+	serverConfig.Pair = pair
+	app := server.NewWithConfig(pmanager, serverConfig)
 	app.Run()
 }
+
+// defaultTokenTTL is the bearer token lifetime when the token command has no ttl
+const defaultTokenTTL = 24 * time.Hour
+
+// printToken prints a bearer token for the subject signed with the AUTH_CONFIG token secret
+// (usage: ipblock-pool-server token <subject> [ttl]; the tokens expire after 24h by default)
+func printToken(args []string) {
+	if len(args) == 0 || len(args) > 2 {
+		tokenUsage("")
+	}
+
+	authConfig, ok := os.LookupEnv("AUTH_CONFIG")
+	if !ok || authConfig == "" {
+		tokenUsage("AUTH_CONFIG is not set")
+	}
+
+	auth, err := server.LoadAuthConfig(authConfig)
+	if err != nil {
+		tokenUsage(fmt.Sprintf("invalid AUTH_CONFIG: %v", err))
+	}
+
+	if auth.TokenSecret == "" {
+		tokenUsage("AUTH_CONFIG has no token secret")
+	}
+
+	ttl := defaultTokenTTL
+	if len(args) > 1 {
+		if ttl, err = time.ParseDuration(args[1]); err != nil || ttl <= 0 {
+			tokenUsage(fmt.Sprintf("invalid ttl: %s (expected a positive duration, e.g. 24h)", args[1]))
+		}
+	}
+
+	fmt.Println(server.SignToken([]byte(auth.TokenSecret), args[0], time.Now().Add(ttl)))
+}
+
+// tokenUsage prints the token command error and usage to stderr and exits
+func tokenUsage(message string) {
+	if message != "" {
+		fmt.Fprintln(os.Stderr, message)
+	}
+
+	fmt.Fprintln(os.Stderr, "usage: AUTH_CONFIG=<auth.json> ipblock-pool-server token <subject> [ttl]")
+	os.Exit(1)
+}
+
+// newLogger creates the logger configured with the LOG_LEVEL and LOG_FORMAT env vars (the logs go to stderr)
+func newLogger(defaultLevel logging.Level) logging.Logger {
+	level := defaultLevel
+	if name, ok := os.LookupEnv("LOG_LEVEL"); ok && name != "" {
+		value, err := logging.ParseLevel(name)
+		if err != nil {
+			panic(err)
+		}
+
+		level = value
+	}
+
+	logger, err := logging.New(os.Stderr, level, os.Getenv("LOG_FORMAT"))
+	if err != nil {
+		panic(err)
+	}
+
+	return logger
+}
@@ -1,31 +1,65 @@
 package main
 
 import (
-	"fmt"
 	"os"
 
 	"github.com/kcq/poc-ipblock-pool/internal/app/cli"
+	"github.com/kcq/poc-ipblock-pool/pkg/logging"
 	"github.com/kcq/poc-ipblock-pool/pkg/pool"
 )
 
 func main() {
-	fmt.Println("IP Block Allocator PoC (cli)...")
-
-	config := pool.Config{
-		StartRange:    "169.254.51.0",
-		EndRange:      "169.254.255.244",
-		PoolBlockSize: 4,
-		Store: &pool.StoreConfig{
-			Address: "127.0.0.1:8500",
-		},
+	//the cli is silent unless LOG_LEVEL is set (the command output goes to stdout)
+	logger := newLogger(logging.LevelOff)
+	logger.Info("IP Block Allocator PoC (cli)...")
+
+	config, err := pool.ConfigFromEnv(logger)
+	if err != nil {
+		panic(err)
 	}
 
-	if consulAddr, ok := os.LookupEnv("CONSUL_ADDR"); ok {
-		config.Store.Address = consulAddr
-		fmt.Println("Using Consul address from environment =", consulAddr)
+	v6Config, err := pool.V6ConfigFromEnv(config)
+	if err != nil {
+		panic(err)
 	}
 
-	pmanager := pool.New(&config, nil)
-	app := cli.New(pmanager)
+	dualStack := v6Config != nil
+
+	//the pools are created after the cli flags (including the Consul flags) are parsed
+	setup := func() (*pool.Manager, *pool.Pair) {
+		pmanager := pool.New(config, nil)
+		if !dualStack {
+			return pmanager, nil
+		}
+
+		pair, err := pool.NewPair(pmanager, pool.New(v6Config, nil))
+		if err != nil {
+			panic(err)
+		}
+
+		return pmanager, pair
+	}
+
+	app := cli.NewWithConfig(nil, &cli.Config{Store: config.Store, Setup: setup, DualStack: dualStack})
 	app.Run(os.Args)
 }
+
+// newLogger creates the logger configured with the LOG_LEVEL and LOG_FORMAT env vars (the logs go to stderr)
+func newLogger(defaultLevel logging.Level) logging.Logger {
+	level := defaultLevel
+	if name, ok := os.LookupEnv("LOG_LEVEL"); ok && name != "" {
+		value, err := logging.ParseLevel(name)
+		if err != nil {
+			panic(err)
+		}
+
+		level = value
+	}
+
+	logger, err := logging.New(os.Stderr, level, os.Getenv("LOG_FORMAT"))
+	if err != nil {
+		panic(err)
+	}
+
+	return logger
+}
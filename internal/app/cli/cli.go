@@ -4,6 +4,10 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"time"
 
 	ucli "github.com/urfave/cli"
 
@@ -11,21 +15,84 @@ import (
 )
 
 const (
-	flagBlock = "block"
-	flagKey   = "key"
+	flagBlock     = "block"
+	flagKey       = "key"
+	flagAddress   = "address"
+	flagIP        = "ip"
+	flagRepair    = "repair"
+	flagStart     = "start"
+	flagEnd       = "end"
+	flagDryRun    = "dry-run"
+	flagCIDR      = "cidr"
+	flagName      = "name"
+	flagSize      = "size"
+	flagBlockSize = "block-size"
+	flagTenant    = "tenant"
+	flagBlocks    = "blocks"
+	flagAddresses = "addresses"
+	flagNamespace = "namespace"
+	flagID        = "id"
+	flagCount     = "count"
+	flagPlan      = "plan"
+	flagHook      = "hook"
+	flagLimit     = "limit"
+	flagLabels    = "labels"
+	flagPrefix    = "prefix"
+	flagSelector  = "selector"
+	flagRange     = "range"
+	flagState     = "state"
+	flagAfter     = "created-after"
+	flagBefore    = "created-before"
+	flagSort      = "sort"
+	flagCursor    = "cursor"
+	flagNewKey    = "new-key"
+	flagDesc      = "description"
+	flagVersion   = "version"
+	flagHolder    = "holder-token"
+	flagOverride  = "override-holder"
+	flagToken     = "consul-token"
+	flagTokenFile = "consul-token-file"
+	flagCAFile    = "consul-ca-file"
+	flagCertFile  = "consul-cert-file"
+	flagKeyFile   = "consul-key-file"
+	flagTLSName   = "consul-tls-server-name"
 )
 
+// Config contains the cli app configurations
+type Config struct {
+	//Pair is the pool pair for the dual-stack allocations (the pair commands are disabled if it's nil)
+	Pair *pool.Pair
+	//Store is the pool Store config (the Consul flags update it before Setup is called)
+	Store *pool.StoreConfig
+	//Setup creates the pool manager and the pool pair after the global flags are parsed
+	//(it's used when the cli app is created without a pool manager)
+	Setup func() (*pool.Manager, *pool.Pair)
+	//DualStack enables the pair commands for the pool pair created by Setup
+	DualStack bool
+}
+
 // App represents the cli app
 type App struct {
-	pm  *pool.Manager
-	cli *ucli.App
+	pm     *pool.Manager
+	config *Config
+	cli    *ucli.App
 }
 
 // New creates a new cli app
 func New(pmanager *pool.Manager) *App {
+	return NewWithConfig(pmanager, nil)
+}
+
+// NewWithConfig creates a new cli app with the provided configurations
+func NewWithConfig(pmanager *pool.Manager, config *Config) *App {
+	if config == nil {
+		config = &Config{}
+	}
+
 	app := &App{
-		pm:  pmanager,
-		cli: ucli.NewApp(),
+		pm:     pmanager,
+		config: config,
+		cli:    ucli.NewApp(),
 	}
 
 	app.init()
@@ -35,6 +102,55 @@ func New(pmanager *pool.Manager) *App {
 func (a *App) init() {
 	a.cli.Name = "ipblock-pool"
 	a.cli.Usage = "IP Block allocator PoC"
+	a.cli.Flags = []ucli.Flag{
+		ucli.StringFlag{
+			Name:   flagNamespace,
+			Value:  "",
+			Usage:  "Namespace for the block keys (the default namespace is empty)",
+			EnvVar: "POOL_NAMESPACE",
+		},
+		ucli.StringFlag{
+			Name:   flagHolder,
+			Value:  "",
+			Usage:  "Block holder token (returned by allocate; required to free or change the block)",
+			EnvVar: "POOL_HOLDER_TOKEN",
+		},
+		ucli.BoolFlag{
+			Name:  flagOverride,
+			Usage: "Free or change the blocks without their holder tokens (admin override)",
+		},
+		ucli.StringFlag{
+			Name:   flagToken,
+			Usage:  "Consul ACL token",
+			EnvVar: "CONSUL_HTTP_TOKEN",
+		},
+		ucli.StringFlag{
+			Name:   flagTokenFile,
+			Usage:  "File with the Consul ACL token",
+			EnvVar: "CONSUL_HTTP_TOKEN_FILE",
+		},
+		ucli.StringFlag{
+			Name:   flagCAFile,
+			Usage:  "CA certificate (PEM) for the Consul server certificate (enables https)",
+			EnvVar: "CONSUL_CACERT",
+		},
+		ucli.StringFlag{
+			Name:   flagCertFile,
+			Usage:  "Client certificate (PEM) for Consul",
+			EnvVar: "CONSUL_CLIENT_CERT",
+		},
+		ucli.StringFlag{
+			Name:   flagKeyFile,
+			Usage:  "Client key (PEM) for Consul",
+			EnvVar: "CONSUL_CLIENT_KEY",
+		},
+		ucli.StringFlag{
+			Name:   flagTLSName,
+			Usage:  "Server name in the Consul server certificate",
+			EnvVar: "CONSUL_TLS_SERVER_NAME",
+		},
+	}
+	a.cli.Before = a.setup
 
 	blockKeyFlag := ucli.StringFlag{
 		Name:  flagKey,
@@ -48,6 +164,64 @@ func (a *App) init() {
 		Usage: "Starting IP address of the IP block",
 	}
 
+	addressKeyFlag := ucli.StringFlag{
+		Name:  flagKey,
+		Value: "",
+		Usage: "Address key (unique within the IP block)",
+	}
+
+	addressIPFlag := ucli.StringFlag{
+		Name:  flagAddress,
+		Value: "",
+		Usage: "IP address in the IP block",
+	}
+
+	rangeStartFlag := ucli.StringFlag{
+		Name:  flagStart,
+		Value: "",
+		Usage: "Start IP address of the range",
+	}
+
+	rangeEndFlag := ucli.StringFlag{
+		Name:  flagEnd,
+		Value: "",
+		Usage: "End IP address of the range",
+	}
+
+	dryRunFlag := ucli.BoolFlag{
+		Name:  flagDryRun,
+		Usage: "preview the change without applying it",
+	}
+
+	childNameFlag := ucli.StringFlag{
+		Name:  flagName,
+		Value: "",
+		Usage: "Child pool name",
+	}
+
+	childSizeFlag := ucli.Int64Flag{
+		Name:  flagSize,
+		Usage: "Number of addresses in the child pool block (a power of two)",
+	}
+
+	tenantFlag := ucli.StringFlag{
+		Name:  flagTenant,
+		Value: "",
+		Usage: "Tenant name (by default it's the block key prefix: tenant:key)",
+	}
+
+	labelsFlag := ucli.StringFlag{
+		Name:  flagLabels,
+		Value: "",
+		Usage: "Block labels (env=prod,tier=web)",
+	}
+
+	descFlag := ucli.StringFlag{
+		Name:  flagDesc,
+		Value: "",
+		Usage: "Block description",
+	}
+
 	a.cli.Commands = []ucli.Command{
 		{
 			Name:    "lookup",
@@ -61,7 +235,7 @@ func (a *App) init() {
 				key := ctx.String(flagKey)
 				block := ctx.String(flagBlock)
 
-				blockInfo := a.pm.Lookup(block, key)
+				blockInfo := a.poolFor(ctx).Lookup(block, key)
 
 				if blockInfo == nil {
 					fmt.Println("Block not found")
@@ -78,13 +252,121 @@ func (a *App) init() {
 			Usage:   "allocate a new IP block",
 			Flags: []ucli.Flag{
 				blockKeyFlag,
+				tenantFlag,
+				labelsFlag,
+				descFlag,
 			},
 			Action: func(ctx *ucli.Context) error {
-				key := ctx.String(flagKey)
+				labels, err := pool.ParseLabels(ctx.String(flagLabels))
+				if err != nil {
+					fmt.Println("Invalid labels!")
+					return nil
+				}
 
-				blockInfo := a.pm.Allocate(key, false)
-				printBlockInfo(blockInfo)
+				blockInfo, err := a.poolFor(ctx).AllocateWith(&pool.AllocationRequest{
+					Key:         ctx.String(flagKey),
+					Tenant:      ctx.String(flagTenant),
+					Labels:      labels,
+					Description: ctx.String(flagDesc),
+				})
 
+				switch err {
+				case pool.ErrPoolExhausted:
+					fmt.Println("No free blocks in pool!")
+				case pool.ErrQuotaExceeded:
+					fmt.Println("Tenant quota exceeded!")
+				case nil:
+					printBlockInfo(blockInfo)
+				default:
+					fmt.Println(err)
+				}
+				return nil
+			},
+		},
+		{
+			Name:  "list",
+			Usage: "list the IP block allocations (use the next cursor to get the next page)",
+			Flags: []ucli.Flag{
+				ucli.StringFlag{
+					Name:  flagPrefix,
+					Value: "",
+					Usage: "Block key prefix",
+				},
+				ucli.StringFlag{
+					Name:  flagSelector,
+					Value: "",
+					Usage: "Label selector (env=prod,tier!=db,gpu,!spot)",
+				},
+				ucli.StringFlag{
+					Name:  flagRange,
+					Value: "",
+					Usage: "IP range (start-end) or CIDR",
+				},
+				ucli.StringFlag{
+					Name:  flagState,
+					Value: "",
+					Usage: "Allocation state (allocated, delegated, paired, grouped or quarantined)",
+				},
+				ucli.StringFlag{
+					Name:  flagAfter,
+					Value: "",
+					Usage: "Blocks created after the time (RFC3339)",
+				},
+				ucli.StringFlag{
+					Name:  flagBefore,
+					Value: "",
+					Usage: "Blocks created before the time (RFC3339)",
+				},
+				ucli.StringFlag{
+					Name:  flagSort,
+					Value: pool.SortByAddress,
+					Usage: "Sort order (address or time)",
+				},
+				ucli.StringFlag{
+					Name:  flagCursor,
+					Value: "",
+					Usage: "Page cursor (the next value from the previous page)",
+				},
+				ucli.IntFlag{
+					Name:  flagLimit,
+					Value: 0,
+					Usage: "Page size (the default is 100)",
+				},
+			},
+			Action: func(ctx *ucli.Context) error {
+				opts := &pool.ListOptions{
+					KeyPrefix: ctx.String(flagPrefix),
+					Selector:  ctx.String(flagSelector),
+					Range:     ctx.String(flagRange),
+					State:     ctx.String(flagState),
+					Sort:      ctx.String(flagSort),
+					Cursor:    ctx.String(flagCursor),
+					Limit:     ctx.Int(flagLimit),
+				}
+
+				var err error
+				if value := ctx.String(flagAfter); value != "" {
+					if opts.CreatedAfter, err = time.Parse(time.RFC3339, value); err != nil {
+						fmt.Println("Invalid time!")
+						return nil
+					}
+				}
+
+				if value := ctx.String(flagBefore); value != "" {
+					if opts.CreatedBefore, err = time.Parse(time.RFC3339, value); err != nil {
+						fmt.Println("Invalid time!")
+						return nil
+					}
+				}
+
+				page, err := a.poolFor(ctx).List(opts)
+
+				switch err {
+				case nil:
+					printBlockInfo(page)
+				default:
+					fmt.Println(err)
+				}
 				return nil
 			},
 		},
@@ -100,11 +382,21 @@ func (a *App) init() {
 				key := ctx.String(flagKey)
 				block := ctx.String(flagBlock)
 
-				err := a.pm.Free(block, key)
+				err := a.poolFor(ctx).Free(block, key)
 
 				switch err {
 				case pool.ErrBlockNotFound:
 					fmt.Println("Block not found!")
+				case pool.ErrHolderRequired:
+					fmt.Println("Block holder token required (use --holder-token)!")
+				case pool.ErrHolderMismatch:
+					fmt.Println("Block holder token doesn't match!")
+				case pool.ErrBlockDelegated:
+					fmt.Println("Block is delegated to a child pool!")
+				case pool.ErrBlockPaired:
+					fmt.Println("Block is paired (use the pair commands)!")
+				case pool.ErrBlockGrouped:
+					fmt.Println("Block is a part of a group (use the group commands)!")
 				case nil:
 					fmt.Println("Done!")
 				default:
@@ -113,14 +405,996 @@ func (a *App) init() {
 				return nil
 			},
 		},
+		{
+			Name:  "grow",
+			Usage: "grow an IP block to the next larger aligned prefix (the neighbouring blocks must be free)",
+			Flags: []ucli.Flag{
+				blockKeyFlag,
+				blockIPFlag,
+			},
+			Action: func(ctx *ucli.Context) error {
+				blockInfo, err := a.poolFor(ctx).GrowBlock(ctx.String(flagBlock), ctx.String(flagKey))
+
+				switch err {
+				case pool.ErrBlockNotFound:
+					fmt.Println("Block not found!")
+				case pool.ErrHolderRequired:
+					fmt.Println("Block holder token required (use --holder-token)!")
+				case pool.ErrHolderMismatch:
+					fmt.Println("Block holder token doesn't match!")
+				case pool.ErrGrowBlocked:
+					fmt.Println("Block can't grow in place (the neighbouring blocks are not free)!")
+				case pool.ErrQuotaExceeded:
+					fmt.Println("Tenant quota exceeded!")
+				case nil:
+					printBlockInfo(blockInfo)
+				default:
+					fmt.Println(err)
+				}
+				return nil
+			},
+		},
+		{
+			Name:  "split",
+			Usage: "split an IP block into smaller blocks (the first block keeps the block key)",
+			Flags: []ucli.Flag{
+				blockKeyFlag,
+				blockIPFlag,
+				ucli.Int64Flag{
+					Name:  flagSize,
+					Value: 0,
+					Usage: "Number of addresses in each new block (a power of two)",
+				},
+			},
+			Action: func(ctx *ucli.Context) error {
+				blocks, err := a.poolFor(ctx).SplitBlock(ctx.String(flagBlock), ctx.String(flagKey), ctx.Int64(flagSize))
+
+				switch err {
+				case pool.ErrBlockNotFound:
+					fmt.Println("Block not found!")
+				case pool.ErrHolderRequired:
+					fmt.Println("Block holder token required (use --holder-token)!")
+				case pool.ErrHolderMismatch:
+					fmt.Println("Block holder token doesn't match!")
+				case pool.ErrInvalidSize:
+					fmt.Println("Invalid block size!")
+				case pool.ErrQuotaExceeded:
+					fmt.Println("Tenant quota exceeded!")
+				case nil:
+					printBlockInfo(blocks)
+				default:
+					fmt.Println(err)
+				}
+				return nil
+			},
+		},
+		{
+			Name:  "update",
+			Usage: "change the key, labels or description of an IP block (only the provided values are changed)",
+			Flags: []ucli.Flag{
+				blockKeyFlag,
+				blockIPFlag,
+				ucli.StringFlag{
+					Name:  flagNewKey,
+					Value: "",
+					Usage: "New block key",
+				},
+				ucli.StringFlag{
+					Name:  flagLabels,
+					Value: "",
+					Usage: "New block labels replacing the current labels (env=prod,tier=web; empty removes all labels)",
+				},
+				ucli.StringFlag{
+					Name:  flagDesc,
+					Value: "",
+					Usage: "New block description",
+				},
+				ucli.Uint64Flag{
+					Name:  flagVersion,
+					Value: 0,
+					Usage: "Expected block version (the update fails if the block changed; 0 means any version)",
+				},
+			},
+			Action: func(ctx *ucli.Context) error {
+				update := &pool.BlockUpdate{}
+				if ctx.IsSet(flagNewKey) {
+					key := ctx.String(flagNewKey)
+					update.Key = &key
+				}
+
+				if ctx.IsSet(flagLabels) {
+					labels, err := pool.ParseLabels(ctx.String(flagLabels))
+					if err != nil {
+						fmt.Println("Invalid labels!")
+						return nil
+					}
+
+					update.Labels = labels
+				}
+
+				if ctx.IsSet(flagDesc) {
+					description := ctx.String(flagDesc)
+					update.Description = &description
+				}
+
+				blockInfo, err := a.poolFor(ctx).UpdateBlock(ctx.String(flagBlock), ctx.String(flagKey), ctx.Uint64(flagVersion), update)
+
+				switch err {
+				case pool.ErrBlockNotFound:
+					fmt.Println("Block not found!")
+				case pool.ErrHolderRequired:
+					fmt.Println("Block holder token required (use --holder-token)!")
+				case pool.ErrHolderMismatch:
+					fmt.Println("Block holder token doesn't match!")
+				case pool.ErrVersionMismatch:
+					fmt.Println("Block was changed (lookup the block to get its current version)!")
+				case pool.ErrKeyInUse:
+					fmt.Println("Block key is used by another block!")
+				case pool.ErrBlockDelegated, pool.ErrBlockPaired, pool.ErrBlockGrouped:
+					fmt.Println("Block key can't be changed (the block is managed with other records)!")
+				case nil:
+					printBlockInfo(blockInfo)
+				default:
+					fmt.Println(err)
+				}
+				return nil
+			},
+		},
+		{
+			Name:  "whois",
+			Usage: "find the IP block allocation that contains the IP address",
+			Flags: []ucli.Flag{
+				ucli.StringFlag{
+					Name:  flagIP,
+					Value: "",
+					Usage: "IP address",
+				},
+			},
+			Action: func(ctx *ucli.Context) error {
+				ip := ctx.String(flagIP)
+				if ip == "" {
+					ip = ctx.Args().First()
+				}
+
+				whoisInfo, err := a.poolFor(ctx).Whois(ip)
+
+				switch err {
+				case pool.ErrInvalidIP:
+					fmt.Println("Invalid IP address!")
+				case nil:
+					printBlockInfo(whoisInfo)
+				default:
+					fmt.Println(err)
+				}
+				return nil
+			},
+		},
+		{
+			Name:  "stats",
+			Usage: "show the pool utilization statistics (or the namespace statistics if the namespace is selected)",
+			Action: func(ctx *ucli.Context) error {
+				if ctx.GlobalString(flagNamespace) != "" {
+					printResult(a.poolFor(ctx).NamespaceStats())
+					return nil
+				}
+
+				printResult(a.pm.Stats())
+				return nil
+			},
+		},
+		{
+			Name:  "blocks",
+			Usage: "list the IP blocks allocated in the namespace",
+			Action: func(ctx *ucli.Context) error {
+				printBlockInfo(a.poolFor(ctx).Blocks())
+				return nil
+			},
+		},
+		{
+			Name:  "fsck",
+			Usage: "check the pool data consistency (and optionally repair it)",
+			Flags: []ucli.Flag{
+				ucli.BoolFlag{
+					Name:  flagRepair,
+					Usage: "fix the inconsistencies that can be fixed safely",
+				},
+			},
+			Action: func(ctx *ucli.Context) error {
+				report := a.pm.Check(ctx.Bool(flagRepair))
+				printBlockInfo(report)
+
+				if !report.Consistent() {
+					return ucli.NewExitError("Pool data is inconsistent!", 1)
+				}
+
+				return nil
+			},
+		},
+		{
+			Name:  "range",
+			Usage: "manage the pool address ranges",
+			Subcommands: []ucli.Command{
+				{
+					Name:  "show",
+					Usage: "show the pool address ranges",
+					Action: func(ctx *ucli.Context) error {
+						printResult(a.pm.Ranges())
+						return nil
+					},
+				},
+				{
+					Name:  "extend",
+					Usage: "extend the end of the (last) pool range",
+					Flags: []ucli.Flag{
+						rangeEndFlag,
+						dryRunFlag,
+					},
+					Action: func(ctx *ucli.Context) error {
+						change, err := a.pm.ExtendRange(ctx.String(flagEnd), ctx.Bool(flagDryRun))
+						printRangeChange(change, err)
+						return nil
+					},
+				},
+				{
+					Name:  "add",
+					Usage: "add an additional pool range",
+					Flags: []ucli.Flag{
+						rangeStartFlag,
+						rangeEndFlag,
+						ucli.StringFlag{
+							Name:  flagCIDR,
+							Value: "",
+							Usage: "CIDR of the range (instead of the start/end IP addresses)",
+						},
+						dryRunFlag,
+					},
+					Action: func(ctx *ucli.Context) error {
+						start, end := ctx.String(flagStart), ctx.String(flagEnd)
+						if cidr := ctx.String(flagCIDR); cidr != "" {
+							start, end = cidr, ""
+						}
+
+						change, err := a.pm.AddRange(start, end, ctx.Bool(flagDryRun))
+						printRangeChange(change, err)
+						return nil
+					},
+				},
+				{
+					Name:  "shrink",
+					Usage: "shrink the (last) pool range (the removed tail must be unallocated)",
+					Flags: []ucli.Flag{
+						rangeEndFlag,
+						dryRunFlag,
+					},
+					Action: func(ctx *ucli.Context) error {
+						change, err := a.pm.ShrinkRange(ctx.String(flagEnd), ctx.Bool(flagDryRun))
+						printRangeChange(change, err)
+						return nil
+					},
+				},
+			},
+		},
+		{
+			Name:  "child",
+			Usage: "manage the child pools delegated from the pool",
+			Subcommands: []ucli.Command{
+				{
+					Name:  "list",
+					Usage: "list the child pools",
+					Action: func(ctx *ucli.Context) error {
+						printBlockInfo(a.pm.Children())
+						return nil
+					},
+				},
+				{
+					Name:  "create",
+					Usage: "create a child pool from a new block with the selected number of addresses",
+					Flags: []ucli.Flag{
+						childNameFlag,
+						childSizeFlag,
+						ucli.Int64Flag{
+							Name:  flagBlockSize,
+							Usage: "Block size in the child pool",
+						},
+					},
+					Action: func(ctx *ucli.Context) error {
+						config := &pool.Config{
+							Name:          ctx.String(flagName),
+							PoolBlockSize: ctx.Int64(flagBlockSize),
+						}
+
+						child, err := a.pm.CreateChild(config, ctx.Int64(flagSize))
+
+						switch err {
+						case pool.ErrPoolExhausted:
+							fmt.Println("No free blocks in pool!")
+						case nil:
+							printResult(child.Info())
+						default:
+							fmt.Println(err)
+						}
+						return nil
+					},
+				},
+				{
+					Name:  "delete",
+					Usage: "delete a child pool (returning its block to the pool)",
+					Flags: []ucli.Flag{
+						childNameFlag,
+					},
+					Action: func(ctx *ucli.Context) error {
+						err := a.pm.DeleteChild(ctx.String(flagName))
+
+						switch err {
+						case pool.ErrPoolNotFound:
+							fmt.Println("Pool not found!")
+						case pool.ErrPoolInUse:
+							fmt.Println("Pool has allocated blocks!")
+						case nil:
+							fmt.Println("Done!")
+						default:
+							fmt.Println(err)
+						}
+						return nil
+					},
+				},
+				{
+					Name:  "shrink",
+					Usage: "shrink a child pool block (the returned addresses must be unallocated)",
+					Flags: []ucli.Flag{
+						childNameFlag,
+						childSizeFlag,
+						dryRunFlag,
+					},
+					Action: func(ctx *ucli.Context) error {
+						change, err := a.pm.ShrinkChild(ctx.String(flagName), ctx.Int64(flagSize), ctx.Bool(flagDryRun))
+						printRangeChange(change, err)
+						return nil
+					},
+				},
+			},
+		},
+		{
+			Name:  "quota",
+			Usage: "manage the tenant quotas",
+			Subcommands: []ucli.Command{
+				{
+					Name:  "list",
+					Usage: "report the quota usage for all tenants",
+					Action: func(ctx *ucli.Context) error {
+						printResult(a.pm.Quotas())
+						return nil
+					},
+				},
+				{
+					Name:  "show",
+					Usage: "show the tenant quota usage",
+					Flags: []ucli.Flag{
+						tenantFlag,
+					},
+					Action: func(ctx *ucli.Context) error {
+						printResult(a.pm.Quota(ctx.String(flagTenant)))
+						return nil
+					},
+				},
+				{
+					Name:  "set",
+					Usage: "set the tenant quota (0 means no limit)",
+					Flags: []ucli.Flag{
+						tenantFlag,
+						ucli.Int64Flag{
+							Name:  flagBlocks,
+							Usage: "Maximum number of blocks",
+						},
+						ucli.Int64Flag{
+							Name:  flagAddresses,
+							Usage: "Maximum number of addresses",
+						},
+					},
+					Action: func(ctx *ucli.Context) error {
+						err := a.pm.SetQuota(&pool.Quota{
+							Tenant:       ctx.String(flagTenant),
+							MaxBlocks:    ctx.Int64(flagBlocks),
+							MaxAddresses: ctx.Int64(flagAddresses),
+						})
+
+						switch err {
+						case pool.ErrInvalidQuota:
+							fmt.Println("Invalid tenant quota!")
+						case nil:
+							printResult(a.pm.Quota(ctx.String(flagTenant)))
+						default:
+							fmt.Println(err)
+						}
+						return nil
+					},
+				},
+				{
+					Name:  "remove",
+					Usage: "remove the tenant quota",
+					Flags: []ucli.Flag{
+						tenantFlag,
+					},
+					Action: func(ctx *ucli.Context) error {
+						err := a.pm.RemoveQuota(ctx.String(flagTenant))
+
+						switch err {
+						case pool.ErrQuotaNotFound:
+							fmt.Println("Tenant quota not found!")
+						case nil:
+							fmt.Println("Done!")
+						default:
+							fmt.Println(err)
+						}
+						return nil
+					},
+				},
+			},
+		},
+		{
+			Name:  "address",
+			Usage: "manage individual IP address allocations in an IP block",
+			Subcommands: []ucli.Command{
+				{
+					Name:    "lookup",
+					Aliases: []string{"g"},
+					Usage:   "lookup IP address allocation by IP address or key",
+					Flags: []ucli.Flag{
+						blockIPFlag,
+						addressKeyFlag,
+						addressIPFlag,
+					},
+					Action: func(ctx *ucli.Context) error {
+						block := ctx.String(flagBlock)
+						key := ctx.String(flagKey)
+						address := ctx.String(flagAddress)
+
+						addressInfo := a.poolFor(ctx).LookupAddress(block, address, key)
+
+						if addressInfo == nil {
+							fmt.Println("Address not found")
+						} else {
+							printBlockInfo(addressInfo)
+						}
+
+						return nil
+					},
+				},
+				{
+					Name:    "allocate",
+					Aliases: []string{"a"},
+					Usage:   "allocate a new IP address in the IP block",
+					Flags: []ucli.Flag{
+						blockIPFlag,
+						addressKeyFlag,
+					},
+					Action: func(ctx *ucli.Context) error {
+						block := ctx.String(flagBlock)
+						key := ctx.String(flagKey)
+
+						addressInfo, err := a.poolFor(ctx).AllocateAddress(block, key)
+
+						switch err {
+						case pool.ErrBlockNotFound:
+							fmt.Println("Block not found!")
+						case pool.ErrHolderRequired:
+							fmt.Println("Block holder token required (use --holder-token)!")
+						case pool.ErrHolderMismatch:
+							fmt.Println("Block holder token doesn't match!")
+						case nil:
+							printBlockInfo(addressInfo)
+						default:
+							fmt.Println(err)
+						}
+						return nil
+					},
+				},
+				{
+					Name:    "free",
+					Aliases: []string{"d"},
+					Usage:   "free an IP address in the IP block",
+					Flags: []ucli.Flag{
+						blockIPFlag,
+						addressKeyFlag,
+						addressIPFlag,
+					},
+					Action: func(ctx *ucli.Context) error {
+						block := ctx.String(flagBlock)
+						key := ctx.String(flagKey)
+						address := ctx.String(flagAddress)
+
+						err := a.poolFor(ctx).FreeAddress(block, address, key)
+
+						switch err {
+						case pool.ErrBlockNotFound:
+							fmt.Println("Block not found!")
+						case pool.ErrAddressNotFound:
+							fmt.Println("Address not found!")
+						case pool.ErrHolderRequired:
+							fmt.Println("Block holder token required (use --holder-token)!")
+						case pool.ErrHolderMismatch:
+							fmt.Println("Block holder token doesn't match!")
+						case nil:
+							fmt.Println("Done!")
+						default:
+							fmt.Println(err)
+						}
+						return nil
+					},
+				},
+			},
+		},
+	}
+
+	a.cli.Commands = append(a.cli.Commands, a.groupCommand(), a.defragCommand())
+
+	if a.config.Pair != nil || a.config.DualStack {
+		a.cli.Commands = append(a.cli.Commands, a.pairCommand())
 	}
 }
 
+func (a *App) groupCommand() ucli.Command {
+	groupFlags := []ucli.Flag{
+		ucli.StringFlag{
+			Name:  flagID,
+			Value: "",
+			Usage: "Block group ID",
+		},
+		ucli.StringFlag{
+			Name:  flagKey,
+			Value: "",
+			Usage: "Block group key",
+		},
+		ucli.StringFlag{
+			Name:  flagAddress,
+			Value: "",
+			Usage: "Any address in the block group",
+		},
+	}
+
+	return ucli.Command{
+		Name:  "group",
+		Usage: "manage the groups of contiguous IP blocks",
+		Subcommands: []ucli.Command{
+			{
+				Name:  "lookup",
+				Usage: "lookup block group by ID, key or any address in the group",
+				Flags: groupFlags,
+				Action: func(ctx *ucli.Context) error {
+					groupInfo := a.poolFor(ctx).LookupGroup(ctx.String(flagID), ctx.String(flagKey), ctx.String(flagAddress))
+
+					if groupInfo == nil {
+						fmt.Println("Block group not found")
+					} else {
+						printBlockInfo(groupInfo)
+					}
+
+					return nil
+				},
+			},
+			{
+				Name:  "allocate",
+				Usage: "allocate a group of contiguous IP blocks (aligned to the group size)",
+				Flags: []ucli.Flag{
+					ucli.StringFlag{
+						Name:  flagKey,
+						Value: "",
+						Usage: "Block group key",
+					},
+					ucli.Int64Flag{
+						Name:  flagCount,
+						Value: 2,
+						Usage: "Number of blocks in the group (a power of two)",
+					},
+					ucli.StringFlag{
+						Name:  flagTenant,
+						Value: "",
+						Usage: "Tenant name (by default it's the block key prefix: tenant:key)",
+					},
+				},
+				Action: func(ctx *ucli.Context) error {
+					groupInfo, err := a.poolFor(ctx).AllocateGroup(&pool.AllocationRequest{
+						Key:    ctx.String(flagKey),
+						Tenant: ctx.String(flagTenant),
+					}, ctx.Int64(flagCount))
+
+					switch err {
+					case pool.ErrNoContiguousRun:
+						fmt.Println("No contiguous run of free blocks in pool!")
+					case pool.ErrQuotaExceeded:
+						fmt.Println("Tenant quota exceeded!")
+					case nil:
+						printBlockInfo(groupInfo)
+					default:
+						fmt.Println(err)
+					}
+					return nil
+				},
+			},
+			{
+				Name:  "free",
+				Usage: "free all IP blocks in a block group",
+				Flags: groupFlags,
+				Action: func(ctx *ucli.Context) error {
+					err := a.poolFor(ctx).FreeGroup(ctx.String(flagID), ctx.String(flagKey), ctx.String(flagAddress))
+
+					switch err {
+					case pool.ErrGroupNotFound:
+						fmt.Println("Block group not found!")
+					case pool.ErrHolderRequired:
+						fmt.Println("Block holder token required (use --holder-token)!")
+					case pool.ErrHolderMismatch:
+						fmt.Println("Block holder token doesn't match!")
+					case nil:
+						fmt.Println("Done!")
+					default:
+						fmt.Println(err)
+					}
+					return nil
+				},
+			},
+		},
+	}
+}
+
+func (a *App) defragCommand() ucli.Command {
+	planFlag := ucli.StringFlag{
+		Name:  flagPlan,
+		Value: "",
+		Usage: "Defragmentation plan file",
+	}
+
+	return ucli.Command{
+		Name:  "defrag",
+		Usage: "plan and apply the block moves that free contiguous regions",
+		Subcommands: []ucli.Command{
+			{
+				Name:  "plan",
+				Usage: "propose the minimal set of block moves that frees a contiguous region",
+				Flags: []ucli.Flag{
+					ucli.Int64Flag{
+						Name:  flagSize,
+						Value: 0,
+						Usage: "Number of addresses in the region (a power of two)",
+					},
+					planFlag,
+				},
+				Action: func(ctx *ucli.Context) error {
+					plan, err := a.pm.PlanDefrag(ctx.Int64(flagSize))
+
+					switch err {
+					case pool.ErrInvalidSize:
+						fmt.Println("Invalid region size!")
+					case pool.ErrDefragNotPossible:
+						fmt.Println("No region can be freed by moving blocks!")
+					case nil:
+						if ctx.String(flagPlan) != "" {
+							savePlan(ctx.String(flagPlan), plan)
+							fmt.Printf("Saved plan with %d move(s) => %s\n", len(plan.Moves), ctx.String(flagPlan))
+						} else {
+							printBlockInfo(plan)
+						}
+					default:
+						fmt.Println(err)
+					}
+					return nil
+				},
+			},
+			{
+				Name:  "apply",
+				Usage: "apply the reviewed plan move by move (the plan file records the applied moves)",
+				Flags: []ucli.Flag{
+					planFlag,
+					ucli.StringFlag{
+						Name:  flagHook,
+						Value: "",
+						Usage: "Command to run before and after each move (the move is passed in DEFRAG_* env vars)",
+					},
+					ucli.IntFlag{
+						Name:  flagLimit,
+						Value: 0,
+						Usage: "Maximum number of moves to apply (0 means all)",
+					},
+				},
+				Action: func(ctx *ucli.Context) error {
+					raw, err := ioutil.ReadFile(ctx.String(flagPlan))
+					if err != nil {
+						fmt.Println(err)
+						return nil
+					}
+
+					var plan pool.DefragPlan
+					if err := json.Unmarshal(raw, &plan); err != nil {
+						fmt.Println("Invalid plan file!")
+						return nil
+					}
+
+					hook := &defragHook{
+						command:  ctx.String(flagHook),
+						planFile: ctx.String(flagPlan),
+						plan:     &plan,
+					}
+
+					//the held blocks are moved only with their holder token or the holder override
+					applied, err := a.poolFor(ctx).ApplyDefrag(&plan, hook, ctx.Int(flagLimit))
+					fmt.Printf("Applied %d move(s)\n", applied)
+
+					switch err {
+					case pool.ErrInvalidPlan:
+						fmt.Println("Plan is for another pool!")
+					case pool.ErrStaleMove:
+						fmt.Println("Block changed since the plan was created (create a new plan)!")
+					case pool.ErrMoveTargetUsed:
+						fmt.Println("Move target is not free anymore (create a new plan)!")
+					case pool.ErrHolderRequired, pool.ErrHolderMismatch:
+						fmt.Println("Block has a holder (use --holder-token or --override-holder)!")
+					case nil:
+						fmt.Println("Done!")
+					default:
+						fmt.Println(err)
+					}
+					return nil
+				},
+			},
+		},
+	}
+}
+
+// defragHook runs the hook command before and after each move
+// and records the applied moves in the plan file
+type defragHook struct {
+	command  string
+	planFile string
+	plan     *pool.DefragPlan
+}
+
+func (h *defragHook) BeforeMove(move *pool.DefragMove) error {
+	return h.run("before", move)
+}
+
+func (h *defragHook) AfterMove(move *pool.DefragMove) error {
+	savePlan(h.planFile, h.plan)
+	return h.run("after", move)
+}
+
+func (h *defragHook) run(stage string, move *pool.DefragMove) error {
+	if h.command == "" {
+		return nil
+	}
+
+	cmd := exec.Command("sh", "-c", h.command)
+	cmd.Env = append(os.Environ(),
+		"DEFRAG_STAGE="+stage,
+		"DEFRAG_BLOCK="+move.Block,
+		"DEFRAG_KEY="+move.Key,
+		"DEFRAG_FROM="+move.From,
+		"DEFRAG_TO="+move.To)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+func savePlan(path string, plan *pool.DefragPlan) {
+	raw, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+
+	if err := ioutil.WriteFile(path, append(raw, '\n'), 0644); err != nil {
+		panic(err)
+	}
+}
+
+func (a *App) pairCommand() ucli.Command {
+	keyFlag := ucli.StringFlag{
+		Name:  flagKey,
+		Value: "",
+		Usage: "Block key",
+	}
+
+	addressFlag := ucli.StringFlag{
+		Name:  flagAddress,
+		Value: "",
+		Usage: "Any IPv4 or IPv6 address in the paired blocks",
+	}
+
+	return ucli.Command{
+		Name:  "pair",
+		Usage: "manage the dual-stack (IPv4 + IPv6) IP block pairs",
+		Subcommands: []ucli.Command{
+			{
+				Name:  "lookup",
+				Usage: "lookup IP block pair by any address in the pair or by key",
+				Flags: []ucli.Flag{
+					keyFlag,
+					addressFlag,
+				},
+				Action: func(ctx *ucli.Context) error {
+					pairInfo := a.pairFor(ctx).Lookup(ctx.String(flagAddress), ctx.String(flagKey))
+
+					if pairInfo == nil {
+						fmt.Println("Block pair not found")
+					} else {
+						printBlockInfo(pairInfo)
+					}
+
+					return nil
+				},
+			},
+			{
+				Name:  "allocate",
+				Usage: "allocate a new IP block pair",
+				Flags: []ucli.Flag{
+					keyFlag,
+					ucli.StringFlag{
+						Name:  flagTenant,
+						Value: "",
+						Usage: "Tenant name (by default it's the block key prefix: tenant:key)",
+					},
+					ucli.StringFlag{
+						Name:  flagLabels,
+						Value: "",
+						Usage: "Block labels (env=prod,tier=web)",
+					},
+					ucli.StringFlag{
+						Name:  flagDesc,
+						Value: "",
+						Usage: "Block description",
+					},
+				},
+				Action: func(ctx *ucli.Context) error {
+					labels, err := pool.ParseLabels(ctx.String(flagLabels))
+					if err != nil {
+						fmt.Println("Invalid labels!")
+						return nil
+					}
+
+					pairInfo, err := a.pairFor(ctx).Allocate(&pool.AllocationRequest{
+						Key:         ctx.String(flagKey),
+						Tenant:      ctx.String(flagTenant),
+						Labels:      labels,
+						Description: ctx.String(flagDesc),
+					})
+
+					switch err {
+					case pool.ErrPoolExhausted:
+						fmt.Println("No free blocks in pool!")
+					case pool.ErrQuotaExceeded:
+						fmt.Println("Tenant quota exceeded!")
+					case nil:
+						printBlockInfo(pairInfo)
+					default:
+						fmt.Println(err)
+					}
+					return nil
+				},
+			},
+			{
+				Name:  "free",
+				Usage: "free an IP block pair",
+				Flags: []ucli.Flag{
+					keyFlag,
+					addressFlag,
+				},
+				Action: func(ctx *ucli.Context) error {
+					err := a.pairFor(ctx).Free(ctx.String(flagAddress), ctx.String(flagKey))
+
+					switch err {
+					case pool.ErrBlockNotFound:
+						fmt.Println("Block pair not found!")
+					case pool.ErrHolderRequired:
+						fmt.Println("Block holder token required (use --holder-token)!")
+					case pool.ErrHolderMismatch:
+						fmt.Println("Block holder token doesn't match!")
+					case nil:
+						fmt.Println("Done!")
+					default:
+						fmt.Println(err)
+					}
+					return nil
+				},
+			},
+		},
+	}
+}
+
+// setup applies the Consul flags to the Store config and creates the pool manager (if it's not created yet)
+func (a *App) setup(ctx *ucli.Context) error {
+	if store := a.config.Store; store != nil {
+		if value := ctx.String(flagToken); value != "" {
+			store.Token = value
+		}
+
+		if value := ctx.String(flagTokenFile); value != "" {
+			store.TokenFile = value
+		}
+
+		if value := ctx.String(flagCAFile); value != "" {
+			store.CAFile = value
+		}
+
+		if value := ctx.String(flagCertFile); value != "" {
+			store.CertFile = value
+		}
+
+		if value := ctx.String(flagKeyFile); value != "" {
+			store.KeyFile = value
+		}
+
+		if value := ctx.String(flagTLSName); value != "" {
+			store.TLSServerName = value
+		}
+	}
+
+	if a.pm == nil && a.config.Setup != nil {
+		a.pm, a.config.Pair = a.config.Setup()
+	}
+
+	return nil
+}
+
+// pairFor returns the pool pair scoped to the selected namespace and block holder
+func (a *App) pairFor(ctx *ucli.Context) *pool.Pair {
+	pair, err := a.config.Pair.WithNamespace(ctx.GlobalString(flagNamespace))
+	if err != nil {
+		fmt.Println("Invalid namespace!")
+		os.Exit(1)
+	}
+
+	if ctx.GlobalBool(flagOverride) {
+		return pair.WithHolderOverride()
+	}
+
+	return pair.WithHolder(ctx.GlobalString(flagHolder))
+}
+
+// poolFor returns the pool manager scoped to the selected namespace and block holder
+func (a *App) poolFor(ctx *ucli.Context) *pool.Manager {
+	pm, err := a.pm.WithNamespace(ctx.GlobalString(flagNamespace))
+	if err != nil {
+		fmt.Println("Invalid namespace!")
+		os.Exit(1)
+	}
+
+	if ctx.GlobalBool(flagOverride) {
+		return pm.WithHolderOverride()
+	}
+
+	return pm.WithHolder(ctx.GlobalString(flagHolder))
+}
+
 // Run starts the cli app execution
 func (a *App) Run(args []string) {
 	a.cli.Run(args)
 }
 
+func printRangeChange(change *pool.RangeChange, err error) {
+	switch err {
+	case pool.ErrInvalidRange:
+		fmt.Println("Invalid range!")
+	case pool.ErrRangeOverlap:
+		fmt.Println("Range overlaps an existing pool range!")
+	case pool.ErrRangeInUse:
+		fmt.Println("Range has allocated blocks!")
+	case pool.ErrChildPoolRange:
+		fmt.Println("Child pool ranges are managed by the parent pool!")
+	case nil:
+		printBlockInfo(change)
+	default:
+		fmt.Println(err)
+	}
+}
+
+// printResult prints the value or the error
+func printResult(value interface{}, err error) {
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	printBlockInfo(value)
+}
+
 func printBlockInfo(value interface{}) {
 	buf := &bytes.Buffer{}
 	enc := json.NewEncoder(buf)
@@ -0,0 +1,65 @@
+package cli
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kcq/poc-ipblock-pool/pkg/pool"
+)
+
+func TestDefragHook(t *testing.T) {
+	dir := t.TempDir()
+	envFile := filepath.Join(dir, "env")
+	move := &pool.DefragMove{Block: "id-1", Key: "vm-1", From: "169.254.60.4", To: "169.254.60.16", Applied: true}
+	hook := &defragHook{
+		command:  `echo "$DEFRAG_STAGE $DEFRAG_BLOCK $DEFRAG_KEY $DEFRAG_FROM $DEFRAG_TO" >> ` + envFile,
+		planFile: filepath.Join(dir, "plan.json"),
+		plan:     &pool.DefragPlan{Pool: "default", Moves: []*pool.DefragMove{move}},
+	}
+
+	if err := hook.BeforeMove(move); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := hook.AfterMove(move); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := ioutil.ReadFile(envFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"before id-1 vm-1 169.254.60.4 169.254.60.16",
+		"after id-1 vm-1 169.254.60.4 169.254.60.16",
+	}
+	if got := strings.Split(strings.TrimSpace(string(raw)), "\n"); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("hook env = %q, want %q", got, want)
+	}
+
+	//the plan file records the applied moves, so the plan can be applied again after a failure
+	raw, err = ioutil.ReadFile(hook.planFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var plan pool.DefragPlan
+	if err := json.Unmarshal(raw, &plan); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(plan.Moves) != 1 || !plan.Moves[0].Applied {
+		t.Errorf("saved plan moves = %+v, want the applied move", plan.Moves)
+	}
+}
+
+func TestDefragHookError(t *testing.T) {
+	hook := &defragHook{command: "exit 3"}
+	if err := hook.BeforeMove(&pool.DefragMove{}); err == nil {
+		t.Error("BeforeMove() with a failing hook command succeeded")
+	}
+}
@@ -0,0 +1,374 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Authentication methods
+const (
+	AuthMethodAPIKey = "api-key"
+	AuthMethodToken  = "token"
+	AuthMethodCert   = "cert"
+)
+
+// Roles (each role includes the permissions of the previous roles)
+const (
+	//RoleReader can look up and list the allocations
+	RoleReader = "reader"
+	//RoleAllocator can also allocate, change and free the blocks
+	RoleAllocator = "allocator"
+	//RoleAdmin can also use the pool management APIs and override the block holders
+	RoleAdmin = "admin"
+)
+
+var roleLevels = map[string]int{
+	RoleReader:    1,
+	RoleAllocator: 2,
+	RoleAdmin:     3,
+}
+
+const (
+	headerAuthorization = "Authorization"
+	bearerPrefix        = "Bearer "
+	tokenSeparator      = "."
+)
+
+// Authentication errors
+var (
+	//
+	ErrUnknownAPIKey = errors.New("Unknown API key")
+	//
+	ErrInvalidToken = errors.New("Invalid bearer token")
+	//
+	ErrTokenExpired = errors.New("Bearer token expired")
+	//
+	ErrInvalidAuthConfig = errors.New("Invalid auth config")
+)
+
+// Identity is the authenticated request identity
+type Identity struct {
+	Name string `json:"name"`
+	//Method is the authentication method (api-key, token or cert)
+	Method string `json:"method"`
+}
+
+// Authenticator identifies the request caller
+type Authenticator interface {
+	//Authenticate returns the request identity (nil if the request has no credentials for the authenticator)
+	//or an error if the request credentials are invalid
+	Authenticate(r *http.Request) (*Identity, error)
+}
+
+// APIKeyAuthenticator identifies the callers by the static API keys passed in the X-API-Key header
+type APIKeyAuthenticator struct {
+	//Keys maps the API keys to the identity names
+	Keys map[string]string
+}
+
+// Authenticate returns the identity for the request API key
+func (auth *APIKeyAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	key := r.Header.Get(headerAPIKey)
+	if key == "" {
+		return nil, nil
+	}
+
+	name, ok := auth.Keys[key]
+	if !ok {
+		return nil, ErrUnknownAPIKey
+	}
+
+	return &Identity{Name: name, Method: AuthMethodAPIKey}, nil
+}
+
+// TokenAuthenticator identifies the callers by the HMAC-SHA256 signed bearer tokens (see SignToken)
+type TokenAuthenticator struct {
+	Secret []byte
+}
+
+type tokenClaims struct {
+	Subject string `json:"sub"`
+	Expires int64  `json:"exp,omitempty"`
+}
+
+// SignToken creates a bearer token for the subject
+// (the token is "<claims>.<signature>" with the base64url encoded JSON claims; a zero expiration time means no expiration)
+func SignToken(secret []byte, subject string, expires time.Time) string {
+	claims := &tokenClaims{Subject: subject}
+	if !expires.IsZero() {
+		claims.Expires = expires.Unix()
+	}
+
+	raw, err := json.Marshal(claims)
+	if err != nil {
+		panic(err)
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(raw)
+	return payload + tokenSeparator + tokenSignature(secret, payload)
+}
+
+func tokenSignature(secret []byte, payload string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Authenticate returns the identity for the request bearer token
+func (auth *TokenAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	value := r.Header.Get(headerAuthorization)
+	if !strings.HasPrefix(value, bearerPrefix) {
+		return nil, nil
+	}
+
+	parts := strings.Split(strings.TrimPrefix(value, bearerPrefix), tokenSeparator)
+	if len(parts) != 2 || !hmac.Equal([]byte(parts[1]), []byte(tokenSignature(auth.Secret, parts[0]))) {
+		return nil, ErrInvalidToken
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var claims tokenClaims
+	if err := json.Unmarshal(raw, &claims); err != nil || claims.Subject == "" {
+		return nil, ErrInvalidToken
+	}
+
+	if claims.Expires != 0 && time.Now().Unix() >= claims.Expires {
+		return nil, ErrTokenExpired
+	}
+
+	return &Identity{Name: claims.Subject, Method: AuthMethodToken}, nil
+}
+
+// CertAuthenticator identifies the callers by the common name of their verified TLS client certificates
+type CertAuthenticator struct{}
+
+// Authenticate returns the identity for the request client certificate
+func (auth *CertAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil, nil
+	}
+
+	name := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	if name == "" {
+		return nil, nil
+	}
+
+	return &Identity{Name: name, Method: AuthMethodCert}, nil
+}
+
+// RoleBinding grants a role to an identity in the selected pools and namespaces
+type RoleBinding struct {
+	Identity string `json:"identity"`
+	Role     string `json:"role"`
+	//Pools are the pool names ("default" is the default pool; no pools means all pools)
+	Pools []string `json:"pools,omitempty"`
+	//Namespaces are the namespaces ("" is the default namespace; no namespaces means all namespaces)
+	Namespaces []string `json:"namespaces,omitempty"`
+}
+
+// grants returns true if the binding grants the role in the pool and the namespace
+// (namespaceAny requires a binding for all namespaces; the empty pool name, used by the requests
+// that are not for one pool, e.g., the pool list, requires a binding for all pools)
+func (b *RoleBinding) grants(role, poolName, namespace string) bool {
+	if roleLevels[b.Role] < roleLevels[role] {
+		return false
+	}
+
+	if len(b.Pools) > 0 && !contains(b.Pools, poolName) {
+		return false
+	}
+
+	if len(b.Namespaces) == 0 {
+		return true
+	}
+
+	return namespace != namespaceAny && contains(b.Namespaces, namespace)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AuthConfig contains the authentication methods and the role bindings (see LoadAuthConfig)
+type AuthConfig struct {
+	//APIKeys maps the API keys (passed in the X-API-Key header) to the identity names
+	APIKeys map[string]string `json:"api_keys,omitempty"`
+	//TokenSecret is the bearer token HMAC key (the bearer tokens are disabled if it's empty)
+	TokenSecret string `json:"token_secret,omitempty"`
+	//ClientCerts enables the TLS client certificate identities (the certificate common names)
+	ClientCerts bool           `json:"client_certs,omitempty"`
+	Bindings    []*RoleBinding `json:"bindings"`
+}
+
+// LoadAuthConfig loads the auth config from a JSON file
+func LoadAuthConfig(path string) (*AuthConfig, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config AuthConfig
+	if err := json.Unmarshal(raw, &config); err != nil {
+		return nil, err
+	}
+
+	for _, b := range config.Bindings {
+		if b.Identity == "" || roleLevels[b.Role] == 0 {
+			return nil, ErrInvalidAuthConfig
+		}
+	}
+
+	return &config, nil
+}
+
+// initAuth sets up the authenticators and the role bindings from the auth config and the namespace credentials
+// (an unrestricted credential is an admin and a namespace credential is an allocator in its namespace)
+func (a *App) initAuth() {
+	a.authenticators = append(a.authenticators, a.config.Authenticators...)
+
+	keys := map[string]string{}
+	if auth := a.config.Auth; auth != nil {
+		for key, name := range auth.APIKeys {
+			keys[key] = name
+		}
+
+		a.bindings = append(a.bindings, auth.Bindings...)
+	}
+
+	for key, namespace := range a.config.Credentials {
+		binding := &RoleBinding{Identity: "namespace:" + namespace, Role: RoleAllocator}
+		if namespace == namespaceAny {
+			binding.Role = RoleAdmin
+		} else {
+			binding.Namespaces = []string{namespace}
+		}
+
+		keys[key] = binding.Identity
+		a.bindings = append(a.bindings, binding)
+	}
+
+	if len(keys) > 0 {
+		a.authenticators = append(a.authenticators, &APIKeyAuthenticator{Keys: keys})
+	}
+
+	if auth := a.config.Auth; auth != nil {
+		if auth.TokenSecret != "" {
+			a.authenticators = append(a.authenticators, &TokenAuthenticator{Secret: []byte(auth.TokenSecret)})
+		}
+
+		if auth.ClientCerts {
+			a.authenticators = append(a.authenticators, &CertAuthenticator{})
+		}
+	}
+}
+
+// identify returns the request identity (the first authenticator that recognizes the request credentials wins)
+func (a *App) identify(r *http.Request) (*Identity, error) {
+	for _, auth := range a.authenticators {
+		identity, err := auth.Authenticate(r)
+		if err != nil || identity != nil {
+			return identity, err
+		}
+	}
+
+	return nil, nil
+}
+
+func (a *App) bindingsFor(identity *Identity) []*RoleBinding {
+	var bindings []*RoleBinding
+	for _, b := range a.bindings {
+		if b.Identity == identity.Name {
+			bindings = append(bindings, b)
+		}
+	}
+
+	return bindings
+}
+
+// requestPoolName returns the name of the pool the request uses
+// (the original API uses the main pool; "" means the request is not for one pool, e.g., the pool list)
+func (a *App) requestPoolName(r *http.Request) string {
+	if !strings.HasPrefix(r.URL.Path, pathV1+"/") {
+		return poolName(a.pm)
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, pathV1+pathV1Pools), "/")
+	if len(parts) < 2 || parts[0] != "" {
+		return ""
+	}
+
+	return parts[1]
+}
+
+// requiredRole returns the role required for the request method (the pool management routes also use adminOnly)
+func requiredRole(r *http.Request) string {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return RoleReader
+	}
+
+	return RoleAllocator
+}
+
+// authorize checks the identity role bindings for the request and selects the request namespace
+func (a *App) authorize(reqAccess *access, identity *Identity, r *http.Request) bool {
+	bindings := a.bindingsFor(identity)
+	poolName := a.requestPoolName(r)
+	role := requiredRole(r)
+
+	reqAccess.identity = identity
+	reqAccess.restricted = true
+	reqAccess.admin = false
+	for _, b := range bindings {
+		if b.grants(RoleReader, poolName, namespaceAny) {
+			reqAccess.restricted = false
+		}
+
+		if b.grants(RoleAdmin, poolName, namespaceAny) {
+			reqAccess.admin = true
+		}
+	}
+
+	if reqAccess.restricted && r.URL.Query().Get(paramNamespace) == "" {
+		for _, b := range bindings {
+			if len(b.Namespaces) > 0 && b.grants(role, poolName, b.Namespaces[0]) {
+				reqAccess.namespace = b.Namespaces[0]
+				break
+			}
+		}
+	}
+
+	for _, b := range bindings {
+		if b.grants(role, poolName, reqAccess.namespace) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// owner returns the name of the authenticated caller ("" if the authentication is disabled)
+func (ra *access) owner() string {
+	if ra.identity == nil {
+		return ""
+	}
+
+	return ra.identity.Name
+}
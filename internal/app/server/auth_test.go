@@ -0,0 +1,171 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRoleBindingGrants(t *testing.T) {
+	tests := []struct {
+		name      string
+		binding   *RoleBinding
+		role      string
+		poolName  string
+		namespace string
+		want      bool
+	}{
+		{
+			name:    "same role",
+			binding: &RoleBinding{Role: RoleAllocator},
+			role:    RoleAllocator, poolName: "default", namespace: "team",
+			want: true,
+		},
+		{
+			name:    "lower role",
+			binding: &RoleBinding{Role: RoleAdmin},
+			role:    RoleReader, poolName: "default",
+			want: true,
+		},
+		{
+			name:    "higher role",
+			binding: &RoleBinding{Role: RoleReader},
+			role:    RoleAllocator, poolName: "default",
+		},
+		{
+			name:    "unknown binding role",
+			binding: &RoleBinding{Role: "owner"},
+			role:    RoleReader, poolName: "default",
+		},
+		{
+			name:    "bound pool",
+			binding: &RoleBinding{Role: RoleReader, Pools: []string{"default", "edge"}},
+			role:    RoleReader, poolName: "edge",
+			want: true,
+		},
+		{
+			name:    "other pool",
+			binding: &RoleBinding{Role: RoleReader, Pools: []string{"default"}},
+			role:    RoleReader, poolName: "edge",
+		},
+		{
+			name:    "no pool with bound pools",
+			binding: &RoleBinding{Role: RoleReader, Pools: []string{"default"}},
+			role:    RoleReader,
+		},
+		{
+			name:    "no pool",
+			binding: &RoleBinding{Role: RoleReader},
+			role:    RoleReader,
+			want:    true,
+		},
+		{
+			name:    "bound namespace",
+			binding: &RoleBinding{Role: RoleAllocator, Namespaces: []string{"team"}},
+			role:    RoleAllocator, poolName: "default", namespace: "team",
+			want: true,
+		},
+		{
+			name:    "other namespace",
+			binding: &RoleBinding{Role: RoleAllocator, Namespaces: []string{"team"}},
+			role:    RoleAllocator, poolName: "default",
+		},
+		{
+			name:    "default namespace",
+			binding: &RoleBinding{Role: RoleAllocator, Namespaces: []string{""}},
+			role:    RoleAllocator, poolName: "default",
+			want: true,
+		},
+		{
+			name:    "all namespaces",
+			binding: &RoleBinding{Role: RoleReader},
+			role:    RoleReader, poolName: "default", namespace: namespaceAny,
+			want: true,
+		},
+		{
+			name:    "all namespaces with bound namespaces",
+			binding: &RoleBinding{Role: RoleReader, Namespaces: []string{"team", namespaceAny}},
+			role:    RoleReader, poolName: "default", namespace: namespaceAny,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.binding.grants(test.role, test.poolName, test.namespace); got != test.want {
+				t.Errorf("grants(%q, %q, %q) = %v, want %v", test.role, test.poolName, test.namespace, got, test.want)
+			}
+		})
+	}
+}
+
+func TestAuthorizePoolBindings(t *testing.T) {
+	a := &App{bindings: []*RoleBinding{
+		{Identity: "edge-reader", Role: RoleReader, Pools: []string{"edge"}},
+		{Identity: "reader", Role: RoleReader},
+	}}
+
+	tests := []struct {
+		name     string
+		identity string
+		path     string
+		want     bool
+	}{
+		{name: "bound pool", identity: "edge-reader", path: "/v1/pools/edge/blocks", want: true},
+		{name: "other pool", identity: "edge-reader", path: "/v1/pools/default/blocks"},
+		{name: "pool list with bound pools", identity: "edge-reader", path: "/v1/pools"},
+		{name: "pairs with bound pools", identity: "edge-reader", path: "/v1/pairs/vm-1"},
+		{name: "pool list", identity: "reader", path: "/v1/pools", want: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", test.path, nil)
+			if got := a.authorize(&access{}, &Identity{Name: test.identity}, r); got != test.want {
+				t.Errorf("authorize(%s, %s) = %v, want %v", test.identity, test.path, got, test.want)
+			}
+		})
+	}
+}
+
+func TestTokenAuthenticator(t *testing.T) {
+	secret := []byte("secret")
+	auth := &TokenAuthenticator{Secret: secret}
+
+	tests := []struct {
+		name    string
+		header  string
+		want    string
+		wantErr error
+	}{
+		{name: "no token"},
+		{name: "not a bearer token", header: "Basic dXNlcjpwYXNz"},
+		{name: "token", header: bearerPrefix + SignToken(secret, "alice", time.Time{}), want: "alice"},
+		{name: "unexpired token", header: bearerPrefix + SignToken(secret, "alice", time.Now().Add(time.Hour)), want: "alice"},
+		{name: "expired token", header: bearerPrefix + SignToken(secret, "alice", time.Now().Add(-time.Hour)), wantErr: ErrTokenExpired},
+		{name: "other secret", header: bearerPrefix + SignToken([]byte("other"), "alice", time.Time{}), wantErr: ErrInvalidToken},
+		{name: "no signature", header: bearerPrefix + "e30", wantErr: ErrInvalidToken},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			if test.header != "" {
+				r.Header.Set(headerAuthorization, test.header)
+			}
+
+			identity, err := auth.Authenticate(r)
+			if err != test.wantErr {
+				t.Fatalf("Authenticate() error = %v, want %v", err, test.wantErr)
+			}
+
+			var name string
+			if identity != nil {
+				name = identity.Name
+			}
+
+			if name != test.want {
+				t.Errorf("identity = %q, want %q", name, test.want)
+			}
+		})
+	}
+}
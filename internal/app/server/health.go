@@ -0,0 +1,94 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/kcq/poc-ipblock-pool/pkg/pool"
+)
+
+const (
+	pathHealth = "/healthz"
+	pathReady  = "/readyz"
+)
+
+// Health check names and statuses
+const (
+	CheckStore  = "store"
+	CheckLeader = "leader"
+	CheckPool   = "pool_info"
+
+	CheckStatusPass = "pass"
+	CheckStatusFail = "fail"
+)
+
+// CheckResult is the result of one readiness check
+type CheckResult struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	//Pool is the checked pool (the pool info checks)
+	Pool string `json:"pool,omitempty"`
+	//Detail is the check output (e.g., the Consul leader address)
+	Detail   string `json:"detail,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// HealthReport is the health or the readiness endpoint output
+type HealthReport struct {
+	Status string         `json:"status"`
+	Checks []*CheckResult `json:"checks,omitempty"`
+}
+
+// healthPath returns true for the health endpoints (they don't require authentication)
+func healthPath(path string) bool {
+	return path == pathHealth || path == pathReady
+}
+
+func (a *App) initHealth() {
+	a.router.Get(pathHealth, func(w http.ResponseWriter, r *http.Request) {
+		replyJSON(w, r, &HealthReport{Status: CheckStatusPass}, http.StatusOK, prettyOutput(r))
+	})
+
+	a.router.Get(pathReady, func(w http.ResponseWriter, r *http.Request) {
+		report := a.readiness()
+
+		status := http.StatusOK
+		if report.Status != CheckStatusPass {
+			status = http.StatusServiceUnavailable
+		}
+
+		replyJSON(w, r, report, status, prettyOutput(r))
+	})
+}
+
+// readiness checks the store connectivity, the Consul leader and the pool info of all served pools
+// (the pools share the Store backend, so the store and the leader are checked once)
+func (a *App) readiness() *HealthReport {
+	report := &HealthReport{Status: CheckStatusPass}
+	add := func(result *CheckResult, started time.Time, err error) {
+		result.Status = CheckStatusPass
+		result.Duration = time.Since(started).String()
+		if err != nil {
+			result.Status = CheckStatusFail
+			result.Error = err.Error()
+			report.Status = CheckStatusFail
+		}
+
+		report.Checks = append(report.Checks, result)
+	}
+
+	started := time.Now()
+	add(&CheckResult{Name: CheckStore}, started, a.pm.Ping())
+
+	started = time.Now()
+	leader, err := a.pm.Leader()
+	add(&CheckResult{Name: CheckLeader, Detail: leader}, started, err)
+
+	for _, pm := range append([]*pool.Manager{a.pm}, a.config.Pools...) {
+		started = time.Now()
+		add(&CheckResult{Name: CheckPool, Pool: poolName(pm)}, started, pm.CheckInfo())
+	}
+
+	return report
+}
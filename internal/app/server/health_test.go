@@ -0,0 +1,41 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+func TestHealthz(t *testing.T) {
+	a := &App{router: chi.NewRouter()}
+	a.initHealth()
+
+	w := httptest.NewRecorder()
+	a.router.ServeHTTP(w, httptest.NewRequest("GET", pathHealth, nil))
+
+	var report HealthReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+
+	if w.Code != http.StatusOK || report.Status != CheckStatusPass {
+		t.Errorf("GET %s = %d %+v, want %d with the pass status", pathHealth, w.Code, report, http.StatusOK)
+	}
+}
+
+func TestHealthPath(t *testing.T) {
+	for path, want := range map[string]bool{
+		pathHealth:      true,
+		pathReady:       true,
+		"/metrics":      false,
+		"/healthz/more": false,
+		"/v1/pools":     false,
+	} {
+		if got := healthPath(path); got != want {
+			t.Errorf("healthPath(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestListOptions(t *testing.T) {
+	r := httptest.NewRequest("GET", "/list?prefix=acme:&selector=env%3Dprod&range=169.254.60.0/24"+
+		"&state=grouped&sort=time&cursor=abc&limit=10"+
+		"&created_after=2020-05-17T00:00:00Z&created_before=2020-05-18T00:00:00Z", nil)
+
+	opts, err := listOptions(r)
+	if err != nil {
+		t.Fatalf("listOptions() error = %v", err)
+	}
+
+	for _, test := range []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"KeyPrefix", opts.KeyPrefix, "acme:"},
+		{"Selector", opts.Selector, "env=prod"},
+		{"Range", opts.Range, "169.254.60.0/24"},
+		{"State", opts.State, "grouped"},
+		{"Sort", opts.Sort, "time"},
+		{"Cursor", opts.Cursor, "abc"},
+	} {
+		if test.got != test.want {
+			t.Errorf("%s = %q, want %q", test.name, test.got, test.want)
+		}
+	}
+
+	if opts.Limit != 10 {
+		t.Errorf("Limit = %d, want 10", opts.Limit)
+	}
+
+	if want := time.Date(2020, 5, 17, 0, 0, 0, 0, time.UTC); !opts.CreatedAfter.Equal(want) {
+		t.Errorf("CreatedAfter = %s, want %s", opts.CreatedAfter, want)
+	}
+
+	if want := time.Date(2020, 5, 18, 0, 0, 0, 0, time.UTC); !opts.CreatedBefore.Equal(want) {
+		t.Errorf("CreatedBefore = %s, want %s", opts.CreatedBefore, want)
+	}
+}
+
+func TestListOptionsDefaults(t *testing.T) {
+	opts, err := listOptions(httptest.NewRequest("GET", "/list", nil))
+	if err != nil {
+		t.Fatalf("listOptions() error = %v", err)
+	}
+
+	if opts.Limit != 0 || !opts.CreatedAfter.IsZero() || !opts.CreatedBefore.IsZero() {
+		t.Errorf("listOptions() = %+v, want the zero options", opts)
+	}
+}
+
+func TestListOptionsErrors(t *testing.T) {
+	for _, query := range []string{
+		"limit=ten",
+		"created_after=2020-05-17",
+		"created_before=yesterday",
+	} {
+		if _, err := listOptions(httptest.NewRequest("GET", "/list?"+query, nil)); err == nil {
+			t.Errorf("listOptions(%s) error = nil, want an error", query)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/segmentio/ksuid"
+
+	"github.com/kcq/poc-ipblock-pool/pkg/logging"
+)
+
+const (
+	headerRequestID  = "X-Request-ID"
+	maxRequestIDSize = 128
+)
+
+const (
+	contextKeyLogger contextKey = "logger"
+)
+
+// logRequests assigns the request IDs (the X-Request-ID header value or a new ID),
+// adds the request logger to the request context and logs the completed requests
+func (a *App) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+
+		requestID := r.Header.Get(headerRequestID)
+		if requestID == "" || len(requestID) > maxRequestIDSize {
+			requestID = ksuid.New().String()
+		}
+
+		w.Header().Set(headerRequestID, requestID)
+		logger := a.logger.With("request_id", requestID)
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		ctx := context.WithValue(r.Context(), contextKeyLogger, logger)
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		logger.Info("Request completed",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"route", chi.RouteContext(r.Context()).RoutePattern(),
+			"status", recorder.status,
+			"duration", time.Since(started).String(),
+			"remote", r.RemoteAddr)
+	})
+}
+
+// requestLogger returns the request logger (with the request ID)
+func requestLogger(r *http.Request) logging.Logger {
+	if value, ok := r.Context().Value(contextKeyLogger).(logging.Logger); ok {
+		return value
+	}
+
+	return logging.Nop()
+}
@@ -0,0 +1,279 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/go-chi/chi"
+
+	"github.com/kcq/poc-ipblock-pool/pkg/pool"
+)
+
+const (
+	pathMetrics              = "/metrics"
+	metricHTTPRequests       = "requests"
+	metricHTTPDuration       = "request_duration_ms"
+	metricHTTPErrors         = "errors"
+	metricRouteOther         = "other"
+	prometheusContentType    = "text/plain; version=0.0.4"
+	prometheusCounterSuffix  = "_total"
+	prometheusKindCounter    = "counter"
+	prometheusKindGauge      = "gauge"
+	prometheusKindHistogram  = "histogram"
+	prometheusLabelSeparator = ":"
+)
+
+// PrometheusBuckets are the histogram buckets for the samples (the timers are in milliseconds)
+var PrometheusBuckets = []float64{1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+// PrometheusSink is a go-metrics sink that keeps the metrics for the Prometheus text format output.
+// The key parts with the "<label>:<value>" format are the metric labels and the other key parts make the metric name
+// (the counters get the "_total" suffix and the samples are the histograms).
+type PrometheusSink struct {
+	mu      sync.Mutex
+	metrics map[string]*promMetric
+}
+
+type promMetric struct {
+	name   string
+	kind   string
+	labels string
+	value  float64
+	//buckets are the sample counts for each histogram bucket (not cumulative)
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+// NewPrometheusSink creates a new Prometheus sink
+func NewPrometheusSink() *PrometheusSink {
+	return &PrometheusSink{metrics: map[string]*promMetric{}}
+}
+
+// SetGauge sets the gauge value
+func (s *PrometheusSink) SetGauge(key []string, val float32) {
+	s.update(key, prometheusKindGauge, func(m *promMetric) {
+		m.value = float64(val)
+	})
+}
+
+// EmitKey sets the key value (the keys are gauges)
+func (s *PrometheusSink) EmitKey(key []string, val float32) {
+	s.SetGauge(key, val)
+}
+
+// IncrCounter adds the value to the counter
+func (s *PrometheusSink) IncrCounter(key []string, val float32) {
+	s.update(key, prometheusKindCounter, func(m *promMetric) {
+		m.value += float64(val)
+	})
+}
+
+// AddSample adds the sample to the histogram
+func (s *PrometheusSink) AddSample(key []string, val float32) {
+	s.update(key, prometheusKindHistogram, func(m *promMetric) {
+		if m.buckets == nil {
+			m.buckets = make([]uint64, len(PrometheusBuckets))
+		}
+
+		for i, bound := range PrometheusBuckets {
+			if float64(val) <= bound {
+				m.buckets[i]++
+				break
+			}
+		}
+
+		m.count++
+		m.sum += float64(val)
+	})
+}
+
+func (s *PrometheusSink) update(key []string, kind string, fn func(m *promMetric)) {
+	name, labels := prometheusName(key)
+	if kind == prometheusKindCounter && !strings.HasSuffix(name, prometheusCounterSuffix) {
+		name += prometheusCounterSuffix
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := name + labels
+	m, ok := s.metrics[id]
+	if !ok {
+		m = &promMetric{name: name, kind: kind, labels: labels}
+		s.metrics[id] = m
+	}
+
+	fn(m)
+}
+
+// prometheusName returns the metric name and the rendered labels for the metric key
+func prometheusName(key []string) (string, string) {
+	var names, labels []string
+	for _, part := range key {
+		if i := strings.Index(part, prometheusLabelSeparator); i > 0 {
+			labels = append(labels, fmt.Sprintf("%s=%s", sanitizeName(part[:i]), strconv.Quote(part[i+1:])))
+			continue
+		}
+
+		names = append(names, sanitizeName(part))
+	}
+
+	return strings.Join(names, "_"), strings.Join(labels, ",")
+}
+
+func sanitizeName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			return r
+		}
+
+		return '_'
+	}, name)
+}
+
+// WriteTo writes the metrics in the Prometheus text format
+func (s *PrometheusSink) WriteTo(w io.Writer) (int64, error) {
+	s.mu.Lock()
+	list := make([]promMetric, 0, len(s.metrics))
+	for _, m := range s.metrics {
+		copied := *m
+		copied.buckets = append([]uint64(nil), m.buckets...)
+		list = append(list, copied)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].name != list[j].name {
+			return list[i].name < list[j].name
+		}
+
+		return list[i].labels < list[j].labels
+	})
+
+	var out strings.Builder
+	for i, m := range list {
+		if i == 0 || list[i-1].name != m.name {
+			fmt.Fprintf(&out, "# TYPE %s %s\n", m.name, m.kind)
+		}
+
+		if m.kind != prometheusKindHistogram {
+			fmt.Fprintf(&out, "%s%s %s\n", m.name, withLabels(m.labels, ""), formatValue(m.value))
+			continue
+		}
+
+		var cumulative uint64
+		for i, bound := range PrometheusBuckets {
+			cumulative += m.buckets[i]
+			fmt.Fprintf(&out, "%s_bucket%s %d\n", m.name, withLabels(m.labels, `le="`+formatValue(bound)+`"`), cumulative)
+		}
+
+		fmt.Fprintf(&out, "%s_bucket%s %d\n", m.name, withLabels(m.labels, `le="+Inf"`), m.count)
+		fmt.Fprintf(&out, "%s_sum%s %s\n", m.name, withLabels(m.labels, ""), formatValue(m.sum))
+		fmt.Fprintf(&out, "%s_count%s %d\n", m.name, withLabels(m.labels, ""), m.count)
+	}
+
+	n, err := io.WriteString(w, out.String())
+	return int64(n), err
+}
+
+func withLabels(labels, extra string) string {
+	if extra != "" {
+		if labels != "" {
+			labels += ","
+		}
+
+		labels += extra
+	}
+
+	if labels == "" {
+		return ""
+	}
+
+	return "{" + labels + "}"
+}
+
+func formatValue(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func (a *App) initMetrics() {
+	if a.config.Metrics == nil {
+		return
+	}
+
+	a.router.Get(pathMetrics, func(w http.ResponseWriter, r *http.Request) {
+		//the pool utilization gauges are updated for each scrape
+		//(the gauges of the pools that can't be loaded keep their last values)
+		for _, pm := range append([]*pool.Manager{a.pm}, a.config.Pools...) {
+			if err := pm.EmitStats(); err != nil {
+				requestLogger(r).Warn("Can't update the pool stats", "pool", poolName(pm), "error", err)
+			}
+		}
+
+		w.Header().Set("Content-Type", prometheusContentType)
+		a.config.Metrics.WriteTo(w)
+	})
+}
+
+// instrument counts the requests by the route, the method and the status code and measures their duration
+// (the requests rejected before the routing, e.g., the authentication failures, use the "other" route)
+func instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		route := chi.RouteContext(r.Context()).RoutePattern()
+		if route == "" {
+			route = metricRouteOther
+		}
+
+		labels := []string{pool.MetricLabel("route", route), pool.MetricLabel("method", r.Method)}
+		metrics.IncrCounter(append([]string{"http", metricHTTPRequests, pool.MetricLabel("status", strconv.Itoa(recorder.status))}, labels...), 1)
+		metrics.MeasureSince(append([]string{"http", metricHTTPDuration}, labels...), started)
+	})
+}
+
+// countError counts the API errors by the error code
+func countError(code string) {
+	metrics.IncrCounter([]string{"http", metricHTTPErrors, pool.MetricLabel("code", code)}, 1)
+}
+
+// countPoolError counts the pool errors of the original API requests by their /v1 error codes
+// (the /v1 API requests count them in replyError)
+func countPoolError(err error) {
+	if err == nil {
+		return
+	}
+
+	if info, ok := apiErrors[err]; ok {
+		countError(info.code)
+		return
+	}
+
+	countError(ErrCodeInternal)
+}
+
+// statusRecorder keeps the response status code
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
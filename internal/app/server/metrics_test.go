@@ -0,0 +1,123 @@
+package server
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/armon/go-metrics"
+
+	"github.com/kcq/poc-ipblock-pool/pkg/pool"
+)
+
+func TestPrometheusName(t *testing.T) {
+	tests := []struct {
+		key        []string
+		wantName   string
+		wantLabels string
+	}{
+		{key: []string{"pool", "blocks"}, wantName: "pool_blocks"},
+		{key: []string{"http", "request-duration.ms"}, wantName: "http_request_duration_ms"},
+		{key: []string{"pool", "blocks", "pool:default"}, wantName: "pool_blocks", wantLabels: `pool="default"`},
+		{
+			key:        []string{"http", "requests", "route:/v1/pools/{pool}", "http-method:GET"},
+			wantName:   "http_requests",
+			wantLabels: `route="/v1/pools/{pool}",http_method="GET"`,
+		},
+		{key: []string{"pool", "tenant:a\"b"}, wantName: "pool", wantLabels: `tenant="a\"b"`},
+		//a part that starts with the separator isn't a label
+		{key: []string{"pool", ":free"}, wantName: "pool__free"},
+	}
+
+	for _, test := range tests {
+		name, labels := prometheusName(test.key)
+		if name != test.wantName || labels != test.wantLabels {
+			t.Errorf("prometheusName(%q) = (%s, %s), want (%s, %s)", test.key, name, labels, test.wantName, test.wantLabels)
+		}
+	}
+}
+
+func TestPrometheusSinkWriteTo(t *testing.T) {
+	defer func(buckets []float64) { PrometheusBuckets = buckets }(PrometheusBuckets)
+	PrometheusBuckets = []float64{5, 10}
+
+	sink := NewPrometheusSink()
+	sink.SetGauge([]string{"pool", "free", "pool:b"}, 3)
+	sink.SetGauge([]string{"pool", "free", "pool:a"}, 1)
+	sink.SetGauge([]string{"pool", "free", "pool:a"}, 1.5)
+	sink.EmitKey([]string{"pool", "size"}, 64)
+	sink.IncrCounter([]string{"http", "requests"}, 1)
+	sink.IncrCounter([]string{"http", "requests"}, 2)
+	sink.IncrCounter([]string{"http", "errors_total"}, 1)
+	for _, val := range []float32{3, 7, 20000} {
+		sink.AddSample([]string{"http", "duration", "method:GET"}, val)
+	}
+
+	var out strings.Builder
+	n, err := sink.WriteTo(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := strings.Join([]string{
+		"# TYPE http_duration histogram",
+		`http_duration_bucket{method="GET",le="5"} 1`,
+		`http_duration_bucket{method="GET",le="10"} 2`,
+		`http_duration_bucket{method="GET",le="+Inf"} 3`,
+		`http_duration_sum{method="GET"} 20010`,
+		`http_duration_count{method="GET"} 3`,
+		"# TYPE http_errors_total counter",
+		"http_errors_total 1",
+		"# TYPE http_requests_total counter",
+		"http_requests_total 3",
+		"# TYPE pool_free gauge",
+		`pool_free{pool="a"} 1.5`,
+		`pool_free{pool="b"} 3`,
+		"# TYPE pool_size gauge",
+		"pool_size 64",
+	}, "\n") + "\n"
+
+	if out.String() != want {
+		t.Errorf("WriteTo() output:\n%s\nwant:\n%s", out.String(), want)
+	}
+
+	if n != int64(len(want)) {
+		t.Errorf("WriteTo() = %d, want %d", n, len(want))
+	}
+}
+
+func TestPrometheusSinkEmpty(t *testing.T) {
+	var out strings.Builder
+	if n, err := NewPrometheusSink().WriteTo(&out); n != 0 || err != nil || out.Len() != 0 {
+		t.Errorf("WriteTo() = (%d, %v) with output %q, want no output", n, err, out.String())
+	}
+}
+
+func TestCountPoolError(t *testing.T) {
+	sink := NewPrometheusSink()
+	config := metrics.DefaultConfig("")
+	config.EnableHostname = false
+	config.EnableRuntimeMetrics = false
+	if _, err := metrics.NewGlobal(config, sink); err != nil {
+		t.Fatal(err)
+	}
+	defer metrics.NewGlobal(config, &metrics.BlackholeSink{})
+
+	for _, err := range []error{nil, pool.ErrPoolExhausted, pool.ErrPoolExhausted, errors.New("store failure")} {
+		countPoolError(err)
+	}
+
+	var out strings.Builder
+	if _, err := sink.WriteTo(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		`http_errors_total{code="pool_exhausted"} 2`,
+		`http_errors_total{code="internal_error"} 1`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("WriteTo() output:\n%s\nwant %s", out.String(), want)
+		}
+	}
+}
@@ -2,12 +2,16 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-chi/chi"
 
+	"github.com/kcq/poc-ipblock-pool/pkg/logging"
 	"github.com/kcq/poc-ipblock-pool/pkg/pool"
 )
 
@@ -17,19 +21,134 @@ const (
 	paramPretty        = "pretty"
 	paramBlock         = "block"
 	paramKey           = "key"
+	paramAddress       = "address"
+	paramIP            = "ip"
+	paramStart         = "start"
+	paramEnd           = "end"
+	paramDryRun        = "dryrun"
+	paramCIDR          = "cidr"
+	paramName          = "name"
+	paramSize          = "size"
+	paramBlockSize     = "blocksize"
+	paramTenant        = "tenant"
+	paramBlocks        = "blocks"
+	paramAddresses     = "addresses"
+	paramNamespace     = "namespace"
+	paramID            = "id"
+	paramCount         = "count"
+	paramLabels        = "labels"
+	paramPrefix        = "prefix"
+	paramSelector      = "selector"
+	paramRange         = "range"
+	paramState         = "state"
+	paramAfter         = "created_after"
+	paramBefore        = "created_before"
+	paramSort          = "sort"
+	paramCursor        = "cursor"
+	paramLimit         = "limit"
+	paramDescription   = "description"
+	paramOverride      = "override"
+	headerAPIKey       = "X-API-Key"
+	headerHolderToken  = "X-Holder-Token"
+	headerETag         = "ETag"
+	headerIfMatch      = "If-Match"
 	pathPoolAllocation = "/pool/allocation"
+	pathPoolBlock      = "/pool/allocation/{block}"
+	pathPoolList       = "/pool/allocations"
+	pathPoolAddresses  = "/pool/allocation/{block}/addresses"
+	pathPoolBlockGrow  = "/pool/allocation/{block}/grow"
+	pathPoolBlockSplit = "/pool/allocation/{block}/split"
+	pathPoolWhois      = "/pool/whois"
+	pathPoolStats      = "/pool/stats"
+	pathPoolRange      = "/pool/range"
+	pathPoolRangeOp    = "/pool/range/{op}"
+	pathPoolChildren   = "/pool/children"
+	pathPoolChild      = "/pool/children/{name}"
+	pathPoolChildOp    = "/pool/children/{name}/shrink"
+	pathPoolQuotas     = "/pool/quotas"
+	pathPoolQuota      = "/pool/quotas/{tenant}"
+	pathPoolBlocks     = "/pool/blocks"
+	pathPoolPair       = "/pool/pair"
+	pathPoolGroups     = "/pool/groups"
+	pathPoolDefrag     = "/pool/defrag"
 )
 
+type contextKey string
+
+const (
+	contextKeyAccess contextKey = "access"
+	//namespaceAny is the credential namespace value for the unrestricted credentials
+	namespaceAny = "*"
+)
+
+// Config contains the server app configurations
+type Config struct {
+	//Credentials maps the API keys (passed in the X-API-Key header) to the namespaces they can access
+	//("*" means all namespaces and the pool management APIs)
+	Credentials map[string]string
+	//Auth contains the authentication methods and the role bindings
+	//(no Auth, Authenticators and Credentials means no authentication)
+	Auth *AuthConfig
+	//Authenticators are the custom authenticators (they are used before the Auth authenticators)
+	Authenticators []Authenticator
+	//Pair is the pool pair for the dual-stack allocations (the pair APIs are disabled if it's nil)
+	Pair *pool.Pair
+	//Pools are the additional pools served by the /v1 API (selected by their names)
+	Pools []*pool.Manager
+	//Addresses are the listen addresses (the default is ":5555")
+	Addresses []string
+	//TLS enables HTTPS on all listen addresses
+	TLS *TLSConfig
+	//Metrics is the metrics sink served on /metrics (the endpoint is disabled if it's nil;
+	//the sink must be one of the global go-metrics sinks)
+	Metrics *PrometheusSink
+	//Logger is the server logger (the server is silent if it's nil)
+	Logger logging.Logger
+}
+
+// access describes what the request credentials can access
+type access struct {
+	//identity is the authenticated caller (nil if the authentication is disabled)
+	identity   *Identity
+	namespace  string
+	restricted bool
+	//admin is true if the caller can use the pool management APIs
+	admin bool
+	//holder is the block holder token presented by the request
+	holder string
+	//override is true if the request frees or changes the blocks without the holder tokens
+	override bool
+}
+
 // App represents the server app
 type App struct {
-	pm     *pool.Manager
-	router *chi.Mux
+	pm             *pool.Manager
+	config         *Config
+	router         *chi.Mux
+	authenticators []Authenticator
+	bindings       []*RoleBinding
+	logger         logging.Logger
 }
 
 // New creates a new server app
 func New(pmanager *pool.Manager) *App {
+	return NewWithConfig(pmanager, nil)
+}
+
+// NewWithConfig creates a new server app with the provided configurations
+func NewWithConfig(pmanager *pool.Manager, config *Config) *App {
+	if config == nil {
+		config = &Config{}
+	}
+
 	app := &App{
-		pm: pmanager,
+		pm:     pmanager,
+		config: config,
+		logger: config.Logger,
+	}
+
+	if app.logger == nil {
+		app.logger = logging.Nop()
 	}
 
 	app.init()
@@ -37,7 +156,14 @@ func New(pmanager *pool.Manager) *App {
 }
 
 func (a *App) init() {
+	a.initAuth()
+
 	a.router = chi.NewRouter()
+	a.router.Use(instrument)
+	a.router.Use(a.logRequests)
+	a.router.Use(a.authenticate)
+	a.initHealth()
+	a.initMetrics()
 
 	a.router.Get(pathPoolAllocation, func(w http.ResponseWriter, r *http.Request) {
 		pretty := false
@@ -55,11 +181,13 @@ func (a *App) init() {
 			key = r.URL.Query().Get(paramKey)
 		}
 
-		blockInfo := a.pm.Lookup(block, key)
+		blockInfo := a.poolFor(r).Lookup(block, key)
 
 		if blockInfo == nil {
+			countError(ErrCodeBlockNotFound)
 			reply(w, r, http.StatusNotFound)
 		} else {
+			w.Header().Set(headerETag, etag(blockInfo))
 			replyJSON(w, r, blockInfo, http.StatusOK, pretty)
 		}
 	})
@@ -80,10 +208,33 @@ func (a *App) init() {
 			key = r.URL.Query().Get(paramKey)
 		}
 
-		blockInfo := a.pm.Allocate(key, delayUnlock)
+		labels, err := pool.ParseLabels(r.URL.Query().Get(paramLabels))
+		if err != nil {
+			countError(ErrCodeInvalidLabels)
+			reply(w, r, http.StatusBadRequest)
+			return
+		}
 
-		replyJSON(w, r, blockInfo, http.StatusOK, pretty)
+		blockInfo, err := a.poolFor(r).AllocateWith(&pool.AllocationRequest{
+			Key:         key,
+			Tenant:      r.URL.Query().Get(paramTenant),
+			Labels:      labels,
+			Description: r.URL.Query().Get(paramDescription),
+			Owner:       requestAccess(r).owner(),
+			DelayUnlock: delayUnlock,
+		})
 
+		countPoolError(err)
+		switch err {
+		case pool.ErrPoolExhausted:
+			reply(w, r, http.StatusConflict)
+		case pool.ErrQuotaExceeded:
+			reply(w, r, http.StatusForbidden)
+		case nil:
+			replyJSON(w, r, blockInfo, http.StatusOK, pretty)
+		default:
+			reply(w, r, http.StatusInternalServerError)
+		}
 	})
 
 	a.router.Delete(pathPoolAllocation, func(w http.ResponseWriter, r *http.Request) {
@@ -97,11 +248,732 @@ func (a *App) init() {
 			block = r.URL.Query().Get(paramBlock)
 		}
 
-		err := a.pm.Free(block, key)
+		err := a.poolFor(r).Free(block, key)
+
+		countPoolError(err)
+		switch err {
+		case pool.ErrBlockNotFound:
+			reply(w, r, http.StatusNotFound)
+		case pool.ErrHolderRequired:
+			reply(w, r, http.StatusUnauthorized)
+		case pool.ErrHolderMismatch:
+			reply(w, r, http.StatusForbidden)
+		case pool.ErrBlockDelegated, pool.ErrBlockPaired, pool.ErrBlockGrouped:
+			reply(w, r, http.StatusConflict)
+		case nil:
+			reply(w, r, http.StatusNoContent)
+		default:
+			reply(w, r, http.StatusInternalServerError)
+		}
+	})
+
+	a.router.Patch(pathPoolBlock, func(w http.ResponseWriter, r *http.Request) {
+		pretty := false
+		if strings.ToLower(r.URL.Query().Get(paramPretty)) == "true" {
+			pretty = true
+		}
+
+		if r.Header.Get(headerIfMatch) == "" {
+			countError(ErrCodeNoPrecondition)
+			reply(w, r, http.StatusPreconditionRequired)
+			return
+		}
+
+		version, err := ifMatchVersion(r)
+		if err != nil {
+			countError(ErrCodeBadRequest)
+			reply(w, r, http.StatusBadRequest)
+			return
+		}
+
+		//only the fields present in the request are changed
+		query := r.URL.Query()
+		update := &pool.BlockUpdate{}
+		if _, ok := query[paramKey]; ok {
+			key := query.Get(paramKey)
+			update.Key = &key
+		}
+
+		if _, ok := query[paramLabels]; ok {
+			if update.Labels, err = pool.ParseLabels(query.Get(paramLabels)); err != nil {
+				countError(ErrCodeInvalidLabels)
+				reply(w, r, http.StatusBadRequest)
+				return
+			}
+		}
+
+		if _, ok := query[paramDescription]; ok {
+			description := query.Get(paramDescription)
+			update.Description = &description
+		}
+
+		blockInfo, err := a.poolFor(r).UpdateBlock(chi.URLParam(r, paramBlock), "", version, update)
+
+		countPoolError(err)
+		switch err {
+		case pool.ErrBlockNotFound:
+			reply(w, r, http.StatusNotFound)
+		case pool.ErrHolderRequired:
+			reply(w, r, http.StatusUnauthorized)
+		case pool.ErrHolderMismatch:
+			reply(w, r, http.StatusForbidden)
+		case pool.ErrVersionMismatch:
+			reply(w, r, http.StatusPreconditionFailed)
+		case pool.ErrKeyInUse, pool.ErrBlockDelegated, pool.ErrBlockPaired, pool.ErrBlockGrouped:
+			reply(w, r, http.StatusConflict)
+		case pool.ErrInvalidLabels:
+			reply(w, r, http.StatusBadRequest)
+		case nil:
+			w.Header().Set(headerETag, etag(blockInfo))
+			replyJSON(w, r, blockInfo, http.StatusOK, pretty)
+		default:
+			reply(w, r, http.StatusInternalServerError)
+		}
+	})
+
+	a.router.Post(pathPoolBlockGrow, func(w http.ResponseWriter, r *http.Request) {
+		pretty := false
+		if strings.ToLower(r.URL.Query().Get(paramPretty)) == "true" {
+			pretty = true
+		}
+
+		blockInfo, err := a.poolFor(r).GrowBlock(chi.URLParam(r, paramBlock), "")
+
+		countPoolError(err)
+		switch err {
+		case pool.ErrBlockNotFound:
+			reply(w, r, http.StatusNotFound)
+		case pool.ErrHolderRequired:
+			reply(w, r, http.StatusUnauthorized)
+		case pool.ErrHolderMismatch:
+			reply(w, r, http.StatusForbidden)
+		case pool.ErrBlockDelegated, pool.ErrBlockPaired, pool.ErrBlockGrouped, pool.ErrGrowBlocked:
+			reply(w, r, http.StatusConflict)
+		case pool.ErrInvalidSize:
+			reply(w, r, http.StatusBadRequest)
+		case pool.ErrQuotaExceeded:
+			reply(w, r, http.StatusForbidden)
+		case nil:
+			replyJSON(w, r, blockInfo, http.StatusOK, pretty)
+		default:
+			reply(w, r, http.StatusInternalServerError)
+		}
+	})
+
+	a.router.Post(pathPoolBlockSplit, func(w http.ResponseWriter, r *http.Request) {
+		pretty := false
+		if strings.ToLower(r.URL.Query().Get(paramPretty)) == "true" {
+			pretty = true
+		}
+
+		size, err := strconv.ParseInt(r.URL.Query().Get(paramSize), 10, 64)
+		if err != nil {
+			countError(ErrCodeInvalidSize)
+			reply(w, r, http.StatusBadRequest)
+			return
+		}
+
+		blocks, err := a.poolFor(r).SplitBlock(chi.URLParam(r, paramBlock), "", size)
+
+		countPoolError(err)
+		switch err {
+		case pool.ErrBlockNotFound:
+			reply(w, r, http.StatusNotFound)
+		case pool.ErrHolderRequired:
+			reply(w, r, http.StatusUnauthorized)
+		case pool.ErrHolderMismatch:
+			reply(w, r, http.StatusForbidden)
+		case pool.ErrBlockDelegated, pool.ErrBlockPaired, pool.ErrBlockGrouped:
+			reply(w, r, http.StatusConflict)
+		case pool.ErrInvalidSize:
+			reply(w, r, http.StatusBadRequest)
+		case pool.ErrQuotaExceeded:
+			reply(w, r, http.StatusForbidden)
+		case nil:
+			replyJSON(w, r, blocks, http.StatusOK, pretty)
+		default:
+			reply(w, r, http.StatusInternalServerError)
+		}
+	})
+
+	a.router.Get(pathPoolAddresses, func(w http.ResponseWriter, r *http.Request) {
+		pretty := false
+		if strings.ToLower(r.URL.Query().Get(paramPretty)) == "true" {
+			pretty = true
+		}
+
+		block := chi.URLParam(r, paramBlock)
+		address := r.URL.Query().Get(paramAddress)
+		key := r.URL.Query().Get(paramKey)
+
+		addressInfo := a.poolFor(r).LookupAddress(block, address, key)
+
+		if addressInfo == nil {
+			countError(ErrCodeAddressNotFound)
+			reply(w, r, http.StatusNotFound)
+		} else {
+			replyJSON(w, r, addressInfo, http.StatusOK, pretty)
+		}
+	})
+
+	a.router.Post(pathPoolAddresses, func(w http.ResponseWriter, r *http.Request) {
+		pretty := false
+		if strings.ToLower(r.URL.Query().Get(paramPretty)) == "true" {
+			pretty = true
+		}
+
+		block := chi.URLParam(r, paramBlock)
+		key := r.URL.Query().Get(paramKey)
+
+		addressInfo, err := a.poolFor(r).AllocateAddress(block, key)
+
+		countPoolError(err)
+		switch err {
+		case pool.ErrBlockNotFound:
+			reply(w, r, http.StatusNotFound)
+		case pool.ErrBlockExhausted, pool.ErrBlockDelegated:
+			reply(w, r, http.StatusConflict)
+		case pool.ErrHolderRequired:
+			reply(w, r, http.StatusUnauthorized)
+		case pool.ErrHolderMismatch:
+			reply(w, r, http.StatusForbidden)
+		case nil:
+			replyJSON(w, r, addressInfo, http.StatusOK, pretty)
+		default:
+			reply(w, r, http.StatusInternalServerError)
+		}
+	})
+
+	a.router.Delete(pathPoolAddresses, func(w http.ResponseWriter, r *http.Request) {
+		block := chi.URLParam(r, paramBlock)
+		address := r.URL.Query().Get(paramAddress)
+		key := r.URL.Query().Get(paramKey)
+
+		err := a.poolFor(r).FreeAddress(block, address, key)
+
+		countPoolError(err)
+		switch err {
+		case pool.ErrBlockNotFound, pool.ErrAddressNotFound:
+			reply(w, r, http.StatusNotFound)
+		case pool.ErrHolderRequired:
+			reply(w, r, http.StatusUnauthorized)
+		case pool.ErrHolderMismatch:
+			reply(w, r, http.StatusForbidden)
+		case nil:
+			reply(w, r, http.StatusNoContent)
+		default:
+			reply(w, r, http.StatusInternalServerError)
+		}
+	})
+
+	a.router.Get(pathPoolWhois, func(w http.ResponseWriter, r *http.Request) {
+		pretty := false
+		if strings.ToLower(r.URL.Query().Get(paramPretty)) == "true" {
+			pretty = true
+		}
+
+		whoisInfo, err := a.poolFor(r).Whois(r.URL.Query().Get(paramIP))
+
+		countPoolError(err)
+		switch err {
+		case pool.ErrInvalidIP:
+			reply(w, r, http.StatusBadRequest)
+		case nil:
+			replyJSON(w, r, whoisInfo, http.StatusOK, pretty)
+		default:
+			reply(w, r, http.StatusInternalServerError)
+		}
+	})
+
+	a.router.Get(pathPoolStats, func(w http.ResponseWriter, r *http.Request) {
+		pretty := false
+		if strings.ToLower(r.URL.Query().Get(paramPretty)) == "true" {
+			pretty = true
+		}
+
+		if access := requestAccess(r); access.restricted || r.URL.Query().Get(paramNamespace) != "" {
+			stats, err := a.poolFor(r).NamespaceStats()
+			if err != nil {
+				replyPoolError(w, r, err)
+				return
+			}
+
+			replyJSON(w, r, stats, http.StatusOK, pretty)
+			return
+		}
+
+		stats, err := a.poolFor(r).Stats()
+		if err != nil {
+			replyPoolError(w, r, err)
+			return
+		}
+
+		replyJSON(w, r, stats, http.StatusOK, pretty)
+	})
+
+	a.router.Get(pathPoolList, func(w http.ResponseWriter, r *http.Request) {
+		pretty := false
+		if strings.ToLower(r.URL.Query().Get(paramPretty)) == "true" {
+			pretty = true
+		}
+
+		opts, err := listOptions(r)
+		if err != nil {
+			countError(ErrCodeInvalidList)
+			reply(w, r, http.StatusBadRequest)
+			return
+		}
+
+		page, err := a.poolFor(r).List(opts)
+
+		countPoolError(err)
+		switch err {
+		case pool.ErrInvalidListOptions, pool.ErrInvalidCursor, pool.ErrInvalidSelector:
+			reply(w, r, http.StatusBadRequest)
+		case nil:
+			replyJSON(w, r, page, http.StatusOK, pretty)
+		default:
+			reply(w, r, http.StatusInternalServerError)
+		}
+	})
+
+	a.router.Get(pathPoolBlocks, func(w http.ResponseWriter, r *http.Request) {
+		pretty := false
+		if strings.ToLower(r.URL.Query().Get(paramPretty)) == "true" {
+			pretty = true
+		}
+
+		replyJSON(w, r, a.poolFor(r).Blocks(), http.StatusOK, pretty)
+	})
+
+	a.router.Get(pathPoolRange, func(w http.ResponseWriter, r *http.Request) {
+		pretty := false
+		if strings.ToLower(r.URL.Query().Get(paramPretty)) == "true" {
+			pretty = true
+		}
+
+		ranges, err := a.poolFor(r).Ranges()
+		if err != nil {
+			replyPoolError(w, r, err)
+			return
+		}
+
+		replyJSON(w, r, ranges, http.StatusOK, pretty)
+	})
+
+	a.router.With(a.adminOnly).Post(pathPoolRangeOp, func(w http.ResponseWriter, r *http.Request) {
+		pretty := false
+		if strings.ToLower(r.URL.Query().Get(paramPretty)) == "true" {
+			pretty = true
+		}
+
+		dryRun := false
+		if strings.ToLower(r.URL.Query().Get(paramDryRun)) == "true" {
+			dryRun = true
+		}
+
+		start := r.URL.Query().Get(paramStart)
+		end := r.URL.Query().Get(paramEnd)
+
+		var change *pool.RangeChange
+		var err error
+		switch chi.URLParam(r, "op") {
+		case pool.RangeOpExtend:
+			change, err = a.pm.ExtendRange(end, dryRun)
+		case pool.RangeOpAdd:
+			if cidr := r.URL.Query().Get(paramCIDR); cidr != "" {
+				start, end = cidr, ""
+			}
+
+			change, err = a.pm.AddRange(start, end, dryRun)
+		case pool.RangeOpShrink:
+			change, err = a.pm.ShrinkRange(end, dryRun)
+		default:
+			countError(ErrCodeNotFound)
+			reply(w, r, http.StatusNotFound)
+			return
+		}
+
+		countPoolError(err)
+		switch err {
+		case pool.ErrInvalidRange:
+			reply(w, r, http.StatusBadRequest)
+		case pool.ErrRangeOverlap, pool.ErrRangeInUse, pool.ErrChildPoolRange:
+			reply(w, r, http.StatusConflict)
+		case nil:
+			replyJSON(w, r, change, http.StatusOK, pretty)
+		default:
+			reply(w, r, http.StatusInternalServerError)
+		}
+	})
+
+	a.router.With(a.adminOnly).Get(pathPoolChildren, func(w http.ResponseWriter, r *http.Request) {
+		pretty := false
+		if strings.ToLower(r.URL.Query().Get(paramPretty)) == "true" {
+			pretty = true
+		}
+
+		replyJSON(w, r, a.pm.Children(), http.StatusOK, pretty)
+	})
+
+	a.router.With(a.adminOnly).Post(pathPoolChildren, func(w http.ResponseWriter, r *http.Request) {
+		pretty := false
+		if strings.ToLower(r.URL.Query().Get(paramPretty)) == "true" {
+			pretty = true
+		}
+
+		size, err := strconv.ParseInt(r.URL.Query().Get(paramSize), 10, 64)
+		if err != nil {
+			countError(ErrCodeInvalidSize)
+			reply(w, r, http.StatusBadRequest)
+			return
+		}
+
+		config := &pool.Config{
+			Name: r.URL.Query().Get(paramName),
+		}
+
+		if value := r.URL.Query().Get(paramBlockSize); value != "" {
+			if config.PoolBlockSize, err = strconv.ParseInt(value, 10, 64); err != nil {
+				countError(ErrCodeInvalidSize)
+				reply(w, r, http.StatusBadRequest)
+				return
+			}
+		}
+
+		child, err := a.pm.CreateChild(config, size)
+
+		countPoolError(err)
+		switch err {
+		case pool.ErrInvalidPoolName, pool.ErrInvalidSize:
+			reply(w, r, http.StatusBadRequest)
+		case pool.ErrPoolExists, pool.ErrPoolExhausted:
+			reply(w, r, http.StatusConflict)
+		case nil:
+			info, err := child.Info()
+			if err != nil {
+				replyPoolError(w, r, err)
+				return
+			}
+
+			replyJSON(w, r, info, http.StatusOK, pretty)
+		default:
+			reply(w, r, http.StatusInternalServerError)
+		}
+	})
+
+	a.router.With(a.adminOnly).Delete(pathPoolChild, func(w http.ResponseWriter, r *http.Request) {
+		err := a.pm.DeleteChild(chi.URLParam(r, paramName))
+
+		countPoolError(err)
+		switch err {
+		case pool.ErrPoolNotFound:
+			reply(w, r, http.StatusNotFound)
+		case pool.ErrPoolInUse:
+			reply(w, r, http.StatusConflict)
+		case nil:
+			reply(w, r, http.StatusNoContent)
+		default:
+			reply(w, r, http.StatusInternalServerError)
+		}
+	})
+
+	a.router.With(a.adminOnly).Post(pathPoolChildOp, func(w http.ResponseWriter, r *http.Request) {
+		pretty := false
+		if strings.ToLower(r.URL.Query().Get(paramPretty)) == "true" {
+			pretty = true
+		}
+
+		dryRun := false
+		if strings.ToLower(r.URL.Query().Get(paramDryRun)) == "true" {
+			dryRun = true
+		}
+
+		size, err := strconv.ParseInt(r.URL.Query().Get(paramSize), 10, 64)
+		if err != nil {
+			countError(ErrCodeInvalidSize)
+			reply(w, r, http.StatusBadRequest)
+			return
+		}
+
+		change, err := a.pm.ShrinkChild(chi.URLParam(r, paramName), size, dryRun)
+
+		countPoolError(err)
+		switch err {
+		case pool.ErrPoolNotFound:
+			reply(w, r, http.StatusNotFound)
+		case pool.ErrInvalidSize, pool.ErrInvalidRange:
+			reply(w, r, http.StatusBadRequest)
+		case pool.ErrRangeInUse:
+			reply(w, r, http.StatusConflict)
+		case nil:
+			replyJSON(w, r, change, http.StatusOK, pretty)
+		default:
+			reply(w, r, http.StatusInternalServerError)
+		}
+	})
+
+	a.router.With(a.adminOnly).Get(pathPoolDefrag, func(w http.ResponseWriter, r *http.Request) {
+		pretty := false
+		if strings.ToLower(r.URL.Query().Get(paramPretty)) == "true" {
+			pretty = true
+		}
 
+		size, err := strconv.ParseInt(r.URL.Query().Get(paramSize), 10, 64)
+		if err != nil {
+			countError(ErrCodeInvalidSize)
+			reply(w, r, http.StatusBadRequest)
+			return
+		}
+
+		plan, err := a.poolFor(r).PlanDefrag(size)
+
+		countPoolError(err)
+		switch err {
+		case pool.ErrInvalidSize:
+			reply(w, r, http.StatusBadRequest)
+		case pool.ErrDefragNotPossible:
+			reply(w, r, http.StatusConflict)
+		case nil:
+			replyJSON(w, r, plan, http.StatusOK, pretty)
+		default:
+			replyPoolError(w, r, err)
+		}
+	})
+
+	a.router.With(a.adminOnly).Get(pathPoolQuotas, func(w http.ResponseWriter, r *http.Request) {
+		pretty := false
+		if strings.ToLower(r.URL.Query().Get(paramPretty)) == "true" {
+			pretty = true
+		}
+
+		quotas, err := a.poolFor(r).Quotas()
+		if err != nil {
+			replyPoolError(w, r, err)
+			return
+		}
+
+		replyJSON(w, r, quotas, http.StatusOK, pretty)
+	})
+
+	a.router.With(a.adminOnly).Get(pathPoolQuota, func(w http.ResponseWriter, r *http.Request) {
+		pretty := false
+		if strings.ToLower(r.URL.Query().Get(paramPretty)) == "true" {
+			pretty = true
+		}
+
+		usage, err := a.poolFor(r).Quota(chi.URLParam(r, paramTenant))
+		if err != nil {
+			replyPoolError(w, r, err)
+			return
+		}
+
+		replyJSON(w, r, usage, http.StatusOK, pretty)
+	})
+
+	a.router.With(a.adminOnly).Put(pathPoolQuota, func(w http.ResponseWriter, r *http.Request) {
+		pretty := false
+		if strings.ToLower(r.URL.Query().Get(paramPretty)) == "true" {
+			pretty = true
+		}
+
+		quota := &pool.Quota{
+			Tenant: chi.URLParam(r, paramTenant),
+		}
+
+		var err error
+		if value := r.URL.Query().Get(paramBlocks); value != "" {
+			if quota.MaxBlocks, err = strconv.ParseInt(value, 10, 64); err != nil {
+				countError(ErrCodeInvalidQuota)
+				reply(w, r, http.StatusBadRequest)
+				return
+			}
+		}
+
+		if value := r.URL.Query().Get(paramAddresses); value != "" {
+			if quota.MaxAddresses, err = strconv.ParseInt(value, 10, 64); err != nil {
+				countError(ErrCodeInvalidQuota)
+				reply(w, r, http.StatusBadRequest)
+				return
+			}
+		}
+
+		err = a.pm.SetQuota(quota)
+
+		countPoolError(err)
+		switch err {
+		case pool.ErrInvalidQuota:
+			reply(w, r, http.StatusBadRequest)
+		case nil:
+			usage, err := a.poolFor(r).Quota(quota.Tenant)
+			if err != nil {
+				replyPoolError(w, r, err)
+				return
+			}
+
+			replyJSON(w, r, usage, http.StatusOK, pretty)
+		default:
+			reply(w, r, http.StatusInternalServerError)
+		}
+	})
+
+	a.router.With(a.adminOnly).Delete(pathPoolQuota, func(w http.ResponseWriter, r *http.Request) {
+		err := a.pm.RemoveQuota(chi.URLParam(r, paramTenant))
+
+		countPoolError(err)
+		switch err {
+		case pool.ErrQuotaNotFound:
+			reply(w, r, http.StatusNotFound)
+		case nil:
+			reply(w, r, http.StatusNoContent)
+		default:
+			reply(w, r, http.StatusInternalServerError)
+		}
+	})
+
+	a.router.Get(pathPoolGroups, func(w http.ResponseWriter, r *http.Request) {
+		pretty := false
+		if strings.ToLower(r.URL.Query().Get(paramPretty)) == "true" {
+			pretty = true
+		}
+
+		groupInfo := a.poolFor(r).LookupGroup(
+			r.URL.Query().Get(paramID),
+			r.URL.Query().Get(paramKey),
+			r.URL.Query().Get(paramAddress))
+
+		if groupInfo == nil {
+			countError(ErrCodeGroupNotFound)
+			reply(w, r, http.StatusNotFound)
+		} else {
+			replyJSON(w, r, groupInfo, http.StatusOK, pretty)
+		}
+	})
+
+	a.router.Post(pathPoolGroups, func(w http.ResponseWriter, r *http.Request) {
+		pretty := false
+		if strings.ToLower(r.URL.Query().Get(paramPretty)) == "true" {
+			pretty = true
+		}
+
+		count, err := strconv.ParseInt(r.URL.Query().Get(paramCount), 10, 64)
+		if err != nil {
+			countError(ErrCodeBadRequest)
+			reply(w, r, http.StatusBadRequest)
+			return
+		}
+
+		groupInfo, err := a.poolFor(r).AllocateGroup(&pool.AllocationRequest{
+			Key:    r.URL.Query().Get(paramKey),
+			Tenant: r.URL.Query().Get(paramTenant),
+			Owner:  requestAccess(r).owner(),
+		}, count)
+
+		countPoolError(err)
+		switch err {
+		case pool.ErrInvalidGroupSize:
+			reply(w, r, http.StatusBadRequest)
+		case pool.ErrNoContiguousRun:
+			reply(w, r, http.StatusConflict)
+		case pool.ErrQuotaExceeded:
+			reply(w, r, http.StatusForbidden)
+		case nil:
+			replyJSON(w, r, groupInfo, http.StatusOK, pretty)
+		default:
+			reply(w, r, http.StatusInternalServerError)
+		}
+	})
+
+	a.router.Delete(pathPoolGroups, func(w http.ResponseWriter, r *http.Request) {
+		err := a.poolFor(r).FreeGroup(
+			r.URL.Query().Get(paramID),
+			r.URL.Query().Get(paramKey),
+			r.URL.Query().Get(paramAddress))
+
+		countPoolError(err)
+		switch err {
+		case pool.ErrGroupNotFound:
+			reply(w, r, http.StatusNotFound)
+		case pool.ErrHolderRequired:
+			reply(w, r, http.StatusUnauthorized)
+		case pool.ErrHolderMismatch:
+			reply(w, r, http.StatusForbidden)
+		case nil:
+			reply(w, r, http.StatusNoContent)
+		default:
+			reply(w, r, http.StatusInternalServerError)
+		}
+	})
+
+	if a.config.Pair != nil {
+		a.initPair()
+	}
+
+	a.initV1()
+}
+
+func (a *App) initPair() {
+	a.router.Get(pathPoolPair, func(w http.ResponseWriter, r *http.Request) {
+		pretty := false
+		if strings.ToLower(r.URL.Query().Get(paramPretty)) == "true" {
+			pretty = true
+		}
+
+		pairInfo := a.pairFor(r).Lookup(r.URL.Query().Get(paramAddress), r.URL.Query().Get(paramKey))
+
+		if pairInfo == nil {
+			countError(ErrCodeNotFound)
+			reply(w, r, http.StatusNotFound)
+		} else {
+			replyJSON(w, r, pairInfo, http.StatusOK, pretty)
+		}
+	})
+
+	a.router.Post(pathPoolPair, func(w http.ResponseWriter, r *http.Request) {
+		pretty := false
+		if strings.ToLower(r.URL.Query().Get(paramPretty)) == "true" {
+			pretty = true
+		}
+
+		labels, err := pool.ParseLabels(r.URL.Query().Get(paramLabels))
+		if err != nil {
+			countError(ErrCodeInvalidLabels)
+			reply(w, r, http.StatusBadRequest)
+			return
+		}
+
+		pairInfo, err := a.pairFor(r).Allocate(&pool.AllocationRequest{
+			Key:         r.URL.Query().Get(paramKey),
+			Tenant:      r.URL.Query().Get(paramTenant),
+			Labels:      labels,
+			Description: r.URL.Query().Get(paramDescription),
+			Owner:       requestAccess(r).owner(),
+		})
+
+		countPoolError(err)
+		switch err {
+		case pool.ErrPoolExhausted, pool.ErrPairMismatch:
+			reply(w, r, http.StatusConflict)
+		case pool.ErrQuotaExceeded:
+			reply(w, r, http.StatusForbidden)
+		case nil:
+			replyJSON(w, r, pairInfo, http.StatusOK, pretty)
+		default:
+			reply(w, r, http.StatusInternalServerError)
+		}
+	})
+
+	a.router.Delete(pathPoolPair, func(w http.ResponseWriter, r *http.Request) {
+		err := a.pairFor(r).Free(r.URL.Query().Get(paramAddress), r.URL.Query().Get(paramKey))
+
+		countPoolError(err)
 		switch err {
 		case pool.ErrBlockNotFound:
 			reply(w, r, http.StatusNotFound)
+		case pool.ErrHolderRequired:
+			reply(w, r, http.StatusUnauthorized)
+		case pool.ErrHolderMismatch:
+			reply(w, r, http.StatusForbidden)
 		case nil:
 			reply(w, r, http.StatusNoContent)
 		default:
@@ -110,11 +982,195 @@ func (a *App) init() {
 	})
 }
 
-// Run starts the HTTP server app execution
-func (a *App) Run() {
-	if err := http.ListenAndServe(serverAddr, a.router); err != nil {
+// authenticate identifies the request caller and checks its role in the request pool and namespace
+// (the callers restricted to some namespaces use their first namespace if the request doesn't select one;
+// the admins can override the block holder tokens with the override parameter;
+// the health endpoints and the metrics endpoint are public, so the probes and the scrapers don't need credentials)
+func (a *App) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if healthPath(r.URL.Path) || r.URL.Path == pathMetrics {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		reqAccess := &access{namespace: r.URL.Query().Get(paramNamespace), admin: true}
+
+		if len(a.authenticators) > 0 {
+			identity, err := a.identify(r)
+			if err != nil {
+				requestLogger(r).Warn("Authentication failed", "error", err)
+				replyError(w, r, http.StatusUnauthorized, ErrCodeUnauthorized, err.Error())
+				return
+			}
+
+			if identity == nil {
+				replyError(w, r, http.StatusUnauthorized, ErrCodeUnauthorized, "Missing credentials")
+				return
+			}
+
+			if !a.authorize(reqAccess, identity, r) {
+				requestLogger(r).Warn("Authorization failed", "identity", identity.Name, "method", identity.Method)
+				replyError(w, r, http.StatusForbidden, ErrCodeForbidden, "Identity can't access the pool or the namespace")
+				return
+			}
+		}
+
+		reqAccess.holder = r.Header.Get(headerHolderToken)
+		if strings.ToLower(r.URL.Query().Get(paramOverride)) == "true" {
+			if !reqAccess.admin {
+				replyError(w, r, http.StatusForbidden, ErrCodeForbidden, "Identity can't override the block holder")
+				return
+			}
+
+			reqAccess.override = true
+		}
+
+		if !pool.ValidNamespace(reqAccess.namespace) {
+			replyError(w, r, http.StatusBadRequest, ErrCodeBadRequest, pool.ErrInvalidNamespace.Error())
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), contextKeyAccess, reqAccess)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// adminOnly rejects the requests from the callers without the admin role in all namespaces of the pool
+func (a *App) adminOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !requestAccess(r).admin {
+			replyError(w, r, http.StatusForbidden, ErrCodeForbidden, "Identity can't access the pool management APIs")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func requestAccess(r *http.Request) *access {
+	if value, ok := r.Context().Value(contextKeyAccess).(*access); ok {
+		return value
+	}
+
+	return &access{}
+}
+
+// poolFor returns the pool manager scoped to the request namespace and the request block holder
+func (a *App) poolFor(r *http.Request) *pool.Manager {
+	pm, err := a.pm.WithNamespace(requestAccess(r).namespace)
+	if err != nil {
 		panic(err)
 	}
+
+	return withHolder(pm.WithLogger(requestLogger(r)), requestAccess(r))
+}
+
+func withHolder(pm *pool.Manager, reqAccess *access) *pool.Manager {
+	if reqAccess.override {
+		return pm.WithHolderOverride()
+	}
+
+	return pm.WithHolder(reqAccess.holder)
+}
+
+// pairFor returns the pool pair scoped to the request namespace and the request block holder
+func (a *App) pairFor(r *http.Request) *pool.Pair {
+	pair, err := a.config.Pair.WithNamespace(requestAccess(r).namespace)
+	if err != nil {
+		panic(err)
+	}
+
+	pair = pair.WithLogger(requestLogger(r))
+	if requestAccess(r).override {
+		return pair.WithHolderOverride()
+	}
+
+	return pair.WithHolder(requestAccess(r).holder)
+}
+
+// listOptions returns the allocation list options from the request parameters
+func listOptions(r *http.Request) (*pool.ListOptions, error) {
+	query := r.URL.Query()
+	opts := &pool.ListOptions{
+		KeyPrefix: query.Get(paramPrefix),
+		Selector:  query.Get(paramSelector),
+		Range:     query.Get(paramRange),
+		State:     query.Get(paramState),
+		Sort:      query.Get(paramSort),
+		Cursor:    query.Get(paramCursor),
+	}
+
+	var err error
+	if value := query.Get(paramLimit); value != "" {
+		if opts.Limit, err = strconv.Atoi(value); err != nil {
+			return nil, err
+		}
+	}
+
+	if value := query.Get(paramAfter); value != "" {
+		if opts.CreatedAfter, err = time.Parse(time.RFC3339, value); err != nil {
+			return nil, err
+		}
+	}
+
+	if value := query.Get(paramBefore); value != "" {
+		if opts.CreatedBefore, err = time.Parse(time.RFC3339, value); err != nil {
+			return nil, err
+		}
+	}
+
+	return opts, nil
+}
+
+// etag returns the entity tag for the IP Block (the block record version)
+func etag(blockInfo *pool.BlockInfo) string {
+	return strconv.Quote(strconv.FormatUint(blockInfo.Version, 10))
+}
+
+// ifMatchVersion returns the block version from the If-Match header ("*" matches any version)
+func ifMatchVersion(r *http.Request) (uint64, error) {
+	value := strings.TrimSpace(r.Header.Get(headerIfMatch))
+	if value == "*" {
+		return 0, nil
+	}
+
+	value = strings.TrimPrefix(value, "W/")
+	return strconv.ParseUint(strings.Trim(value, `"`), 10, 64)
+}
+
+// Run starts the HTTP server app execution (on all listen addresses)
+func (a *App) Run() {
+	addresses := a.config.Addresses
+	if len(addresses) == 0 {
+		addresses = []string{serverAddr}
+	}
+
+	var reloader *tlsReloader
+	if a.config.TLS != nil {
+		var err error
+		if reloader, err = newTLSReloader(a.config.TLS, a.logger); err != nil {
+			panic(err)
+		}
+
+		go reloader.watch()
+	}
+
+	errCh := make(chan error, len(addresses))
+	for _, addr := range addresses {
+		a.logger.Info("Listening", "address", addr, "tls", reloader != nil)
+		srv := &http.Server{Addr: addr, Handler: a.router}
+		go func() {
+			if reloader == nil {
+				errCh <- srv.ListenAndServe()
+				return
+			}
+
+			srv.TLSConfig = reloader.serverConfig()
+			errCh <- srv.ListenAndServeTLS("", "")
+		}()
+	}
+
+	panic(<-errCh)
 }
 
 func replyJSON(w http.ResponseWriter,
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kcq/poc-ipblock-pool/pkg/pool"
+)
+
+func TestIfMatchVersion(t *testing.T) {
+	for _, test := range []struct {
+		header string
+		want   uint64
+		err    bool
+	}{
+		{header: "*", want: 0},
+		{header: `"42"`, want: 42},
+		{header: `W/"42"`, want: 42},
+		{header: ` "42" `, want: 42},
+		{header: "42", want: 42},
+		{header: `"abc"`, err: true},
+		{header: `"-1"`, err: true},
+	} {
+		r := httptest.NewRequest("PATCH", "/", nil)
+		r.Header.Set(headerIfMatch, test.header)
+
+		got, err := ifMatchVersion(r)
+		if (err != nil) != test.err || got != test.want {
+			t.Errorf("ifMatchVersion(%q) = %d, %v, want %d (error %v)", test.header, got, err, test.want, test.err)
+		}
+	}
+}
+
+func TestETagRoundTrip(t *testing.T) {
+	block := &pool.BlockInfo{Version: 1234}
+	if got := etag(block); got != `"1234"` {
+		t.Errorf("etag() = %s, want \"1234\"", got)
+	}
+
+	//the ETag of a response is the If-Match value for the next change
+	r := httptest.NewRequest("PATCH", "/", nil)
+	r.Header.Set(headerIfMatch, etag(block))
+	if version, err := ifMatchVersion(r); err != nil || version != block.Version {
+		t.Errorf("ifMatchVersion(etag()) = %d, %v, want %d", version, err, block.Version)
+	}
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/kcq/poc-ipblock-pool/pkg/logging"
+)
+
+const (
+	defaultTLSReloadInterval = 30 * time.Second
+)
+
+// TLS errors
+var (
+	//
+	ErrInvalidClientCA = errors.New("No certificates in the client CA bundle")
+)
+
+// TLSConfig contains the server TLS configurations
+// (the files are loaded again on SIGHUP and when they change)
+type TLSConfig struct {
+	CertFile string
+	KeyFile  string
+	//ClientCAFile is the client CA bundle (PEM); it enables mTLS
+	ClientCAFile string
+	//ClientCertOptional accepts the clients without certificates (the provided certificates are still verified)
+	ClientCertOptional bool
+	//ReloadInterval is how often the files are checked for changes (the default is 30 seconds)
+	ReloadInterval time.Duration
+}
+
+// tlsReloader keeps the current TLS config loaded from the TLS files
+type tlsReloader struct {
+	config   *TLSConfig
+	mu       sync.RWMutex
+	current  *tls.Config
+	modTimes map[string]time.Time
+	logger   logging.Logger
+}
+
+func newTLSReloader(config *TLSConfig, logger logging.Logger) (*tlsReloader, error) {
+	reloader := &tlsReloader{config: config, logger: logger}
+	if err := reloader.load(); err != nil {
+		return nil, err
+	}
+
+	return reloader, nil
+}
+
+func (r *tlsReloader) files() []string {
+	files := []string{r.config.CertFile, r.config.KeyFile}
+	if r.config.ClientCAFile != "" {
+		files = append(files, r.config.ClientCAFile)
+	}
+
+	return files
+}
+
+func (r *tlsReloader) load() error {
+	modTimes := map[string]time.Time{}
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+
+		modTimes[file] = info.ModTime()
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.config.CertFile, r.config.KeyFile)
+	if err != nil {
+		return err
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if r.config.ClientCAFile != "" {
+		raw, err := ioutil.ReadFile(r.config.ClientCAFile)
+		if err != nil {
+			return err
+		}
+
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(raw) {
+			return ErrInvalidClientCA
+		}
+
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		if r.config.ClientCertOptional {
+			config.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+
+	r.mu.Lock()
+	r.current = config
+	r.modTimes = modTimes
+	r.mu.Unlock()
+	return nil
+}
+
+// changed returns true if any TLS file changed since it was loaded
+func (r *tlsReloader) changed() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			//the file is probably being replaced (it's checked again later)
+			return false
+		}
+
+		if !info.ModTime().Equal(r.modTimes[file]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *tlsReloader) reload(reason string) {
+	if err := r.load(); err != nil {
+		r.logger.Error("TLS reload failed - keeping the current config", "reason", reason, "error", err)
+		return
+	}
+
+	r.logger.Info("TLS config reloaded", "reason", reason)
+}
+
+// watch reloads the TLS files on SIGHUP and when they change
+func (r *tlsReloader) watch() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	interval := r.config.ReloadInterval
+	if interval <= 0 {
+		interval = defaultTLSReloadInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-hup:
+			r.reload("SIGHUP")
+		case <-ticker.C:
+			if r.changed() {
+				r.reload("file change")
+			}
+		}
+	}
+}
+
+// serverConfig returns the server TLS config that uses the current TLS config for each new connection
+func (r *tlsReloader) serverConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			return &r.current.Certificates[0], nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			return r.current, nil
+		},
+	}
+}
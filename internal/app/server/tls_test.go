@@ -0,0 +1,327 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/kcq/poc-ipblock-pool/pkg/logging"
+)
+
+// testCert is a generated certificate with its key
+type testCert struct {
+	cert    *x509.Certificate
+	certPEM []byte
+	keyPEM  []byte
+	key     *ecdsa.PrivateKey
+}
+
+var testSerial int64
+
+// newTestCert creates a certificate signed by the parent (a self-signed CA if the parent is nil)
+func newTestCert(t *testing.T, name string, parent *testCert, usage x509.ExtKeyUsage) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testSerial++
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(testSerial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{usage}
+		template.DNSNames = []string{"localhost"}
+		template.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	raw, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rawKey, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testCert{
+		cert:    cert,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: raw}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: rawKey}),
+		key:     key,
+	}
+}
+
+func (c *testCert) tlsCertificate(t *testing.T) tls.Certificate {
+	cert, err := tls.X509KeyPair(c.certPEM, c.keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert
+}
+
+// testPKI is a test CA with the server and the client certificates in a temp dir
+type testPKI struct {
+	ca     *testCert
+	client *testCert
+	config *TLSConfig
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	dir := t.TempDir()
+	ca := newTestCert(t, "test-ca", nil, 0)
+	pki := &testPKI{
+		ca:     ca,
+		client: newTestCert(t, "test-client", ca, x509.ExtKeyUsageClientAuth),
+		config: &TLSConfig{
+			CertFile: filepath.Join(dir, "server.pem"),
+			KeyFile:  filepath.Join(dir, "server-key.pem"),
+		},
+	}
+
+	pki.writeServerCert(t, "server-1")
+	writeFile(t, filepath.Join(dir, "ca.pem"), ca.certPEM)
+	return pki
+}
+
+// writeServerCert writes a new server certificate (with the common name) and its key
+func (p *testPKI) writeServerCert(t *testing.T, name string) {
+	server := newTestCert(t, name, p.ca, x509.ExtKeyUsageServerAuth)
+	writeFile(t, p.config.CertFile, server.certPEM)
+	writeFile(t, p.config.KeyFile, server.keyPEM)
+}
+
+func (p *testPKI) withClientCA(optional bool) *testPKI {
+	p.config.ClientCAFile = filepath.Join(filepath.Dir(p.config.CertFile), "ca.pem")
+	p.config.ClientCertOptional = optional
+	return p
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// serveTLS starts an HTTPS server with the reloader TLS config and returns its address
+func serveTLS(t *testing.T, reloader *tlsReloader) string {
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", reloader.serverConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})}
+	go srv.Serve(listener)
+	t.Cleanup(func() { srv.Close() })
+
+	return listener.Addr().String()
+}
+
+func (p *testPKI) clientConfig(t *testing.T, withCert bool) *tls.Config {
+	roots := x509.NewCertPool()
+	roots.AddCert(p.ca.cert)
+
+	config := &tls.Config{RootCAs: roots, ServerName: "localhost"}
+	if withCert {
+		config.Certificates = []tls.Certificate{p.client.tlsCertificate(t)}
+	}
+
+	return config
+}
+
+func get(addr string, config *tls.Config) error {
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: config},
+		Timeout:   5 * time.Second,
+	}
+
+	resp, err := client.Get("https://" + addr + "/")
+	if err != nil {
+		return err
+	}
+
+	resp.Body.Close()
+	return nil
+}
+
+// servedName returns the common name of the certificate served on the address
+func servedName(t *testing.T, addr string, config *tls.Config) string {
+	conn, err := tls.Dial("tcp", addr, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+}
+
+func TestTLSHandshake(t *testing.T) {
+	pki := newTestPKI(t)
+	reloader, err := newTLSReloader(pki.config, logging.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr := serveTLS(t, reloader)
+	if err := get(addr, pki.clientConfig(t, false)); err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+
+	if name := servedName(t, addr, pki.clientConfig(t, false)); name != "server-1" {
+		t.Errorf("served certificate = %q, want server-1", name)
+	}
+}
+
+func TestTLSClientCerts(t *testing.T) {
+	tests := []struct {
+		name     string
+		optional bool
+		withCert bool
+		wantErr  bool
+	}{
+		{name: "required without cert", wantErr: true},
+		{name: "required with cert", withCert: true},
+		{name: "optional without cert", optional: true},
+		{name: "optional with cert", optional: true, withCert: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pki := newTestPKI(t).withClientCA(test.optional)
+			reloader, err := newTLSReloader(pki.config, logging.Nop())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = get(serveTLS(t, reloader), pki.clientConfig(t, test.withCert))
+			if test.wantErr && err == nil {
+				t.Error("request without the client certificate was accepted")
+			}
+
+			if !test.wantErr && err != nil {
+				t.Errorf("request failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestTLSInvalidClientCA(t *testing.T) {
+	pki := newTestPKI(t).withClientCA(false)
+	writeFile(t, pki.config.ClientCAFile, []byte("not a certificate"))
+
+	if _, err := newTLSReloader(pki.config, logging.Nop()); err != ErrInvalidClientCA {
+		t.Errorf("newTLSReloader() error = %v, want %v", err, ErrInvalidClientCA)
+	}
+}
+
+// waitForName waits until the server serves the certificate with the common name
+// (the trigger is called before each check)
+func waitForName(t *testing.T, addr string, config *tls.Config, name string, trigger func()) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		trigger()
+		if servedName(t, addr, config) == name {
+			return
+		}
+
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	t.Fatalf("server doesn't serve the new certificate (%s)", name)
+}
+
+func TestTLSReloadOnFileChange(t *testing.T) {
+	pki := newTestPKI(t)
+	pki.config.ReloadInterval = 20 * time.Millisecond
+	reloader, err := newTLSReloader(pki.config, logging.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go reloader.watch()
+	addr := serveTLS(t, reloader)
+
+	pki.writeServerCert(t, "server-2")
+	//the modification time may not change within the file system time resolution
+	later := time.Now().Add(time.Minute)
+	for _, file := range []string{pki.config.CertFile, pki.config.KeyFile} {
+		if err := os.Chtimes(file, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	waitForName(t, addr, pki.clientConfig(t, false), "server-2", func() {})
+}
+
+func TestTLSReloadOnSIGHUP(t *testing.T) {
+	//SIGHUP must not stop the test process before the reloader handles it
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	pki := newTestPKI(t)
+	pki.config.ReloadInterval = time.Hour
+	reloader, err := newTLSReloader(pki.config, logging.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go reloader.watch()
+	addr := serveTLS(t, reloader)
+
+	pki.writeServerCert(t, "server-2")
+	waitForName(t, addr, pki.clientConfig(t, false), "server-2", func() {
+		syscall.Kill(os.Getpid(), syscall.SIGHUP)
+	})
+}
+
+func TestTLSReloadKeepsConfigOnError(t *testing.T) {
+	pki := newTestPKI(t)
+	reloader, err := newTLSReloader(pki.config, logging.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr := serveTLS(t, reloader)
+
+	writeFile(t, pki.config.KeyFile, []byte("not a key"))
+	reloader.reload("test")
+
+	if name := servedName(t, addr, pki.clientConfig(t, false)); name != "server-1" {
+		t.Errorf("served certificate = %q, want server-1", name)
+	}
+}
@@ -0,0 +1,685 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+
+	"github.com/kcq/poc-ipblock-pool/pkg/pool"
+)
+
+const (
+	//defaultPoolName is the /v1 API name for the default pool (the pool without a name)
+	defaultPoolName = "default"
+	paramPool       = "pool"
+	pathV1          = "/v1"
+	pathV1Pools     = "/pools"
+	pathV1Pool      = "/pools/{pool}"
+	pathV1Blocks    = "/pools/{pool}/blocks"
+	pathV1Block     = "/pools/{pool}/blocks/{start}"
+	pathV1Grow      = "/pools/{pool}/blocks/{start}/grow"
+	pathV1Split     = "/pools/{pool}/blocks/{start}/split"
+	pathV1Addresses = "/pools/{pool}/blocks/{start}/addresses"
+	pathV1Address   = "/pools/{pool}/blocks/{start}/addresses/{address}"
+	pathV1Key       = "/pools/{pool}/keys/{key}"
+	pathV1Whois     = "/pools/{pool}/whois/{address}"
+	pathV1Groups    = "/pools/{pool}/groups"
+	pathV1Group     = "/pools/{pool}/groups/{id}"
+	pathV1Stats     = "/pools/{pool}/stats"
+	pathV1Ranges    = "/pools/{pool}/ranges"
+	pathV1RangeOp   = "/pools/{pool}/ranges/{op}"
+	pathV1Quotas    = "/pools/{pool}/quotas"
+	pathV1Quota     = "/pools/{pool}/quotas/{tenant}"
+	pathV1Pairs     = "/pairs"
+	pathV1Pair      = "/pairs/{key}"
+)
+
+// Machine-readable error codes (the "code" field in the JSON error envelope)
+const (
+	ErrCodeBadRequest      = "bad_request"
+	ErrCodeUnauthorized    = "unauthorized"
+	ErrCodeForbidden       = "forbidden"
+	ErrCodeNotFound        = "not_found"
+	ErrCodeInternal        = "internal_error"
+	ErrCodePoolNotFound    = "pool_not_found"
+	ErrCodeBlockNotFound   = "block_not_found"
+	ErrCodeAddressNotFound = "address_not_found"
+	ErrCodeGroupNotFound   = "group_not_found"
+	ErrCodePoolExhausted   = "pool_exhausted"
+	ErrCodeBlockExhausted  = "block_exhausted"
+	ErrCodeNoContiguousRun = "no_contiguous_run"
+	ErrCodeQuotaExceeded   = "quota_exceeded"
+	ErrCodeQuotaNotFound   = "quota_not_found"
+	ErrCodeInvalidQuota    = "invalid_quota"
+	ErrCodeInvalidRange    = "invalid_range"
+	ErrCodeRangeOverlap    = "range_overlap"
+	ErrCodeRangeInUse      = "range_in_use"
+	ErrCodeChildPoolRange  = "child_pool_range"
+	ErrCodeBlockDelegated  = "block_delegated"
+	ErrCodeBlockPaired     = "block_paired"
+	ErrCodeBlockGrouped    = "block_grouped"
+	ErrCodePairMismatch    = "pair_mismatch"
+	ErrCodeGrowBlocked     = "grow_blocked"
+	ErrCodeInvalidSize     = "invalid_size"
+	ErrCodeInvalidIP       = "invalid_ip"
+	ErrCodeInvalidLabels   = "invalid_labels"
+	ErrCodeInvalidList     = "invalid_list_options"
+	ErrCodeVersionMismatch = "version_mismatch"
+	ErrCodeKeyInUse        = "key_in_use"
+	ErrCodeNoPrecondition  = "precondition_required"
+	ErrCodeHolderRequired  = "holder_required"
+	ErrCodeHolderMismatch  = "holder_mismatch"
+	ErrCodePoolUnavailable = "pool_unavailable"
+)
+
+// ErrorInfo is the JSON error envelope content
+type ErrorInfo struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type errorEnvelope struct {
+	Error *ErrorInfo `json:"error"`
+}
+
+type apiError struct {
+	status int
+	code   string
+}
+
+// apiErrors maps the pool errors to the HTTP status codes and the error codes
+var apiErrors = map[error]apiError{
+	pool.ErrPoolNotFound:       {http.StatusNotFound, ErrCodePoolNotFound},
+	pool.ErrBlockNotFound:      {http.StatusNotFound, ErrCodeBlockNotFound},
+	pool.ErrAddressNotFound:    {http.StatusNotFound, ErrCodeAddressNotFound},
+	pool.ErrGroupNotFound:      {http.StatusNotFound, ErrCodeGroupNotFound},
+	pool.ErrPoolExhausted:      {http.StatusConflict, ErrCodePoolExhausted},
+	pool.ErrBlockExhausted:     {http.StatusConflict, ErrCodeBlockExhausted},
+	pool.ErrNoContiguousRun:    {http.StatusConflict, ErrCodeNoContiguousRun},
+	pool.ErrQuotaExceeded:      {http.StatusForbidden, ErrCodeQuotaExceeded},
+	pool.ErrQuotaNotFound:      {http.StatusNotFound, ErrCodeQuotaNotFound},
+	pool.ErrInvalidQuota:       {http.StatusBadRequest, ErrCodeInvalidQuota},
+	pool.ErrInvalidRange:       {http.StatusBadRequest, ErrCodeInvalidRange},
+	pool.ErrRangeOverlap:       {http.StatusConflict, ErrCodeRangeOverlap},
+	pool.ErrRangeInUse:         {http.StatusConflict, ErrCodeRangeInUse},
+	pool.ErrChildPoolRange:     {http.StatusConflict, ErrCodeChildPoolRange},
+	pool.ErrBlockDelegated:     {http.StatusConflict, ErrCodeBlockDelegated},
+	pool.ErrBlockPaired:        {http.StatusConflict, ErrCodeBlockPaired},
+	pool.ErrBlockGrouped:       {http.StatusConflict, ErrCodeBlockGrouped},
+	pool.ErrPairMismatch:       {http.StatusConflict, ErrCodePairMismatch},
+	pool.ErrGrowBlocked:        {http.StatusConflict, ErrCodeGrowBlocked},
+	pool.ErrInvalidSize:        {http.StatusBadRequest, ErrCodeInvalidSize},
+	pool.ErrInvalidGroupSize:   {http.StatusBadRequest, ErrCodeInvalidSize},
+	pool.ErrInvalidIP:          {http.StatusBadRequest, ErrCodeInvalidIP},
+	pool.ErrInvalidLabels:      {http.StatusBadRequest, ErrCodeInvalidLabels},
+	pool.ErrInvalidSelector:    {http.StatusBadRequest, ErrCodeInvalidList},
+	pool.ErrInvalidListOptions: {http.StatusBadRequest, ErrCodeInvalidList},
+	pool.ErrInvalidCursor:      {http.StatusBadRequest, ErrCodeInvalidList},
+	pool.ErrVersionMismatch:    {http.StatusPreconditionFailed, ErrCodeVersionMismatch},
+	pool.ErrKeyInUse:           {http.StatusConflict, ErrCodeKeyInUse},
+	pool.ErrHolderRequired:     {http.StatusUnauthorized, ErrCodeHolderRequired},
+	pool.ErrHolderMismatch:     {http.StatusForbidden, ErrCodeHolderMismatch},
+	pool.ErrPoolInfoMissing:    {http.StatusServiceUnavailable, ErrCodePoolUnavailable},
+	pool.ErrInvalidPoolInfo:    {http.StatusServiceUnavailable, ErrCodePoolUnavailable},
+}
+
+// PoolSummary describes a pool served by the /v1 API
+type PoolSummary struct {
+	Name   string `json:"name"`
+	Parent string `json:"parent,omitempty"`
+}
+
+// AllocationBody is the /v1 block, group and pair allocation request body
+type AllocationBody struct {
+	Key         string            `json:"key"`
+	Tenant      string            `json:"tenant,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Description string            `json:"description,omitempty"`
+	//Count is the number of blocks in a block group
+	Count int64 `json:"count,omitempty"`
+}
+
+// UpdateBody is the /v1 block update request body (the omitted fields are not changed)
+type UpdateBody struct {
+	Key *string `json:"key"`
+	//Labels replace the block labels (an empty object removes all labels)
+	Labels      map[string]string `json:"labels"`
+	Description *string           `json:"description"`
+}
+
+// SplitBody is the /v1 block split request body
+type SplitBody struct {
+	Size int64 `json:"size"`
+}
+
+// AddressBody is the /v1 address allocation request body
+type AddressBody struct {
+	Key string `json:"key"`
+}
+
+// RangeBody is the /v1 pool range change request body
+type RangeBody struct {
+	Start string `json:"start,omitempty"`
+	End   string `json:"end,omitempty"`
+	//CIDR is the added range (instead of the start and end addresses)
+	CIDR   string `json:"cidr,omitempty"`
+	DryRun bool   `json:"dry_run,omitempty"`
+}
+
+// QuotaBody is the /v1 tenant quota request body (0 means no limit)
+type QuotaBody struct {
+	MaxBlocks    int64 `json:"max_blocks"`
+	MaxAddresses int64 `json:"max_addresses"`
+}
+
+func (a *App) initV1() {
+	a.router.Route(pathV1, func(router chi.Router) {
+		router.Get(pathV1Pools, a.v1ListPools)
+		router.Get(pathV1Pool, a.v1GetPool)
+		router.Get(pathV1Stats, a.v1GetPool)
+		router.Get(pathV1Ranges, a.v1GetRanges)
+		router.With(a.adminOnly).Post(pathV1RangeOp, a.v1ChangeRange)
+		router.With(a.adminOnly).Get(pathV1Quotas, a.v1ListQuotas)
+		router.With(a.adminOnly).Get(pathV1Quota, a.v1GetQuota)
+		router.With(a.adminOnly).Put(pathV1Quota, a.v1SetQuota)
+		router.With(a.adminOnly).Delete(pathV1Quota, a.v1RemoveQuota)
+		router.Get(pathV1Blocks, a.v1ListBlocks)
+		router.Post(pathV1Blocks, a.v1AllocateBlock)
+		router.Get(pathV1Block, a.v1GetBlock)
+		router.Patch(pathV1Block, a.v1UpdateBlock)
+		router.Delete(pathV1Block, a.v1FreeBlock)
+		router.Post(pathV1Grow, a.v1GrowBlock)
+		router.Post(pathV1Split, a.v1SplitBlock)
+		router.Post(pathV1Addresses, a.v1AllocateAddress)
+		router.Get(pathV1Address, a.v1GetAddress)
+		router.Delete(pathV1Address, a.v1FreeAddress)
+		router.Get(pathV1Key, a.v1GetKey)
+		router.Delete(pathV1Key, a.v1FreeKey)
+		router.Get(pathV1Whois, a.v1Whois)
+		router.Post(pathV1Groups, a.v1AllocateGroup)
+		router.Get(pathV1Group, a.v1GetGroup)
+		router.Delete(pathV1Group, a.v1FreeGroup)
+
+		if a.config.Pair != nil {
+			router.Post(pathV1Pairs, a.v1AllocatePair)
+			router.Get(pathV1Pair, a.v1GetPair)
+			router.Delete(pathV1Pair, a.v1FreePair)
+		}
+
+		router.NotFound(func(w http.ResponseWriter, r *http.Request) {
+			replyError(w, r, http.StatusNotFound, ErrCodeNotFound, "Resource not found")
+		})
+	})
+}
+
+func (a *App) v1ListPools(w http.ResponseWriter, r *http.Request) {
+	pools := []*PoolSummary{{Name: poolName(a.pm)}}
+	for _, pm := range a.config.Pools {
+		pools = append(pools, &PoolSummary{Name: poolName(pm)})
+	}
+
+	for _, child := range a.pm.Children() {
+		pools = append(pools, &PoolSummary{Name: child.Name, Parent: poolName(a.pm)})
+	}
+
+	replyJSON(w, r, pools, http.StatusOK, prettyOutput(r))
+}
+
+// v1GetPool replies with the pool statistics (the parent pool statistics include its child pools)
+func (a *App) v1GetPool(w http.ResponseWriter, r *http.Request) {
+	pm, ok := a.v1Pool(w, r)
+	if !ok {
+		return
+	}
+
+	if requestAccess(r).restricted || r.URL.Query().Get(paramNamespace) != "" {
+		stats, err := pm.NamespaceStats()
+		replyValue(w, r, stats, stats == nil, err, pool.ErrPoolNotFound)
+		return
+	}
+
+	stats, err := pm.Stats()
+	replyValue(w, r, stats, stats == nil, err, pool.ErrPoolNotFound)
+}
+
+func (a *App) v1GetRanges(w http.ResponseWriter, r *http.Request) {
+	if pm, ok := a.v1Pool(w, r); ok {
+		ranges, err := pm.Ranges()
+		replyValue(w, r, ranges, false, err, nil)
+	}
+}
+
+func (a *App) v1ChangeRange(w http.ResponseWriter, r *http.Request) {
+	pm, ok := a.v1Pool(w, r)
+	if !ok {
+		return
+	}
+
+	var body RangeBody
+	if !decodeBody(w, r, &body) {
+		return
+	}
+
+	var change *pool.RangeChange
+	var err error
+	switch chi.URLParam(r, "op") {
+	case pool.RangeOpExtend:
+		change, err = pm.ExtendRange(body.End, body.DryRun)
+	case pool.RangeOpAdd:
+		start, end := body.Start, body.End
+		if body.CIDR != "" {
+			start, end = body.CIDR, ""
+		}
+
+		change, err = pm.AddRange(start, end, body.DryRun)
+	case pool.RangeOpShrink:
+		change, err = pm.ShrinkRange(body.End, body.DryRun)
+	default:
+		replyError(w, r, http.StatusNotFound, ErrCodeNotFound, "Resource not found")
+		return
+	}
+
+	replyValue(w, r, change, change == nil, err, pool.ErrInvalidRange)
+}
+
+func (a *App) v1ListQuotas(w http.ResponseWriter, r *http.Request) {
+	if pm, ok := a.v1Pool(w, r); ok {
+		quotas, err := pm.Quotas()
+		replyValue(w, r, quotas, false, err, nil)
+	}
+}
+
+func (a *App) v1GetQuota(w http.ResponseWriter, r *http.Request) {
+	if pm, ok := a.v1Pool(w, r); ok {
+		usage, err := pm.Quota(chi.URLParam(r, paramTenant))
+		replyValue(w, r, usage, usage == nil, err, pool.ErrQuotaNotFound)
+	}
+}
+
+func (a *App) v1SetQuota(w http.ResponseWriter, r *http.Request) {
+	pm, ok := a.v1Pool(w, r)
+	if !ok {
+		return
+	}
+
+	var body QuotaBody
+	if !decodeBody(w, r, &body) {
+		return
+	}
+
+	tenant := chi.URLParam(r, paramTenant)
+	if err := pm.SetQuota(&pool.Quota{Tenant: tenant, MaxBlocks: body.MaxBlocks, MaxAddresses: body.MaxAddresses}); err != nil {
+		replyPoolError(w, r, err)
+		return
+	}
+
+	usage, err := pm.Quota(tenant)
+	replyValue(w, r, usage, usage == nil, err, pool.ErrQuotaNotFound)
+}
+
+func (a *App) v1RemoveQuota(w http.ResponseWriter, r *http.Request) {
+	if pm, ok := a.v1Pool(w, r); ok {
+		replyFreed(w, r, pm.RemoveQuota(chi.URLParam(r, paramTenant)))
+	}
+}
+
+func (a *App) v1ListBlocks(w http.ResponseWriter, r *http.Request) {
+	pm, ok := a.v1Pool(w, r)
+	if !ok {
+		return
+	}
+
+	opts, err := listOptions(r)
+	if err != nil {
+		replyError(w, r, http.StatusBadRequest, ErrCodeInvalidList, err.Error())
+		return
+	}
+
+	page, err := pm.List(opts)
+	replyValue(w, r, page, page == nil, err, pool.ErrPoolNotFound)
+}
+
+func (a *App) v1AllocateBlock(w http.ResponseWriter, r *http.Request) {
+	pm, ok := a.v1Pool(w, r)
+	if !ok {
+		return
+	}
+
+	var body AllocationBody
+	if !decodeBody(w, r, &body) {
+		return
+	}
+
+	blockInfo, err := pm.AllocateWith(&pool.AllocationRequest{
+		Key:         body.Key,
+		Tenant:      body.Tenant,
+		Labels:      body.Labels,
+		Description: body.Description,
+		Owner:       requestAccess(r).owner(),
+	})
+	if err != nil {
+		replyPoolError(w, r, err)
+		return
+	}
+
+	replyBlock(w, r, blockInfo)
+}
+
+func (a *App) v1GetBlock(w http.ResponseWriter, r *http.Request) {
+	if pm, ok := a.v1Pool(w, r); ok {
+		replyBlock(w, r, pm.Lookup(chi.URLParam(r, paramStart), ""))
+	}
+}
+
+// v1UpdateBlock changes the block if the If-Match header matches the block ETag
+func (a *App) v1UpdateBlock(w http.ResponseWriter, r *http.Request) {
+	pm, ok := a.v1Pool(w, r)
+	if !ok {
+		return
+	}
+
+	if r.Header.Get(headerIfMatch) == "" {
+		replyError(w, r, http.StatusPreconditionRequired, ErrCodeNoPrecondition, "Missing If-Match header")
+		return
+	}
+
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		replyError(w, r, http.StatusBadRequest, ErrCodeBadRequest, "Invalid If-Match header")
+		return
+	}
+
+	var body UpdateBody
+	if !decodeBody(w, r, &body) {
+		return
+	}
+
+	blockInfo, err := pm.UpdateBlock(chi.URLParam(r, paramStart), "", version, &pool.BlockUpdate{
+		Key:         body.Key,
+		Labels:      body.Labels,
+		Description: body.Description,
+	})
+	if err != nil {
+		replyPoolError(w, r, err)
+		return
+	}
+
+	replyBlock(w, r, blockInfo)
+}
+
+func (a *App) v1FreeBlock(w http.ResponseWriter, r *http.Request) {
+	if pm, ok := a.v1Pool(w, r); ok {
+		replyFreed(w, r, pm.Free(chi.URLParam(r, paramStart), ""))
+	}
+}
+
+func (a *App) v1GrowBlock(w http.ResponseWriter, r *http.Request) {
+	pm, ok := a.v1Pool(w, r)
+	if !ok {
+		return
+	}
+
+	blockInfo, err := pm.GrowBlock(chi.URLParam(r, paramStart), "")
+	if err != nil {
+		replyPoolError(w, r, err)
+		return
+	}
+
+	replyBlock(w, r, blockInfo)
+}
+
+func (a *App) v1SplitBlock(w http.ResponseWriter, r *http.Request) {
+	pm, ok := a.v1Pool(w, r)
+	if !ok {
+		return
+	}
+
+	var body SplitBody
+	if !decodeBody(w, r, &body) {
+		return
+	}
+
+	blocks, err := pm.SplitBlock(chi.URLParam(r, paramStart), "", body.Size)
+	replyValue(w, r, blocks, len(blocks) == 0, err, pool.ErrBlockNotFound)
+}
+
+func (a *App) v1AllocateAddress(w http.ResponseWriter, r *http.Request) {
+	pm, ok := a.v1Pool(w, r)
+	if !ok {
+		return
+	}
+
+	var body AddressBody
+	if !decodeBody(w, r, &body) {
+		return
+	}
+
+	addressInfo, err := pm.AllocateAddress(chi.URLParam(r, paramStart), body.Key)
+	replyValue(w, r, addressInfo, addressInfo == nil, err, pool.ErrAddressNotFound)
+}
+
+func (a *App) v1GetAddress(w http.ResponseWriter, r *http.Request) {
+	pm, ok := a.v1Pool(w, r)
+	if !ok {
+		return
+	}
+
+	addressInfo := pm.LookupAddress(chi.URLParam(r, paramStart), chi.URLParam(r, paramAddress), "")
+	if addressInfo == nil {
+		replyPoolError(w, r, pool.ErrAddressNotFound)
+		return
+	}
+
+	replyJSON(w, r, addressInfo, http.StatusOK, prettyOutput(r))
+}
+
+func (a *App) v1FreeAddress(w http.ResponseWriter, r *http.Request) {
+	if pm, ok := a.v1Pool(w, r); ok {
+		replyFreed(w, r, pm.FreeAddress(chi.URLParam(r, paramStart), chi.URLParam(r, paramAddress), ""))
+	}
+}
+
+func (a *App) v1GetKey(w http.ResponseWriter, r *http.Request) {
+	if pm, ok := a.v1Pool(w, r); ok {
+		replyBlock(w, r, pm.Lookup("", chi.URLParam(r, paramKey)))
+	}
+}
+
+func (a *App) v1FreeKey(w http.ResponseWriter, r *http.Request) {
+	if pm, ok := a.v1Pool(w, r); ok {
+		replyFreed(w, r, pm.Free("", chi.URLParam(r, paramKey)))
+	}
+}
+
+func (a *App) v1Whois(w http.ResponseWriter, r *http.Request) {
+	if pm, ok := a.v1Pool(w, r); ok {
+		whoisInfo, err := pm.Whois(chi.URLParam(r, paramAddress))
+		replyValue(w, r, whoisInfo, whoisInfo == nil, err, pool.ErrAddressNotFound)
+	}
+}
+
+func (a *App) v1AllocateGroup(w http.ResponseWriter, r *http.Request) {
+	pm, ok := a.v1Pool(w, r)
+	if !ok {
+		return
+	}
+
+	var body AllocationBody
+	if !decodeBody(w, r, &body) {
+		return
+	}
+
+	groupInfo, err := pm.AllocateGroup(&pool.AllocationRequest{
+		Key:         body.Key,
+		Tenant:      body.Tenant,
+		Labels:      body.Labels,
+		Description: body.Description,
+		Owner:       requestAccess(r).owner(),
+	}, body.Count)
+	replyValue(w, r, groupInfo, groupInfo == nil, err, pool.ErrGroupNotFound)
+}
+
+func (a *App) v1GetGroup(w http.ResponseWriter, r *http.Request) {
+	pm, ok := a.v1Pool(w, r)
+	if !ok {
+		return
+	}
+
+	groupInfo := pm.LookupGroup(chi.URLParam(r, paramID), "", "")
+	if groupInfo == nil {
+		replyPoolError(w, r, pool.ErrGroupNotFound)
+		return
+	}
+
+	replyJSON(w, r, groupInfo, http.StatusOK, prettyOutput(r))
+}
+
+func (a *App) v1FreeGroup(w http.ResponseWriter, r *http.Request) {
+	if pm, ok := a.v1Pool(w, r); ok {
+		replyFreed(w, r, pm.FreeGroup(chi.URLParam(r, paramID), "", ""))
+	}
+}
+
+func (a *App) v1AllocatePair(w http.ResponseWriter, r *http.Request) {
+	var body AllocationBody
+	if !decodeBody(w, r, &body) {
+		return
+	}
+
+	pairInfo, err := a.pairFor(r).Allocate(&pool.AllocationRequest{
+		Key:         body.Key,
+		Tenant:      body.Tenant,
+		Labels:      body.Labels,
+		Description: body.Description,
+		Owner:       requestAccess(r).owner(),
+	})
+	replyValue(w, r, pairInfo, pairInfo == nil, err, pool.ErrBlockNotFound)
+}
+
+func (a *App) v1GetPair(w http.ResponseWriter, r *http.Request) {
+	pairInfo := a.pairFor(r).Lookup("", chi.URLParam(r, paramKey))
+	replyValue(w, r, pairInfo, pairInfo == nil, nil, pool.ErrBlockNotFound)
+}
+
+func (a *App) v1FreePair(w http.ResponseWriter, r *http.Request) {
+	replyFreed(w, r, a.pairFor(r).Free("", chi.URLParam(r, paramKey)))
+}
+
+// v1Pool returns the pool selected in the request path scoped to the request namespace and block holder
+// (the main pool, the additional pools or the child pools of the main pool)
+func (a *App) v1Pool(w http.ResponseWriter, r *http.Request) (*pool.Manager, bool) {
+	name := chi.URLParam(r, paramPool)
+
+	var pm *pool.Manager
+	if name == poolName(a.pm) {
+		pm = a.pm
+	}
+
+	for _, p := range a.config.Pools {
+		if pm == nil && name == poolName(p) {
+			pm = p
+		}
+	}
+
+	if pm == nil {
+		child, err := a.pm.Child(name)
+		if err != nil {
+			replyPoolError(w, r, pool.ErrPoolNotFound)
+			return nil, false
+		}
+
+		pm = child
+	}
+
+	scoped, err := pm.WithNamespace(requestAccess(r).namespace)
+	if err != nil {
+		panic(err)
+	}
+
+	return withHolder(scoped.WithLogger(requestLogger(r)), requestAccess(r)), true
+}
+
+func poolName(pm *pool.Manager) string {
+	if pm.Name() == "" {
+		return defaultPoolName
+	}
+
+	return pm.Name()
+}
+
+func prettyOutput(r *http.Request) bool {
+	return strings.ToLower(r.URL.Query().Get(paramPretty)) == "true"
+}
+
+// decodeBody decodes the JSON request body (an empty body is allowed)
+func decodeBody(w http.ResponseWriter, r *http.Request, value interface{}) bool {
+	if r.Body == nil || r.ContentLength == 0 {
+		return true
+	}
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(value); err != nil {
+		replyError(w, r, http.StatusBadRequest, ErrCodeBadRequest, "Invalid request body: "+err.Error())
+		return false
+	}
+
+	return true
+}
+
+func replyBlock(w http.ResponseWriter, r *http.Request, blockInfo *pool.BlockInfo) {
+	if blockInfo == nil {
+		replyPoolError(w, r, pool.ErrBlockNotFound)
+		return
+	}
+
+	w.Header().Set(headerETag, etag(blockInfo))
+	replyJSON(w, r, blockInfo, http.StatusOK, prettyOutput(r))
+}
+
+// replyFreed replies with 204 or with the error envelope
+func replyFreed(w http.ResponseWriter, r *http.Request, err error) {
+	if err != nil {
+		replyPoolError(w, r, err)
+		return
+	}
+
+	reply(w, r, http.StatusNoContent)
+}
+
+// replyValue replies with the value or with the error envelope
+// (the notFound error is used if the value is missing, so the typed nil values are never sent as "null")
+func replyValue(w http.ResponseWriter, r *http.Request, value interface{}, missing bool, err, notFound error) {
+	switch {
+	case err != nil:
+		replyPoolError(w, r, err)
+	case missing:
+		replyPoolError(w, r, notFound)
+	default:
+		replyJSON(w, r, value, http.StatusOK, prettyOutput(r))
+	}
+}
+
+func replyPoolError(w http.ResponseWriter, r *http.Request, err error) {
+	info, ok := apiErrors[err]
+	if !ok {
+		replyError(w, r, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+
+	replyError(w, r, info.status, info.code, err.Error())
+}
+
+// replyError replies with the JSON error envelope for the /v1 API requests
+// (the original API replies only with the status code)
+func replyError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	countError(code)
+	if !strings.HasPrefix(r.URL.Path, pathV1+"/") {
+		reply(w, r, status)
+		return
+	}
+
+	replyJSON(w, r, &errorEnvelope{Error: &ErrorInfo{Code: code, Message: message}}, status, prettyOutput(r))
+}
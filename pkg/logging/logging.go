@@ -0,0 +1,218 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the log message level
+type Level int
+
+// Log levels
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+	//LevelOff disables all messages
+	LevelOff
+)
+
+var levelNames = map[Level]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+	LevelOff:   "off",
+}
+
+// Log formats
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+const (
+	timeFormat     = "2006-01-02T15:04:05.000Z07:00"
+	missingValue   = "<missing>"
+	fieldTime      = "time"
+	fieldLevel     = "level"
+	fieldMessage   = "msg"
+	textQuoteChars = " =\""
+)
+
+// Logging errors
+var (
+	//
+	ErrUnknownLevel = errors.New("Unknown log level")
+	//
+	ErrUnknownFormat = errors.New("Unknown log format")
+)
+
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+// ParseLevel returns the log level by its name (debug, info, warn, error or off)
+func ParseLevel(name string) (Level, error) {
+	for level, levelName := range levelNames {
+		if strings.ToLower(name) == levelName {
+			return level, nil
+		}
+	}
+
+	return LevelOff, ErrUnknownLevel
+}
+
+// Logger is the leveled structured logger
+// (the fields are the key/value pairs, e.g., logger.Info("Allocated IP block", "block", start))
+type Logger interface {
+	Debug(msg string, fields ...interface{})
+	Info(msg string, fields ...interface{})
+	Warn(msg string, fields ...interface{})
+	Error(msg string, fields ...interface{})
+	//With returns a logger that adds the fields to all messages
+	With(fields ...interface{}) Logger
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(msg string, fields ...interface{}) {}
+func (nopLogger) Info(msg string, fields ...interface{})  {}
+func (nopLogger) Warn(msg string, fields ...interface{})  {}
+func (nopLogger) Error(msg string, fields ...interface{}) {}
+func (l nopLogger) With(fields ...interface{}) Logger     { return l }
+
+// Nop returns the logger that discards all messages (the default logger)
+func Nop() Logger {
+	return nopLogger{}
+}
+
+// output is shared by the loggers created with With
+type output struct {
+	mu     sync.Mutex
+	w      io.Writer
+	level  Level
+	format string
+}
+
+type logger struct {
+	out    *output
+	fields []interface{}
+}
+
+// New creates a logger that writes the messages at the level or above in the text or the JSON format
+func New(w io.Writer, level Level, format string) (Logger, error) {
+	if format == "" {
+		format = FormatText
+	}
+
+	if format != FormatText && format != FormatJSON {
+		return nil, ErrUnknownFormat
+	}
+
+	if level == LevelOff {
+		return Nop(), nil
+	}
+
+	return &logger{out: &output{w: w, level: level, format: format}}, nil
+}
+
+func (l *logger) Debug(msg string, fields ...interface{}) {
+	l.log(LevelDebug, msg, fields)
+}
+
+func (l *logger) Info(msg string, fields ...interface{}) {
+	l.log(LevelInfo, msg, fields)
+}
+
+func (l *logger) Warn(msg string, fields ...interface{}) {
+	l.log(LevelWarn, msg, fields)
+}
+
+func (l *logger) Error(msg string, fields ...interface{}) {
+	l.log(LevelError, msg, fields)
+}
+
+func (l *logger) With(fields ...interface{}) Logger {
+	all := make([]interface{}, 0, len(l.fields)+len(fields))
+	all = append(append(all, l.fields...), fields...)
+	return &logger{out: l.out, fields: all}
+}
+
+func (l *logger) log(level Level, msg string, fields []interface{}) {
+	if level < l.out.level {
+		return
+	}
+
+	all := append(append([]interface{}{}, l.fields...), fields...)
+	if len(all)%2 != 0 {
+		all = append(all, missingValue)
+	}
+
+	buf := &bytes.Buffer{}
+	now := time.Now().UTC().Format(timeFormat)
+	if l.out.format == FormatJSON {
+		buf.WriteString("{")
+		writeJSONField(buf, fieldTime, now)
+		buf.WriteString(",")
+		writeJSONField(buf, fieldLevel, level.String())
+		buf.WriteString(",")
+		writeJSONField(buf, fieldMessage, msg)
+		for i := 0; i < len(all); i += 2 {
+			buf.WriteString(",")
+			writeJSONField(buf, fmt.Sprint(all[i]), fieldValue(all[i+1]))
+		}
+		buf.WriteString("}\n")
+	} else {
+		fmt.Fprintf(buf, "%s %-5s %s", now, strings.ToUpper(level.String()), msg)
+		for i := 0; i < len(all); i += 2 {
+			fmt.Fprintf(buf, " %s=%s", fmt.Sprint(all[i]), textValue(fieldValue(all[i+1])))
+		}
+		buf.WriteString("\n")
+	}
+
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	l.out.w.Write(buf.Bytes())
+}
+
+// fieldValue returns the value to log (the errors and the stringers are logged as strings)
+func fieldValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+
+	return value
+}
+
+func writeJSONField(buf *bytes.Buffer, key string, value interface{}) {
+	rawKey, _ := json.Marshal(key)
+	rawValue, err := json.Marshal(value)
+	if err != nil {
+		rawValue, _ = json.Marshal(fmt.Sprint(value))
+	}
+
+	buf.Write(rawKey)
+	buf.WriteString(":")
+	buf.Write(rawValue)
+}
+
+func textValue(value interface{}) string {
+	text := fmt.Sprint(value)
+	if text == "" || strings.ContainsAny(text, textQuoteChars) {
+		return strconv.Quote(text)
+	}
+
+	return text
+}
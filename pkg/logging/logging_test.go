@@ -0,0 +1,176 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestLogger(t *testing.T, level Level, format string) (Logger, *bytes.Buffer) {
+	buf := &bytes.Buffer{}
+	logger, err := New(buf, level, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return logger, buf
+}
+
+// textLines returns the logged text lines without the message times
+func textLines(t *testing.T, buf *bytes.Buffer) []string {
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		parts := strings.SplitN(line, " ", 2)
+		if len(parts) != 2 {
+			t.Fatalf("invalid log line: %q", line)
+		}
+
+		if _, err := time.Parse(timeFormat, parts[0]); err != nil {
+			t.Errorf("invalid message time in %q: %v", line, err)
+		}
+
+		lines = append(lines, parts[1])
+	}
+
+	return lines
+}
+
+func TestTextFormat(t *testing.T) {
+	logger, buf := newTestLogger(t, LevelDebug, FormatText)
+	logger.Info("Allocated IP block", "block", "169.254.60.4", "size", 4)
+	logger.Warn("Can't load", "error", errors.New("Pool info missing"), "ip", net.ParseIP("fd00::1"))
+	logger.Error("Values", "empty", "", "quote", `a"b`, "eq", "a=b")
+	logger.Debug("Odd fields", "key")
+
+	want := []string{
+		"INFO  Allocated IP block block=169.254.60.4 size=4",
+		`WARN  Can't load error="Pool info missing" ip=fd00::1`,
+		`ERROR Values empty="" quote="a\"b" eq="a=b"`,
+		"DEBUG Odd fields key=<missing>",
+	}
+
+	lines := textLines(t, buf)
+	if len(lines) != len(want) {
+		t.Fatalf("logged lines = %q, want %q", lines, want)
+	}
+
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Errorf("line %d = %q, want %q", i, lines[i], want[i])
+		}
+	}
+}
+
+func TestJSONFormat(t *testing.T) {
+	logger, buf := newTestLogger(t, LevelInfo, FormatJSON)
+	logger.Info("Allocated IP block", "block", "169.254.60.4", "size", 4, "error", errors.New("none"), "odd")
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &fields); err != nil {
+		t.Fatalf("invalid JSON message %q: %v", buf.String(), err)
+	}
+
+	if _, err := time.Parse(timeFormat, fields[fieldTime].(string)); err != nil {
+		t.Errorf("invalid message time: %v", err)
+	}
+
+	for key, want := range map[string]interface{}{
+		fieldLevel:   "info",
+		fieldMessage: "Allocated IP block",
+		"block":      "169.254.60.4",
+		"size":       float64(4),
+		"error":      "none",
+		"odd":        missingValue,
+	} {
+		if fields[key] != want {
+			t.Errorf("field %s = %v, want %v", key, fields[key], want)
+		}
+	}
+
+	if !strings.HasSuffix(buf.String(), "}\n") {
+		t.Errorf("message %q doesn't end with a new line", buf.String())
+	}
+}
+
+func TestLevelFilter(t *testing.T) {
+	logger, buf := newTestLogger(t, LevelWarn, FormatText)
+	logger.Debug("debug")
+	logger.Info("info")
+	logger.Warn("warn")
+	logger.Error("error")
+
+	want := []string{"WARN  warn", "ERROR error"}
+	lines := textLines(t, buf)
+	if len(lines) != len(want) || lines[0] != want[0] || lines[1] != want[1] {
+		t.Errorf("logged lines = %q, want %q", lines, want)
+	}
+}
+
+func TestWith(t *testing.T) {
+	logger, buf := newTestLogger(t, LevelInfo, FormatText)
+	requestLogger := logger.With("request", "r1")
+	requestLogger.With("pool", "default").Info("Freed", "block", "169.254.60.4")
+	requestLogger.Info("Listed")
+	logger.Info("Started")
+
+	want := []string{
+		"INFO  Freed request=r1 pool=default block=169.254.60.4",
+		"INFO  Listed request=r1",
+		"INFO  Started",
+	}
+
+	lines := textLines(t, buf)
+	if len(lines) != len(want) {
+		t.Fatalf("logged lines = %q, want %q", lines, want)
+	}
+
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Errorf("line %d = %q, want %q", i, lines[i], want[i])
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(&bytes.Buffer{}, LevelInfo, "xml"); err != ErrUnknownFormat {
+		t.Errorf("New(xml) error = %v, want %v", err, ErrUnknownFormat)
+	}
+
+	//the text format is the default format
+	logger, buf := newTestLogger(t, LevelInfo, "")
+	logger.Info("Started")
+	if lines := textLines(t, buf); lines[0] != "INFO  Started" {
+		t.Errorf("logged line = %q, want the text format", lines[0])
+	}
+
+	logger, buf = newTestLogger(t, LevelOff, FormatJSON)
+	logger.Error("Failed")
+	if buf.Len() != 0 {
+		t.Errorf("logger with the off level logged %q", buf.String())
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		want    Level
+		wantErr error
+	}{
+		{name: "debug", want: LevelDebug},
+		{name: "INFO", want: LevelInfo},
+		{name: "Warn", want: LevelWarn},
+		{name: "error", want: LevelError},
+		{name: "off", want: LevelOff},
+		{name: "trace", want: LevelOff, wantErr: ErrUnknownLevel},
+		{name: "", want: LevelOff, wantErr: ErrUnknownLevel},
+	} {
+		level, err := ParseLevel(test.name)
+		if level != test.want || err != test.wantErr {
+			t.Errorf("ParseLevel(%q) = (%s, %v), want (%s, %v)", test.name, level, err, test.want, test.wantErr)
+		}
+	}
+}
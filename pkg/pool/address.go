@@ -0,0 +1,233 @@
+package pool
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net"
+
+	"github.com/segmentio/ksuid"
+)
+
+const (
+	poolAddressesKeyPrefix = "addresses"
+)
+
+// Address allocation errors
+var (
+	//
+	ErrAddressNotFound = errors.New("Address not found")
+	//
+	ErrBlockExhausted = errors.New("No free addresses in block")
+)
+
+// AddressInfo contains the IP address metadata persisted in the Pool Store
+// (individual addresses are allocated from an existing IP Block allocation)
+type AddressInfo struct {
+	ID      string `json:"id"`
+	Block   string `json:"block"`
+	Address string `json:"address"`
+	Key     string `json:"key"`
+}
+
+// NewAddressInfo creates a new IP address Info object
+func NewAddressInfo(block, address, key string) *AddressInfo {
+	id, err := ksuid.NewRandom()
+	if err != nil {
+		panic(err)
+	}
+
+	info := AddressInfo{
+		ID:      id.String(),
+		Block:   block,
+		Address: address,
+		Key:     key,
+	}
+
+	return &info
+}
+
+// LookupAddress returns the IP address metadata selected by the IP address
+// or the address (sub-)key within the selected IP Block or nil if the address is not allocated
+func (pool *Manager) LookupAddress(ipBlock, address, addressKey string) *AddressInfo {
+	if ipBlock == "" || pool.getBlock(ipBlock) == nil {
+		return nil
+	}
+
+	if address != "" {
+		return pool.store.GetAddress(ipBlock, address)
+	} else if addressKey != "" {
+		return pool.store.FindAddress(ipBlock, addressKey)
+	}
+
+	return nil
+}
+
+// AllocateAddress returns a newly allocated IP address from the selected IP Block
+// or an existing IP address if the provided address (sub-)key matches an existing allocation
+func (pool *Manager) AllocateAddress(ipBlock, addressKey string) (*AddressInfo, error) {
+	lock := pool.acquireLock("Pool.AllocateAddress")
+	defer lock.Unlock()
+
+	blockInfo := pool.getBlock(ipBlock)
+	if blockInfo == nil {
+		return nil, ErrBlockNotFound
+	}
+
+	if blockInfo.Pool != "" {
+		return nil, ErrBlockDelegated
+	}
+
+	if err := pool.checkHolder(blockInfo); err != nil {
+		return nil, err
+	}
+
+	if addressKey != "" {
+		if addressInfo := pool.store.FindAddress(blockInfo.Start, addressKey); addressInfo != nil {
+			pool.logger.Debug("Address is already allocated", "block", blockInfo.Start, "key", addressKey)
+			return addressInfo, nil
+		}
+	}
+
+	allocated := map[string]bool{}
+	for _, addressInfo := range pool.store.ListAddresses(blockInfo.Start) {
+		allocated[addressInfo.Address] = true
+	}
+
+	blockIP := net.ParseIP(blockInfo.Start)
+	first, last := pool.hostOffsets(blockIP, pool.sizeOf(blockInfo))
+	for offset := first; offset.Cmp(last) <= 0; offset.Add(offset, big.NewInt(1)) {
+		ip := addToIP(blockIP, offset)
+		if ip == nil || allocated[ip.String()] {
+			continue
+		}
+
+		addressInfo := NewAddressInfo(blockInfo.Start, ip.String(), addressKey)
+		pool.store.SaveAddress(addressInfo)
+		pool.logger.Info("Allocated IP address", "block", blockInfo.Start, "address", addressInfo.Address, "key", addressKey)
+		return addressInfo, nil
+	}
+
+	return nil, ErrBlockExhausted
+}
+
+// hostOffsets returns the first and the last allocatable address offsets in the IP Block
+// (if the pool reserves the network addresses, the network and the broadcast addresses
+// are skipped in the IPv4 blocks with more than 2 addresses)
+func (pool *Manager) hostOffsets(blockIP net.IP, size *big.Int) (*big.Int, *big.Int) {
+	first := big.NewInt(0)
+	last := big.NewInt(0).Sub(size, big.NewInt(1))
+	if pool.reserveNetwork && !isIPv6(blockIP) && size.Cmp(big.NewInt(2)) > 0 {
+		first.Add(first, big.NewInt(1))
+		last.Sub(last, big.NewInt(1))
+	}
+
+	return first, last
+}
+
+// FreeAddress releases the selected IP address allocation
+// based on the provided IP address or its address (sub-)key
+func (pool *Manager) FreeAddress(ipBlock, address, addressKey string) error {
+	lock := pool.acquireLock("Pool.FreeAddress")
+	defer lock.Unlock()
+
+	blockInfo := pool.getBlock(ipBlock)
+	if blockInfo == nil {
+		return ErrBlockNotFound
+	}
+
+	if err := pool.checkHolder(blockInfo); err != nil {
+		return err
+	}
+
+	if address != "" {
+		if addressInfo := pool.store.GetAddress(ipBlock, address); addressInfo != nil {
+			pool.store.RemoveAddress(ipBlock, address)
+			pool.logger.Info("Freed IP address", "block", ipBlock, "address", address)
+			return nil
+		}
+	} else if addressKey != "" {
+		if addressInfo := pool.store.FindAddress(ipBlock, addressKey); addressInfo != nil {
+			pool.store.RemoveAddress(ipBlock, addressInfo.Address)
+			pool.logger.Info("Freed IP address", "block", ipBlock, "address", addressInfo.Address, "key", addressKey)
+			return nil
+		}
+	}
+
+	return ErrAddressNotFound
+}
+
+func (s *Store) addressKeyPrefix(blockStart string) string {
+	return s.key(poolAddressesKeyPrefix, blockStart) + "/"
+}
+
+// ListAddresses returns the IP address allocations in the selected IP Block
+func (s *Store) ListAddresses(blockStart string) []*AddressInfo {
+	pairs, _, err := s.kvAPI.List(s.addressKeyPrefix(blockStart), nil)
+	if err != nil {
+		panic(err)
+	}
+
+	var addresses []*AddressInfo
+	for _, p := range pairs {
+		var address AddressInfo
+		if err := json.Unmarshal(p.Value, &address); err != nil {
+			panic(err)
+		}
+
+		addresses = append(addresses, &address)
+	}
+
+	return addresses
+}
+
+// FindAddress returns the AddressInfo object selected by the address (sub-)key
+func (s *Store) FindAddress(blockStart, key string) *AddressInfo {
+	for _, address := range s.ListAddresses(blockStart) {
+		if address.Key == key {
+			return address
+		}
+	}
+
+	return nil
+}
+
+// GetAddress returns the AddressInfo object selected by the IP address
+func (s *Store) GetAddress(blockStart, address string) *AddressInfo {
+	raw := s.GetRecord(s.addressKeyPrefix(blockStart) + address)
+	if raw == nil {
+		return nil
+	}
+
+	var info AddressInfo
+	if err := json.Unmarshal(raw, &info); err != nil {
+		panic(err)
+	}
+
+	return &info
+}
+
+// SaveAddress saves the provided AddressInfo object
+func (s *Store) SaveAddress(address *AddressInfo) {
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(address); err != nil {
+		panic(err)
+	}
+
+	s.SaveRecord(s.addressKeyPrefix(address.Block)+address.Address, buf.Bytes())
+}
+
+// RemoveAddress removes the AddressInfo object selected by the IP address
+func (s *Store) RemoveAddress(blockStart, address string) {
+	s.RemoveRecord(s.addressKeyPrefix(blockStart) + address)
+}
+
+// RemoveAddresses removes all IP address allocations in the selected IP Block
+func (s *Store) RemoveAddresses(blockStart string) {
+	if _, err := s.kvAPI.DeleteTree(s.addressKeyPrefix(blockStart), nil); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,109 @@
+package pool
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestAllocateAddress(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		reserve bool
+		want    []string
+	}{
+		{name: "all addresses", want: []string{"169.254.60.0", "169.254.60.1", "169.254.60.2", "169.254.60.3"}},
+		{name: "reserved network addresses", reserve: true, want: []string{"169.254.60.1", "169.254.60.2"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			pool := New(&Config{
+				StartRange:              "169.254.60.0",
+				EndRange:                "169.254.60.15",
+				PoolBlockSize:           4,
+				ReserveNetworkAddresses: test.reserve,
+			}, newTestStore(t))
+
+			block, err := pool.Allocate("vm-1", false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			holder := pool.WithHolder(block.HolderToken)
+			var addresses []string
+			for i := range test.want {
+				address, err := holder.AllocateAddress(block.Start, fmt.Sprintf("eth%d", i))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				addresses = append(addresses, address.Address)
+			}
+
+			if !equalStrings(addresses, test.want) {
+				t.Errorf("allocated addresses = %v, want %v", addresses, test.want)
+			}
+
+			if _, err := holder.AllocateAddress(block.Start, "eth9"); err != ErrBlockExhausted {
+				t.Errorf("AllocateAddress() in the full block error = %v, want %v", err, ErrBlockExhausted)
+			}
+
+			//an existing address key returns its address even in the full block
+			if address, err := holder.AllocateAddress(block.Start, "eth0"); err != nil || address.Address != test.want[0] {
+				t.Errorf("AllocateAddress(eth0) = %v, %v, want %s", address, err, test.want[0])
+			}
+		})
+	}
+}
+
+func TestFreeAddress(t *testing.T) {
+	pool := New(&Config{StartRange: "169.254.60.0", EndRange: "169.254.60.15", PoolBlockSize: 4, ReserveNetworkAddresses: true}, newTestStore(t))
+	block, err := pool.Allocate("vm-1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	holder := pool.WithHolder(block.HolderToken)
+	for _, key := range []string{"eth0", "eth1"} {
+		if _, err := holder.AllocateAddress(block.Start, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if address := pool.LookupAddress(block.Start, "", "eth1"); address == nil || address.Address != "169.254.60.2" {
+		t.Fatalf("LookupAddress(eth1) = %v, want 169.254.60.2", address)
+	}
+
+	if address := pool.LookupAddress(block.Start, "169.254.60.1", ""); address == nil || address.Key != "eth0" {
+		t.Fatalf("LookupAddress(169.254.60.1) = %v, want eth0", address)
+	}
+
+	//the reserved addresses are never allocated
+	if address := pool.LookupAddress(block.Start, "169.254.60.3", ""); address != nil {
+		t.Errorf("LookupAddress(169.254.60.3) = %v, want no address", address)
+	}
+
+	if err := pool.FreeAddress(block.Start, "169.254.60.1", ""); err != ErrHolderRequired {
+		t.Errorf("FreeAddress() without the holder token error = %v, want %v", err, ErrHolderRequired)
+	}
+
+	if err := holder.FreeAddress(block.Start, "", "eth0"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := holder.FreeAddress(block.Start, "", "eth0"); err != ErrAddressNotFound {
+		t.Errorf("FreeAddress() of the freed address error = %v, want %v", err, ErrAddressNotFound)
+	}
+
+	//the freed address is allocated again
+	address, err := holder.AllocateAddress(block.Start, "eth2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if address.Address != "169.254.60.1" {
+		t.Errorf("AllocateAddress(eth2) = %s, want 169.254.60.1", address.Address)
+	}
+
+	if err := holder.FreeAddress("169.254.60.4", "", "eth2"); err != ErrBlockNotFound {
+		t.Errorf("FreeAddress() in a free block error = %v, want %v", err, ErrBlockNotFound)
+	}
+}
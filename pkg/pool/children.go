@@ -0,0 +1,293 @@
+package pool
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	poolChildrenKeyPrefix = "children"
+)
+
+// Child pool errors
+var (
+	//
+	ErrInvalidPoolName = errors.New("Invalid pool name")
+	//
+	ErrPoolExists = errors.New("Pool already exists")
+	//
+	ErrPoolNotFound = errors.New("Pool not found")
+	//
+	ErrPoolInUse = errors.New("Pool has allocated blocks")
+	//
+	ErrBlockDelegated = errors.New("Block is delegated to a child pool")
+	//
+	ErrInvalidSize = errors.New("Invalid block size")
+	//
+	ErrChildPoolRange = errors.New("Child pool ranges are managed by the parent pool")
+)
+
+// ChildInfo contains the parent/child pool link persisted in the parent pool keyspace
+type ChildInfo struct {
+	Name    string    `json:"name"`
+	Block   string    `json:"block"`
+	Size    int64     `json:"size"`
+	Created time.Time `json:"created"`
+}
+
+// validSize returns true if the delegated block size is a power of two
+// and a multiple of the pool block size
+func (pool *Manager) validSize(size int64) bool {
+	return size > 0 && size&(size-1) == 0 && big.NewInt(0).Mod(big.NewInt(size), pool.blockSize()).Sign() == 0
+}
+
+// CreateChild allocates a block with the requested number of addresses (a power of two)
+// from the pool and creates a new child pool that manages the addresses in the block.
+// The child pool has its own block size (config.PoolBlockSize) and exclusions.
+func (pool *Manager) CreateChild(config *Config, size int64) (*Manager, error) {
+	if config == nil || config.Name == "" || config.Name == pool.name || strings.Contains(config.Name, "/") {
+		return nil, ErrInvalidPoolName
+	}
+
+	lock := pool.acquireLock("Pool.CreateChild")
+	defer lock.Unlock()
+
+	pool, err := pool.load()
+	if err != nil {
+		return nil, err
+	}
+
+	if !pool.validSize(size) {
+		return nil, ErrInvalidSize
+	}
+
+	blockSize := config.PoolBlockSize
+	if blockSize <= 0 {
+		blockSize = defaultPoolBlockSize
+	}
+
+	if blockSize > size {
+		return nil, ErrInvalidSize
+	}
+
+	childStore := pool.store.ForPool(config.Name)
+	if childStore.GetPool() != nil || pool.store.GetChild(config.Name) != nil {
+		return nil, ErrPoolExists
+	}
+
+	blockStart, err := pool.nextBlockFromRange(big.NewInt(size))
+	if err != nil {
+		return nil, err
+	}
+
+	block := NewBlockInfo(blockStart, config.Name)
+	block.Size = size
+	block.Pool = config.Name
+	pool.store.SaveBlock(block)
+
+	pool.store.SaveChild(&ChildInfo{
+		Name:    config.Name,
+		Block:   blockStart,
+		Size:    size,
+		Created: time.Now().UTC(),
+	})
+
+	//the child pool info is created here, so the child pool is restored from it in New()
+	end := newExtent(ipToInt(net.ParseIP(blockStart)), big.NewInt(size)).end
+	info := NewPoolInfo(blockStart, intToIP(end, isIPv6(net.ParseIP(blockStart))).String(), blockStart)
+	info.Exclude = config.Exclude
+	info.BlockSize = blockSize
+	info.Parent = pool.name
+	info.ParentBlock = blockStart
+	childStore.SavePool(info)
+
+	pool.logger.Info("Created child pool", "child", config.Name, "start", info.Start, "end", info.End)
+
+	childConfig := *config
+	childConfig.Ranges = nil
+	return New(&childConfig, pool.store), nil
+}
+
+// childManagers caches the child pool managers (shared by the pool manager copies)
+type childManagers struct {
+	sync.Mutex
+	managers map[string]*Manager
+}
+
+// Child returns the manager for the selected child pool
+// (the child pool manager is created once and reused while the child pool has the same block)
+func (pool *Manager) Child(name string) (*Manager, error) {
+	link := pool.store.GetChild(name)
+	if link == nil {
+		return nil, ErrPoolNotFound
+	}
+
+	if pool.children == nil {
+		return New(&Config{Name: name}, pool.store), nil
+	}
+
+	pool.children.Lock()
+	defer pool.children.Unlock()
+
+	child, ok := pool.children.managers[name]
+	if !ok || child.info.ParentBlock != link.Block {
+		child = New(&Config{Name: name}, pool.store)
+		pool.children.managers[name] = child
+	}
+
+	return child, nil
+}
+
+// Children returns the child pool links
+func (pool *Manager) Children() []*ChildInfo {
+	return pool.store.ListChildren()
+}
+
+// DeleteChild deletes the child pool and returns its block to the pool
+// (the child pool must not have any allocated blocks)
+func (pool *Manager) DeleteChild(name string) error {
+	lock := pool.acquireLock("Pool.DeleteChild")
+	defer lock.Unlock()
+
+	link := pool.store.GetChild(name)
+	if link == nil {
+		return ErrPoolNotFound
+	}
+
+	childStore := pool.store.ForPool(name)
+	childLock := childStore.GetLock()
+	lockCh, err := childLock.Lock(nil)
+	if err != nil {
+		panic(err)
+	}
+	if lockCh == nil {
+		panic("did not lock")
+	}
+
+	if len(childStore.ListBlocks()) > 0 || len(childStore.ListChildren()) > 0 {
+		childLock.Unlock()
+		return ErrPoolInUse
+	}
+
+	for _, p := range childStore.ListRecords(childStore.key() + "/") {
+		if p.Key != childStore.key(poolLockKey) {
+			childStore.RemoveRecord(p.Key)
+		}
+	}
+
+	childLock.Unlock()
+	if err := childLock.Destroy(); err != nil {
+		pool.logger.Warn("Can't remove the child pool lock", "child", name, "error", err)
+	}
+
+	if block := pool.store.GetBlock(link.Block); block != nil {
+		pool.store.RemoveBlock(block.Start)
+		pool.quarantineBlock(block)
+	}
+
+	pool.store.RemoveChild(name)
+
+	pool.logger.Info("Deleted child pool", "child", name, "block", link.Block)
+	return nil
+}
+
+// ShrinkChild reduces the child pool block to the requested number of addresses (a power of two)
+// returning the end of the block to the pool (the returned addresses must be unallocated in the child pool)
+func (pool *Manager) ShrinkChild(name string, size int64, dryRun bool) (*RangeChange, error) {
+	lock := pool.acquireLock("Pool.ShrinkChild")
+	defer lock.Unlock()
+
+	link := pool.store.GetChild(name)
+	if link == nil {
+		return nil, ErrPoolNotFound
+	}
+
+	pool, err := pool.load()
+	if err != nil {
+		return nil, err
+	}
+
+	if !pool.validSize(size) || size >= link.Size {
+		return nil, ErrInvalidSize
+	}
+
+	start := net.ParseIP(link.Block)
+	end := intToIP(newExtent(ipToInt(start), big.NewInt(size)).end, isIPv6(start))
+
+	child := New(&Config{Name: name}, pool.store)
+	if big.NewInt(size).Cmp(child.blockSize()) < 0 {
+		return nil, ErrInvalidSize
+	}
+
+	change, err := child.shrinkRange(end.String(), dryRun, true)
+	if err != nil || dryRun {
+		return change, err
+	}
+
+	block := pool.store.GetBlock(link.Block)
+	if block == nil {
+		return nil, ErrBlockNotFound
+	}
+
+	block.Size = size
+	pool.store.SaveBlock(block)
+
+	link.Size = size
+	pool.store.SaveChild(link)
+
+	pool.logger.Info("Shrunk child pool", "child", name, "start", link.Block, "end", end)
+	return change, nil
+}
+
+// ListChildren returns the child pool links
+func (s *Store) ListChildren() []*ChildInfo {
+	var records []*ChildInfo
+	for _, p := range s.ListRecords(s.key(poolChildrenKeyPrefix) + "/") {
+		var record ChildInfo
+		if err := json.Unmarshal(p.Value, &record); err != nil {
+			panic(err)
+		}
+
+		records = append(records, &record)
+	}
+
+	return records
+}
+
+// GetChild returns the child pool link selected by the child pool name
+func (s *Store) GetChild(name string) *ChildInfo {
+	raw := s.GetRecord(s.key(poolChildrenKeyPrefix, name))
+	if raw == nil {
+		return nil
+	}
+
+	var record ChildInfo
+	if err := json.Unmarshal(raw, &record); err != nil {
+		panic(err)
+	}
+
+	return &record
+}
+
+// SaveChild saves the provided child pool link
+func (s *Store) SaveChild(record *ChildInfo) {
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(record); err != nil {
+		panic(err)
+	}
+
+	s.SaveRecord(s.key(poolChildrenKeyPrefix, record.Name), buf.Bytes())
+}
+
+// RemoveChild removes the child pool link selected by the child pool name
+func (s *Store) RemoveChild(name string) {
+	s.RemoveRecord(s.key(poolChildrenKeyPrefix, name))
+}
@@ -0,0 +1,180 @@
+package pool
+
+import (
+	"net"
+	"testing"
+)
+
+func TestCreateChild(t *testing.T) {
+	pool := New(&Config{Name: "edge", StartRange: "169.254.60.0", EndRange: "169.254.60.63", PoolBlockSize: 4}, newTestStore(t))
+	for _, test := range []struct {
+		name   string
+		config *Config
+		size   int64
+		err    error
+	}{
+		{name: "no name", config: &Config{PoolBlockSize: 4}, size: 16, err: ErrInvalidPoolName},
+		{name: "parent name", config: &Config{Name: "edge", PoolBlockSize: 4}, size: 16, err: ErrInvalidPoolName},
+		{name: "not a power of two", config: &Config{Name: "region-a", PoolBlockSize: 4}, size: 12, err: ErrInvalidSize},
+		{name: "smaller than the pool block", config: &Config{Name: "region-a", PoolBlockSize: 2}, size: 2, err: ErrInvalidSize},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := pool.CreateChild(test.config, test.size); err != test.err {
+				t.Errorf("CreateChild() error = %v, want %v", err, test.err)
+			}
+		})
+	}
+
+	child, err := pool.CreateChild(&Config{Name: "region-a", PoolBlockSize: 4}, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := pool.CreateChild(&Config{Name: "region-a", PoolBlockSize: 4}, 16); err != ErrPoolExists {
+		t.Errorf("CreateChild() of an existing child pool error = %v, want %v", err, ErrPoolExists)
+	}
+
+	children := pool.Children()
+	if len(children) != 1 || children[0].Name != "region-a" || children[0].Size != 16 {
+		t.Fatalf("Children() = %+v, want region-a with 16 addresses", children)
+	}
+
+	//the child pool blocks are allocated in the delegated block
+	link := children[0]
+	delegated := newExtent(ipToInt(net.ParseIP(link.Block)), pool.sizeOf(&BlockInfo{Size: link.Size}))
+	for _, key := range []string{"vm-1", "vm-2"} {
+		block, err := child.Allocate(key, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if start := ipToInt(net.ParseIP(block.Start)); start.Cmp(delegated.start) < 0 || start.Cmp(delegated.end) > 0 {
+			t.Errorf("child pool block %s is outside the delegated block %s", block.Start, link.Block)
+		}
+	}
+
+	if err := pool.WithHolderOverride().Free(link.Block, ""); err != ErrBlockDelegated {
+		t.Errorf("Free() of the delegated block error = %v, want %v", err, ErrBlockDelegated)
+	}
+
+	if err := pool.DeleteChild("region-a"); err != ErrPoolInUse {
+		t.Errorf("DeleteChild() with the allocated blocks error = %v, want %v", err, ErrPoolInUse)
+	}
+
+	if _, err := pool.ShrinkChild("region-a", 16, false); err != ErrInvalidSize {
+		t.Errorf("ShrinkChild() to the same size error = %v, want %v", err, ErrInvalidSize)
+	}
+
+	//the child pool blocks are at the start of the delegated block
+	if _, err := pool.ShrinkChild("region-a", 8, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if block := pool.Lookup(link.Block, ""); block == nil || block.Size != 8 || pool.Children()[0].Size != 8 {
+		t.Errorf("Lookup(%s) after ShrinkChild() = %+v, want the delegated block with 8 addresses", link.Block, block)
+	}
+
+	for _, key := range []string{"vm-1", "vm-2"} {
+		if err := child.WithHolderOverride().Free("", key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := pool.DeleteChild("region-a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if children := pool.Children(); len(children) != 0 {
+		t.Errorf("Children() after DeleteChild() = %+v, want no child pools", children)
+	}
+
+	if block := pool.Lookup(link.Block, ""); block != nil {
+		t.Errorf("Lookup(%s) after DeleteChild() = %+v, want the block returned to the pool", link.Block, block)
+	}
+}
+
+func TestChildManagerReuse(t *testing.T) {
+	pool := New(&Config{StartRange: "169.254.60.0", EndRange: "169.254.60.63", PoolBlockSize: 4}, newTestStore(t))
+	if _, err := pool.CreateChild(&Config{Name: "region-a", PoolBlockSize: 4}, 16); err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := pool.Child("region-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the pool manager copies share the child pool managers
+	second, err := pool.WithHolderOverride().Child("region-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first != second {
+		t.Error("Child() created a new child pool manager for the same child pool")
+	}
+
+	if err := pool.DeleteChild("region-a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := pool.Child("region-a"); err != ErrPoolNotFound {
+		t.Fatalf("Child() of the deleted child pool error = %v, want %v", err, ErrPoolNotFound)
+	}
+
+	//a new child pool with the same name gets a new manager
+	if _, err := pool.CreateChild(&Config{Name: "other", PoolBlockSize: 4}, 16); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := pool.CreateChild(&Config{Name: "region-a", PoolBlockSize: 4}, 16); err != nil {
+		t.Fatal(err)
+	}
+
+	recreated, err := pool.Child("region-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if recreated == first {
+		t.Error("Child() reused the manager of the deleted child pool")
+	}
+}
+
+func TestChildStatsRollup(t *testing.T) {
+	pool := New(&Config{StartRange: "169.254.60.0", EndRange: "169.254.60.63", PoolBlockSize: 4}, newTestStore(t))
+	if _, err := pool.Allocate("vm-1", false); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := pool.CreateChild(&Config{Name: "region-a", PoolBlockSize: 4}, 16); err != nil {
+		t.Fatal(err)
+	}
+
+	child, err := pool.Child("region-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"vm-2", "vm-3"} {
+		if _, err := child.Allocate(key, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats, err := pool.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the delegated block is allocated in the parent pool, the child pool blocks are rolled up by address
+	if stats.AllocatedBlocks != 5 || stats.DelegatedBlocks != 4 || len(stats.Children) != 1 {
+		t.Errorf("Stats() = %d allocated, %d delegated, %d children, want 5, 4, 1",
+			stats.AllocatedBlocks, stats.DelegatedBlocks, len(stats.Children))
+	}
+
+	if stats.AllocatedAddresses == nil || stats.AllocatedAddresses.Int64() != 12 || stats.RollupUtilization != 12.0/64 {
+		t.Errorf("Stats() = %v allocated addresses, %v rollup utilization, want 12, %v",
+			stats.AllocatedAddresses, stats.RollupUtilization, 12.0/64)
+	}
+}
@@ -0,0 +1,365 @@
+package pool
+
+import (
+	"errors"
+	"math/big"
+	"net"
+	"sort"
+	"time"
+)
+
+// Defragmentation errors
+var (
+	//
+	ErrDefragNotPossible = errors.New("No region can be freed by moving blocks")
+	//
+	ErrInvalidPlan = errors.New("Defragmentation plan is for another pool")
+	//
+	ErrStaleMove = errors.New("Block changed since the defragmentation plan was created")
+	//
+	ErrMoveTargetUsed = errors.New("Move target is not free")
+)
+
+// DefragMove relocates one IP Block (the block keeps its ID and key)
+type DefragMove struct {
+	Block   string `json:"block"`
+	Key     string `json:"key,omitempty"`
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Size    int64  `json:"size,omitempty"`
+	Applied bool   `json:"applied,omitempty"`
+}
+
+// DefragPlan contains the block moves that free a contiguous (aligned) region in the pool
+type DefragPlan struct {
+	Pool    string    `json:"pool"`
+	Created time.Time `json:"created"`
+	//Size is the number of addresses in the region
+	Size  int64         `json:"size"`
+	Start string        `json:"start"`
+	End   string        `json:"end"`
+	Moves []*DefragMove `json:"moves"`
+}
+
+// DefragHook notifies the block holders about the moves
+// (a BeforeMove error stops the plan before the block is moved)
+type DefragHook interface {
+	BeforeMove(move *DefragMove) error
+	AfterMove(move *DefragMove) error
+}
+
+// defragWindow is an aligned region of the requested size and the blocks that need to move out of it
+type defragWindow struct {
+	extent
+	ipv6   bool
+	blocks []*BlockInfo
+	moved  *big.Int
+}
+
+// PlanDefrag proposes the minimal set of block moves that frees a contiguous region
+// with the requested number of addresses (a power of two and a multiple of the pool block size).
+// The plan has no moves if the region is already free.
+func (pool *Manager) PlanDefrag(size int64) (*DefragPlan, error) {
+	if !pool.validSize(size) {
+		return nil, ErrInvalidSize
+	}
+
+	pool, err := pool.load()
+	if err != nil {
+		return nil, err
+	}
+
+	target := big.NewInt(size)
+	plan := &DefragPlan{
+		Pool:    pool.name,
+		Created: time.Now().UTC(),
+		Size:    size,
+		Moves:   []*DefragMove{},
+	}
+
+	view := pool.allocationView(target)
+	if start := (&FirstFitStrategy{}).Select(view); start != nil {
+		plan.setRegion(start, target)
+		return plan, nil
+	}
+
+	blocks := pool.store.ListBlocks()
+
+	var windows []*defragWindow
+	for _, l := range pool.layout() {
+		windows = append(windows, pool.defragWindows(l, target, blocks)...)
+	}
+
+	//the fewest moves first (and then the fewest moved addresses)
+	sort.SliceStable(windows, func(i, j int) bool {
+		if len(windows[i].blocks) != len(windows[j].blocks) {
+			return len(windows[i].blocks) < len(windows[j].blocks)
+		}
+
+		return windows[i].moved.Cmp(windows[j].moved) < 0
+	})
+
+	for _, w := range windows {
+		if moves := pool.placeBlocks(view.Free, w); moves != nil {
+			plan.setRegion(intToIP(w.start, w.ipv6), target)
+			plan.Moves = moves
+			return plan, nil
+		}
+	}
+
+	return nil, ErrDefragNotPossible
+}
+
+func (plan *DefragPlan) setRegion(start net.IP, size *big.Int) {
+	plan.Start = start.String()
+	plan.End = intToIP(newExtent(ipToInt(start), size).end, isIPv6(start)).String()
+}
+
+// defragWindows returns the aligned regions in the range that can be freed by moving the blocks in them
+// (the regions with excluded or quarantined addresses and with delegated, paired or grouped blocks are skipped)
+func (pool *Manager) defragWindows(l *rangeLayout, target *big.Int, blocks []*BlockInfo) []*defragWindow {
+	//the regions are aligned the same way the allocations are (see AllocationView.Fit)
+	base := l.rng.start
+	if target.Cmp(l.blockSize) > 0 {
+		base = big.NewInt(0)
+	}
+
+	windowStart := func(val *big.Int) *big.Int {
+		offset := big.NewInt(0).Sub(val, base)
+		return big.NewInt(0).Sub(val, offset.Mod(offset, target))
+	}
+
+	var blocked []extent
+	blocked = append(blocked, l.excluded...)
+	blocked = append(blocked, l.quarantined...)
+
+	windows := map[string]*defragWindow{}
+	var order []string
+	for _, block := range blocks {
+		start := net.ParseIP(block.Start)
+		if start == nil || isIPv6(start) != l.rng.ipv6 {
+			continue
+		}
+
+		e := newExtent(ipToInt(start), pool.sizeOf(block))
+		if !l.rng.overlaps(e.start, e.end) {
+			continue
+		}
+
+		ws := windowStart(e.start)
+		if standalone(block) != nil || newExtent(ws, target).end.Cmp(e.end) < 0 {
+			blocked = append(blocked, e)
+			continue
+		}
+
+		w, ok := windows[ws.String()]
+		if !ok {
+			w = &defragWindow{extent: newExtent(ws, target), ipv6: l.rng.ipv6, moved: big.NewInt(0)}
+			windows[ws.String()] = w
+			order = append(order, ws.String())
+		}
+
+		w.blocks = append(w.blocks, block)
+		w.moved.Add(w.moved, e.size())
+	}
+
+	limit := l.lastBlockEnd()
+
+	var usable []*defragWindow
+	for _, id := range order {
+		w := windows[id]
+		if w.start.Cmp(l.rng.start) < 0 || w.end.Cmp(limit) > 0 {
+			continue
+		}
+
+		free := true
+		for _, b := range blocked {
+			if b.start.Cmp(w.end) <= 0 && b.end.Cmp(w.start) >= 0 {
+				free = false
+				break
+			}
+		}
+
+		if free {
+			usable = append(usable, w)
+		}
+	}
+
+	return usable
+}
+
+// placeBlocks selects the new locations (outside of the window) for the blocks in the window
+// (nil if the blocks don't fit in the free space outside of the window)
+func (pool *Manager) placeBlocks(free []*FreeRun, w *defragWindow) []*DefragMove {
+	var runs []*FreeRun
+	for _, run := range free {
+		runs = append(runs, subtractRun(run, w.extent)...)
+	}
+
+	//the largest blocks first (they are the hardest to place)
+	blocks := make([]*BlockInfo, len(w.blocks))
+	copy(blocks, w.blocks)
+	sort.SliceStable(blocks, func(i, j int) bool {
+		return pool.sizeOf(blocks[i]).Cmp(pool.sizeOf(blocks[j])) > 0
+	})
+
+	moves := []*DefragMove{}
+	for _, block := range blocks {
+		from := net.ParseIP(block.Start)
+		view := &AllocationView{BlockSize: pool.blockSize(), Size: pool.sizeOf(block)}
+		for _, run := range runs {
+			if isIPv6(run.Start) == isIPv6(from) {
+				view.Free = append(view.Free, run)
+			}
+		}
+
+		//the smallest free runs are filled first
+		to := (&BestFitStrategy{}).Select(view)
+		if to == nil {
+			return nil
+		}
+
+		used := newExtent(ipToInt(to), view.Size)
+		var left []*FreeRun
+		for _, run := range runs {
+			if isIPv6(run.Start) != isIPv6(to) {
+				left = append(left, run)
+				continue
+			}
+
+			left = append(left, subtractRun(run, used)...)
+		}
+		runs = left
+
+		moves = append(moves, &DefragMove{
+			Block: block.ID,
+			Key:   block.Key,
+			From:  block.Start,
+			To:    to.String(),
+			Size:  block.Size,
+		})
+	}
+
+	return moves
+}
+
+// subtractRun returns the parts of the free run outside of the extent
+func subtractRun(run *FreeRun, e extent) []*FreeRun {
+	r := newExtent(ipToInt(run.Start), run.Size)
+	if r.start.Cmp(e.end) > 0 || r.end.Cmp(e.start) < 0 {
+		return []*FreeRun{run}
+	}
+
+	ipv6 := isIPv6(run.Start)
+
+	var parts []*FreeRun
+	if r.start.Cmp(e.start) < 0 {
+		parts = append(parts, &FreeRun{
+			Start: run.Start,
+			Size:  big.NewInt(0).Sub(e.start, r.start),
+		})
+	}
+
+	if r.end.Cmp(e.end) > 0 {
+		parts = append(parts, &FreeRun{
+			Start: intToIP(big.NewInt(0).Add(e.end, big.NewInt(1)), ipv6),
+			Size:  big.NewInt(0).Sub(r.end, e.end),
+		})
+	}
+
+	return parts
+}
+
+// ApplyDefrag applies the plan moves one by one (the applied moves are marked and skipped,
+// so a partially applied plan can be applied again). The limit is the maximum number of moves (0 means all).
+func (pool *Manager) ApplyDefrag(plan *DefragPlan, hook DefragHook, limit int) (int, error) {
+	if plan.Pool != pool.name {
+		return 0, ErrInvalidPlan
+	}
+
+	applied := 0
+	for _, move := range plan.Moves {
+		if move.Applied {
+			continue
+		}
+
+		if limit > 0 && applied >= limit {
+			break
+		}
+
+		if hook != nil {
+			if err := hook.BeforeMove(move); err != nil {
+				return applied, err
+			}
+		}
+
+		if err := pool.ApplyMove(move); err != nil {
+			return applied, err
+		}
+
+		move.Applied = true
+		applied++
+
+		if hook != nil {
+			if err := hook.AfterMove(move); err != nil {
+				return applied, err
+			}
+		}
+	}
+
+	return applied, nil
+}
+
+// ApplyMove moves the IP Block (and its addresses) to the new location.
+// The old location is not quarantined (the holders are notified by the defragmentation hooks)
+// and the held blocks are moved only with the holder token or the holder override.
+func (pool *Manager) ApplyMove(move *DefragMove) error {
+	lock := pool.acquireLock("Pool.ApplyMove")
+	defer lock.Unlock()
+
+	pool, err := pool.load()
+	if err != nil {
+		return err
+	}
+
+	block := pool.store.GetBlock(move.From)
+	if block == nil || block.ID != move.Block || block.Size != move.Size || standalone(block) != nil {
+		return ErrStaleMove
+	}
+
+	if err := pool.checkHolder(block); err != nil {
+		return err
+	}
+
+	from := net.ParseIP(block.Start)
+	to := net.ParseIP(move.To)
+	if to == nil || isIPv6(to) != isIPv6(from) {
+		return ErrMoveTargetUsed
+	}
+
+	size := pool.sizeOf(block)
+	target := newExtent(ipToInt(to), size)
+	if !pool.extentFree(target) {
+		return ErrMoveTargetUsed
+	}
+
+	pool.removeQuarantine(target)
+
+	moved := *block
+	moved.Start = to.String()
+	pool.store.SaveBlock(&moved)
+
+	for _, address := range pool.store.ListAddresses(block.Start) {
+		offset := big.NewInt(0).Sub(ipToInt(net.ParseIP(address.Address)), ipToInt(from))
+		address.Block = moved.Start
+		address.Address = addToIP(to, offset).String()
+		pool.store.SaveAddress(address)
+	}
+
+	pool.store.RemoveAddresses(block.Start)
+	pool.store.RemoveBlock(block.Start)
+	pool.advanceNext(to, size)
+
+	pool.logger.Info("Moved IP block", "from", move.From, "to", move.To)
+	return nil
+}
@@ -0,0 +1,139 @@
+package pool
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// newTestDefragPool returns a full pool with 8 blocks and the blocks 1, 3, 4 and 6 freed,
+// so a region with 16 addresses is freed by 2 moves
+func newTestDefragPool(t *testing.T) *Manager {
+	pool := New(&Config{StartRange: "169.254.60.0", EndRange: "169.254.60.31", PoolBlockSize: 4}, newTestStore(t))
+	for i := 0; i < 8; i++ {
+		if _, err := pool.Allocate(fmt.Sprintf("vm-%d", i), false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, i := range []int{1, 3, 4, 6} {
+		if err := pool.WithHolderOverride().Free("", fmt.Sprintf("vm-%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return pool.WithHolderOverride()
+}
+
+// recordingHook records the hook calls and fails the BeforeMove calls with the before error
+type recordingHook struct {
+	calls  []string
+	before error
+}
+
+func (h *recordingHook) BeforeMove(move *DefragMove) error {
+	h.calls = append(h.calls, "before "+move.Key)
+	return h.before
+}
+
+func (h *recordingHook) AfterMove(move *DefragMove) error {
+	h.calls = append(h.calls, "after "+move.Key)
+	return nil
+}
+
+func TestApplyDefragLimit(t *testing.T) {
+	pool := newTestDefragPool(t)
+	plan, err := pool.PlanDefrag(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(plan.Moves) != 2 {
+		t.Fatalf("PlanDefrag() = %d moves, want 2", len(plan.Moves))
+	}
+
+	hook := &recordingHook{}
+	if applied, err := pool.ApplyDefrag(plan, hook, 1); applied != 1 || err != nil {
+		t.Fatalf("ApplyDefrag() with limit 1 = %d, %v, want 1 move", applied, err)
+	}
+
+	if !plan.Moves[0].Applied || plan.Moves[1].Applied {
+		t.Fatalf("ApplyDefrag() with limit 1 marked %+v, %+v, want only the first move", plan.Moves[0], plan.Moves[1])
+	}
+
+	//the applied moves are skipped when the plan is applied again
+	if applied, err := pool.ApplyDefrag(plan, hook, 0); applied != 1 || err != nil {
+		t.Fatalf("ApplyDefrag() of the partially applied plan = %d, %v, want 1 move", applied, err)
+	}
+
+	want := []string{
+		"before " + plan.Moves[0].Key, "after " + plan.Moves[0].Key,
+		"before " + plan.Moves[1].Key, "after " + plan.Moves[1].Key,
+	}
+	if !equalStrings(hook.calls, want) {
+		t.Errorf("hook calls = %v, want %v", hook.calls, want)
+	}
+
+	for _, move := range plan.Moves {
+		if block := pool.Lookup("", move.Key); block == nil || block.Start != move.To || block.ID != move.Block {
+			t.Errorf("Lookup(%s) = %+v, want the block moved to %s", move.Key, block, move.To)
+		}
+	}
+
+	if region := pool.Lookup(plan.Start, ""); region != nil {
+		t.Errorf("Lookup(%s) = %+v, want the freed region", plan.Start, region)
+	}
+}
+
+func TestApplyDefragStaleMove(t *testing.T) {
+	pool := newTestDefragPool(t)
+	plan, err := pool.PlanDefrag(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pool.Free(plan.Moves[0].From, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if applied, err := pool.ApplyDefrag(plan, nil, 0); applied != 0 || err != ErrStaleMove {
+		t.Errorf("ApplyDefrag() of the freed block = %d, %v, want %v", applied, err, ErrStaleMove)
+	}
+
+	plan.Pool = "other"
+	if _, err := pool.ApplyDefrag(plan, nil, 0); err != ErrInvalidPlan {
+		t.Errorf("ApplyDefrag() of another pool plan error = %v, want %v", err, ErrInvalidPlan)
+	}
+}
+
+func TestApplyDefragHookError(t *testing.T) {
+	pool := newTestDefragPool(t)
+	plan, err := pool.PlanDefrag(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hookErr := errors.New("holder not ready")
+	if applied, err := pool.ApplyDefrag(plan, &recordingHook{before: hookErr}, 0); applied != 0 || err != hookErr {
+		t.Fatalf("ApplyDefrag() with a failing hook = %d, %v, want %v", applied, err, hookErr)
+	}
+
+	if block := pool.Lookup("", plan.Moves[0].Key); block == nil || block.Start != plan.Moves[0].From || plan.Moves[0].Applied {
+		t.Errorf("Lookup(%s) = %+v, want the block not moved", plan.Moves[0].Key, block)
+	}
+}
+
+func TestApplyMoveHolder(t *testing.T) {
+	pool := newTestDefragPool(t)
+	plan, err := pool.PlanDefrag(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the held blocks are moved only with the holder token or the holder override
+	scoped := *pool
+	scoped.holderOverride = false
+	if err := scoped.ApplyMove(plan.Moves[0]); err != ErrHolderRequired {
+		t.Errorf("ApplyMove() without the holder token error = %v, want %v", err, ErrHolderRequired)
+	}
+}
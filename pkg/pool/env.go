@@ -0,0 +1,166 @@
+package pool
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kcq/poc-ipblock-pool/pkg/logging"
+)
+
+const (
+	defaultConsulAddress = "127.0.0.1:8500"
+	defaultV6Name        = "ipv6"
+	defaultV6BlockPrefix = 64
+)
+
+// ConfigFromEnv returns the pool configuration from the CONSUL_ADDR and POOL_* env vars
+// (the other Store options are set by the caller: the server reads them from the env vars
+// and the cli from its flags). The logger is the pool logger and it logs the env var values.
+func ConfigFromEnv(logger logging.Logger) (*Config, error) {
+	if logger == nil {
+		logger = logging.Nop()
+	}
+
+	config := &Config{
+		StartRange:    defaultStartRange,
+		EndRange:      defaultEndRange,
+		PoolBlockSize: defaultPoolBlockSize,
+		Store: &StoreConfig{
+			Address: defaultConsulAddress,
+		},
+		Logger: logger,
+	}
+
+	if consulAddr, ok := os.LookupEnv("CONSUL_ADDR"); ok {
+		config.Store.Address = consulAddr
+		logger.Info("Using Consul address from environment", "value", consulAddr)
+	}
+
+	if name, ok := lookupEnv("POOL_NAME"); ok {
+		config.Name = name
+		logger.Info("Using pool name from environment", "value", name)
+	}
+
+	if ranges, ok := lookupEnv("POOL_RANGES"); ok {
+		config.Ranges = strings.Split(ranges, ",")
+		logger.Info("Using pool ranges from environment", "value", ranges)
+	}
+
+	if exclude, ok := lookupEnv("POOL_EXCLUDE"); ok {
+		config.Exclude = strings.Split(exclude, ",")
+		logger.Info("Using pool exclusions from environment", "value", exclude)
+	}
+
+	if quarantine, ok := lookupEnv("POOL_QUARANTINE"); ok {
+		period, err := time.ParseDuration(quarantine)
+		if err != nil {
+			return nil, envError("POOL_QUARANTINE", err)
+		}
+
+		config.QuarantinePeriod = period
+		logger.Info("Using pool quarantine period from environment", "value", period)
+	}
+
+	if reserve, ok := lookupEnv("POOL_RESERVE_NETWORK_ADDRESSES"); ok {
+		value, err := strconv.ParseBool(reserve)
+		if err != nil {
+			return nil, envError("POOL_RESERVE_NETWORK_ADDRESSES", err)
+		}
+
+		config.ReserveNetworkAddresses = value
+		logger.Info("Using network address reservation from environment", "value", value)
+	}
+
+	if strategy, ok := lookupEnv("POOL_STRATEGY"); ok {
+		config.Strategy = strategy
+		logger.Info("Using allocation strategy from environment", "value", strategy)
+	}
+
+	if seed, ok := lookupEnv("POOL_STRATEGY_SEED"); ok {
+		value, err := strconv.ParseInt(seed, 10, 64)
+		if err != nil {
+			return nil, envError("POOL_STRATEGY_SEED", err)
+		}
+
+		config.StrategySeed = value
+		logger.Info("Using allocation strategy seed from environment", "value", value)
+	}
+
+	if supernet, ok := lookupEnv("POOL_SUPERNET"); ok {
+		config.Supernet = supernet
+		logger.Info("Using pool supernet from environment", "value", supernet)
+	}
+
+	if size, ok := lookupEnv("POOL_GROWTH_SIZE"); ok {
+		value, err := strconv.ParseInt(size, 10, 64)
+		if err != nil {
+			return nil, envError("POOL_GROWTH_SIZE", err)
+		}
+
+		config.GrowthSize = value
+		logger.Info("Using pool growth size from environment", "value", value)
+	}
+
+	if threshold, ok := lookupEnv("POOL_GROWTH_THRESHOLD"); ok {
+		value, err := strconv.ParseFloat(threshold, 64)
+		if err != nil {
+			return nil, envError("POOL_GROWTH_THRESHOLD", err)
+		}
+
+		config.GrowthThreshold = value
+		logger.Info("Using pool growth threshold from environment", "value", value)
+	}
+
+	return config, nil
+}
+
+// V6ConfigFromEnv returns the IPv6 pool configuration for the dual-stack pairs
+// from the POOL_V6_* env vars (nil if POOL_V6_RANGES is not set).
+// The IPv6 pool uses the Store and the logger of the IPv4 pool configuration.
+func V6ConfigFromEnv(config *Config) (*Config, error) {
+	ranges, ok := lookupEnv("POOL_V6_RANGES")
+	if !ok {
+		return nil, nil
+	}
+
+	v6Config := &Config{
+		Name:        defaultV6Name,
+		Ranges:      strings.Split(ranges, ","),
+		BlockPrefix: defaultV6BlockPrefix,
+		Store:       config.Store,
+		Logger:      config.Logger,
+	}
+
+	if name, ok := lookupEnv("POOL_V6_NAME"); ok {
+		v6Config.Name = name
+	}
+
+	if prefix, ok := lookupEnv("POOL_V6_BLOCK_PREFIX"); ok {
+		value, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, envError("POOL_V6_BLOCK_PREFIX", err)
+		}
+
+		v6Config.BlockPrefix = value
+	}
+
+	if config.Logger != nil {
+		config.Logger.Info("Using IPv6 pool from environment",
+			"name", v6Config.Name, "ranges", ranges, "block_prefix", v6Config.BlockPrefix)
+	}
+
+	return v6Config, nil
+}
+
+// lookupEnv returns the env var value (the empty values are ignored)
+func lookupEnv(key string) (string, bool) {
+	value, ok := os.LookupEnv(key)
+	return value, ok && value != ""
+}
+
+func envError(key string, err error) error {
+	return fmt.Errorf("invalid %s value: %v", key, err)
+}
@@ -0,0 +1,62 @@
+package pool
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv("CONSUL_ADDR", "consul:8500")
+	t.Setenv("POOL_NAME", "edge")
+	t.Setenv("POOL_RANGES", "10.0.0.0/24,10.0.2.0/24")
+	t.Setenv("POOL_QUARANTINE", "1h")
+	t.Setenv("POOL_STRATEGY", StrategyRandom)
+	t.Setenv("POOL_STRATEGY_SEED", "42")
+	t.Setenv("POOL_EXCLUDE", "")
+
+	config, err := ConfigFromEnv(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Store.Address != "consul:8500" || config.Name != "edge" || len(config.Ranges) != 2 ||
+		config.QuarantinePeriod != time.Hour || config.Strategy != StrategyRandom || config.StrategySeed != 42 {
+		t.Errorf("ConfigFromEnv() = %+v, want the env var values", config)
+	}
+
+	//the empty values are ignored
+	if config.Exclude != nil || config.PoolBlockSize != defaultPoolBlockSize {
+		t.Errorf("ConfigFromEnv() = exclude %v, block size %d, want the defaults", config.Exclude, config.PoolBlockSize)
+	}
+
+	if v6Config, err := V6ConfigFromEnv(config); v6Config != nil || err != nil {
+		t.Errorf("V6ConfigFromEnv() = %+v, %v, want no IPv6 pool", v6Config, err)
+	}
+
+	t.Setenv("POOL_V6_RANGES", "fd00::/56")
+	v6Config, err := V6ConfigFromEnv(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v6Config.Name != defaultV6Name || v6Config.BlockPrefix != defaultV6BlockPrefix || v6Config.Store != config.Store {
+		t.Errorf("V6ConfigFromEnv() = %+v, want the default IPv6 pool with the IPv4 pool store", v6Config)
+	}
+}
+
+func TestConfigFromEnvErrors(t *testing.T) {
+	for _, key := range []string{
+		"POOL_QUARANTINE",
+		"POOL_RESERVE_NETWORK_ADDRESSES",
+		"POOL_STRATEGY_SEED",
+		"POOL_GROWTH_SIZE",
+		"POOL_GROWTH_THRESHOLD",
+	} {
+		t.Run(key, func(t *testing.T) {
+			t.Setenv(key, "many")
+			if _, err := ConfigFromEnv(nil); err == nil {
+				t.Errorf("ConfigFromEnv() with an invalid %s succeeded", key)
+			}
+		})
+	}
+}
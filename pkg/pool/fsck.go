@@ -0,0 +1,417 @@
+package pool
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	poolLostFoundKeyPrefix = "lost+found"
+	poolCheckKeyPrefix     = "fsck"
+)
+
+// Pool consistency issue kinds
+const (
+	IssueInvalidPoolInfo       = "invalid-pool-info"
+	IssueInvalidNext           = "invalid-next"
+	IssueUndecodable           = "undecodable-record"
+	IssueInvalidStart          = "invalid-start"
+	IssueKeyMismatch           = "key-mismatch"
+	IssueOutOfRange            = "out-of-range"
+	IssueMisaligned            = "misaligned"
+	IssueBeyondNext            = "beyond-next"
+	IssueExcluded              = "excluded"
+	IssueDuplicateKey          = "duplicate-key"
+	IssueDuplicateID           = "duplicate-id"
+	IssueAddressUndecodable    = "undecodable-address"
+	IssueAddressOrphaned       = "orphaned-address"
+	IssueAddressOutside        = "address-outside-block"
+	IssueAddressDuplicateKey   = "duplicate-address-key"
+	IssueQuarantineUndecodable = "undecodable-quarantine"
+	IssueQuarantineAllocated   = "allocated-in-quarantine"
+	IssueQuarantineExpired     = "expired-quarantine"
+	IssueChildLinkMissing      = "missing-child-link"
+	IssueChildLinkOrphaned     = "orphaned-child-link"
+)
+
+// CheckIssue describes a pool data inconsistency
+type CheckIssue struct {
+	Kind     string `json:"kind"`
+	Key      string `json:"key"`
+	Block    string `json:"block,omitempty"`
+	Detail   string `json:"detail"`
+	Repaired bool   `json:"repaired"`
+	Action   string `json:"action,omitempty"`
+}
+
+// CheckReport contains the pool consistency check results
+type CheckReport struct {
+	Started  time.Time     `json:"started"`
+	Finished time.Time     `json:"finished"`
+	Repair   bool          `json:"repair"`
+	Issues   []*CheckIssue `json:"issues"`
+	Repaired int           `json:"repaired"`
+	//ReportKey is the Store key where the report is saved (only when something was repaired)
+	ReportKey string `json:"report_key,omitempty"`
+}
+
+// Consistent returns true if no issues are left unrepaired
+func (r *CheckReport) Consistent() bool {
+	return r.Repaired == len(r.Issues)
+}
+
+type checker struct {
+	pool   *Manager
+	repair bool
+	report *CheckReport
+}
+
+func (c *checker) issue(kind, key, block, detail string) *CheckIssue {
+	issue := &CheckIssue{
+		Kind:   kind,
+		Key:    key,
+		Block:  block,
+		Detail: detail,
+	}
+
+	c.report.Issues = append(c.report.Issues, issue)
+	return issue
+}
+
+func (c *checker) repaired(issue *CheckIssue, action string) {
+	issue.Repaired = true
+	issue.Action = action
+	c.report.Repaired++
+}
+
+// moveToLostFound moves an unusable record out of the pool keyspace (keeping its raw value)
+func (c *checker) moveToLostFound(issue *CheckIssue, key string, raw []byte) {
+	if !c.repair {
+		return
+	}
+
+	target := c.pool.store.key(poolLostFoundKeyPrefix, strings.Replace(key, "/", "_", -1))
+	c.pool.store.SaveRecord(target, raw)
+	c.pool.store.RemoveRecord(key)
+	c.repaired(issue, "moved to "+target)
+}
+
+// Check validates the data in the Pool Store and reports every inconsistency it finds.
+// In the repair mode it also fixes the issues that can be fixed safely
+// and saves the report in the Store to record what was changed.
+func (pool *Manager) Check(repair bool) *CheckReport {
+	lock := pool.acquireLock("Pool.Check")
+	defer lock.Unlock()
+
+	c := &checker{
+		pool:   pool,
+		repair: repair,
+		report: &CheckReport{
+			Started: time.Now().UTC(),
+			Repair:  repair,
+			Issues:  []*CheckIssue{},
+		},
+	}
+
+	rng, next := c.checkInfo()
+	blocks := c.checkBlocks(rng, next)
+	c.checkAddresses(blocks)
+	c.checkQuarantine(blocks)
+	c.checkChildren(blocks)
+
+	c.report.Finished = time.Now().UTC()
+
+	if repair && c.report.Repaired > 0 {
+		c.report.ReportKey = pool.store.key(poolCheckKeyPrefix, c.report.Started.Format(time.RFC3339Nano))
+
+		buf := &bytes.Buffer{}
+		enc := json.NewEncoder(buf)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(c.report); err != nil {
+			panic(err)
+		}
+
+		pool.store.SaveRecord(c.report.ReportKey, buf.Bytes())
+	}
+
+	return c.report
+}
+
+// checkInfo validates the pool metadata and returns the pool ranges and the next block value
+func (c *checker) checkInfo() ([]*ipRange, *big.Int) {
+	infoKey := c.pool.store.key(poolInfoKey)
+	raw := c.pool.store.GetRecord(infoKey)
+	if raw == nil {
+		c.issue(IssueInvalidPoolInfo, infoKey, "", "pool info record is missing")
+		return nil, nil
+	}
+
+	var info Info
+	if err := json.Unmarshal(raw, &info); err != nil {
+		c.issue(IssueInvalidPoolInfo, infoKey, "", err.Error())
+		return nil, nil
+	}
+
+	var ranges []*ipRange
+	for _, r := range info.RangeList() {
+		rng, err := newIPRange(r.Start, r.End)
+		if err != nil {
+			c.issue(IssueInvalidPoolInfo, infoKey, "",
+				fmt.Sprintf("invalid pool range (start=%s end=%s)", r.Start, r.End))
+			return nil, nil
+		}
+
+		if n := len(ranges); n > 0 &&
+			(ranges[n-1].ipv6 != rng.ipv6 || ranges[n-1].end.Cmp(rng.start) >= 0) {
+			c.issue(IssueInvalidPoolInfo, infoKey, "",
+				fmt.Sprintf("pool range (%s) overlaps or is out of order", rng))
+			return nil, nil
+		}
+
+		ranges = append(ranges, rng)
+	}
+
+	nextIP := net.ParseIP(info.Next)
+	if nextIP == nil || isIPv6(nextIP) != ranges[0].ipv6 {
+		c.issue(IssueInvalidNext, infoKey, "", fmt.Sprintf("invalid next block (%s)", info.Next))
+		return ranges, nil
+	}
+
+	next := ipToInt(nextIP)
+	if next.Cmp(ranges[0].start) < 0 {
+		c.issue(IssueInvalidNext, infoKey, "", fmt.Sprintf("next block (%s) is before the range start", info.Next))
+	}
+
+	if rng := findRange(ranges, next); rng != nil &&
+		big.NewInt(0).Mod(big.NewInt(0).Sub(next, rng.start), c.pool.blockSize()).Sign() != 0 {
+		c.issue(IssueInvalidNext, infoKey, "", fmt.Sprintf("next block (%s) is misaligned", info.Next))
+	}
+
+	return ranges, next
+}
+
+// checkBlocks validates the IP Block records and returns the valid ones (by start address)
+func (c *checker) checkBlocks(ranges []*ipRange, next *big.Int) map[string]*BlockInfo {
+	blocks := map[string]*BlockInfo{}
+	byKey := map[string][]string{}
+	byID := map[string][]string{}
+	var beyondNext *big.Int
+
+	prefix := c.pool.store.key(poolBlocksKeyPrefix) + "/"
+	for _, p := range c.pool.store.ListRecords(prefix) {
+		var block BlockInfo
+		if err := json.Unmarshal(p.Value, &block); err != nil {
+			issue := c.issue(IssueUndecodable, p.Key, "", err.Error())
+			c.moveToLostFound(issue, p.Key, p.Value)
+			continue
+		}
+
+		startIP := net.ParseIP(block.Start)
+		if startIP == nil {
+			issue := c.issue(IssueInvalidStart, p.Key, block.Start, "invalid block start address")
+			c.moveToLostFound(issue, p.Key, p.Value)
+			continue
+		}
+
+		if recordKey := strings.TrimPrefix(p.Key, prefix); recordKey != block.Start {
+			issue := c.issue(IssueKeyMismatch, p.Key, block.Start,
+				fmt.Sprintf("record key (%s) doesn't match the block start", recordKey))
+
+			if c.repair {
+				if c.pool.store.GetRecord(prefix+block.Start) == nil {
+					c.pool.store.SaveRecord(prefix+block.Start, p.Value)
+					c.pool.store.RemoveRecord(p.Key)
+					c.repaired(issue, "moved to "+prefix+block.Start)
+				} else {
+					c.moveToLostFound(issue, p.Key, p.Value)
+					continue
+				}
+			}
+		}
+
+		blocks[block.Start] = &block
+		if block.Key != "" {
+			//the Block Keys are unique per namespace
+			id := fmt.Sprintf("%s/%s", block.Namespace, block.Key)
+			byKey[id] = append(byKey[id], block.Start)
+		}
+		byID[block.ID] = append(byID[block.ID], block.Start)
+
+		if ranges == nil {
+			continue
+		}
+
+		start := ipToInt(startIP)
+		blockRange := newExtent(start, c.pool.sizeOf(&block))
+		rng := findRange(ranges, start)
+		if rng == nil || isIPv6(startIP) != rng.ipv6 || !rng.contains(blockRange.end) {
+			c.issue(IssueOutOfRange, p.Key, block.Start, "block is outside the pool ranges")
+			continue
+		}
+
+		offset := big.NewInt(0).Sub(start, rng.start)
+		if big.NewInt(0).Mod(offset, c.pool.blockSize()).Sign() != 0 {
+			c.issue(IssueMisaligned, p.Key, block.Start, "block start is not aligned to the pool block size")
+		}
+
+		for _, r := range c.pool.excluded {
+			if r.ipv6 == rng.ipv6 && r.overlaps(blockRange.start, blockRange.end) {
+				c.issue(IssueExcluded, p.Key, block.Start, fmt.Sprintf("block overlaps an excluded range (%s)", r))
+				break
+			}
+		}
+
+		if next != nil && start.Cmp(next) >= 0 {
+			issue := c.issue(IssueBeyondNext, p.Key, block.Start,
+				fmt.Sprintf("block is at or beyond the next block (%s)", intToIP(next, rng.ipv6)))
+
+			if beyondNext == nil || blockRange.end.Cmp(beyondNext) > 0 {
+				beyondNext = blockRange.end
+			}
+
+			if c.repair {
+				//the actual Next update happens once all blocks are checked
+				c.repaired(issue, "advanced next block")
+			}
+		}
+	}
+
+	if beyondNext != nil && c.repair {
+		newNext := intToIP(big.NewInt(0).Add(beyondNext, big.NewInt(1)), ranges[0].ipv6)
+		//the pool info was read by checkInfo (under the same pool lock)
+		info := c.pool.store.GetPool()
+		info.Next = newNext.String()
+		c.pool.store.SavePool(info)
+
+		for _, issue := range c.report.Issues {
+			if issue.Kind == IssueBeyondNext && issue.Repaired {
+				issue.Action = "advanced next block to " + newNext.String()
+			}
+		}
+	}
+
+	for key, starts := range byKey {
+		if len(starts) > 1 {
+			c.issue(IssueDuplicateKey, c.pool.store.key(poolBlocksKeyPrefix), strings.Join(starts, ","),
+				fmt.Sprintf("block key (%s) is used by %d blocks", key, len(starts)))
+		}
+	}
+
+	for id, starts := range byID {
+		if len(starts) > 1 {
+			c.issue(IssueDuplicateID, c.pool.store.key(poolBlocksKeyPrefix), strings.Join(starts, ","),
+				fmt.Sprintf("block ID (%s) is used by %d blocks", id, len(starts)))
+		}
+	}
+
+	return blocks
+}
+
+// checkAddresses validates the individual IP address records
+func (c *checker) checkAddresses(blocks map[string]*BlockInfo) {
+	byKey := map[string][]string{}
+
+	for _, p := range c.pool.store.ListRecords(c.pool.store.key(poolAddressesKeyPrefix) + "/") {
+		var address AddressInfo
+		if err := json.Unmarshal(p.Value, &address); err != nil {
+			issue := c.issue(IssueAddressUndecodable, p.Key, "", err.Error())
+			c.moveToLostFound(issue, p.Key, p.Value)
+			continue
+		}
+
+		block, ok := blocks[address.Block]
+		if !ok || !strings.HasPrefix(p.Key, c.pool.store.addressKeyPrefix(address.Block)) {
+			issue := c.issue(IssueAddressOrphaned, p.Key, address.Block, "address record has no matching block")
+			if c.repair {
+				c.pool.store.RemoveRecord(p.Key)
+				c.repaired(issue, "removed")
+			}
+			continue
+		}
+
+		ip := net.ParseIP(address.Address)
+		blockRange := newExtent(ipToInt(net.ParseIP(block.Start)), c.pool.sizeOf(block))
+		if ip == nil ||
+			ipToInt(ip).Cmp(blockRange.start) < 0 || ipToInt(ip).Cmp(blockRange.end) > 0 {
+			issue := c.issue(IssueAddressOutside, p.Key, address.Block, fmt.Sprintf("address (%s) is outside its block", address.Address))
+			if c.repair {
+				c.pool.store.RemoveRecord(p.Key)
+				c.repaired(issue, "removed")
+			}
+			continue
+		}
+
+		if address.Key != "" {
+			id := address.Block + "/" + address.Key
+			byKey[id] = append(byKey[id], address.Address)
+		}
+	}
+
+	for id, addresses := range byKey {
+		if len(addresses) > 1 {
+			c.issue(IssueAddressDuplicateKey, c.pool.store.key(poolAddressesKeyPrefix), strings.Split(id, "/")[0],
+				fmt.Sprintf("address key (%s) is used by %d addresses", id, len(addresses)))
+		}
+	}
+}
+
+// checkQuarantine validates the quarantine records
+func (c *checker) checkQuarantine(blocks map[string]*BlockInfo) {
+	for _, p := range c.pool.store.ListRecords(c.pool.store.key(poolQuarantineKeyPrefix) + "/") {
+		var record QuarantineInfo
+		if err := json.Unmarshal(p.Value, &record); err != nil {
+			issue := c.issue(IssueQuarantineUndecodable, p.Key, "", err.Error())
+			c.moveToLostFound(issue, p.Key, p.Value)
+			continue
+		}
+
+		if _, ok := blocks[record.Start]; ok {
+			issue := c.issue(IssueQuarantineAllocated, p.Key, record.Start, "quarantined block is allocated")
+			if c.repair {
+				c.pool.store.RemoveRecord(p.Key)
+				c.repaired(issue, "removed")
+			}
+			continue
+		}
+
+		if !record.Active() {
+			issue := c.issue(IssueQuarantineExpired, p.Key, record.Start,
+				fmt.Sprintf("quarantine expired at %s", record.Until.Format(time.RFC3339)))
+			if c.repair {
+				c.pool.store.RemoveRecord(p.Key)
+				c.repaired(issue, "removed")
+			}
+		}
+	}
+}
+
+// checkChildren validates the links between the delegated IP Blocks and the child pools
+// (the issues are only reported because the child pools may still be in use)
+func (c *checker) checkChildren(blocks map[string]*BlockInfo) {
+	links := map[string]*ChildInfo{}
+	for _, link := range c.pool.store.ListChildren() {
+		links[link.Name] = link
+
+		block, ok := blocks[link.Block]
+		if !ok || block.Pool != link.Name {
+			c.issue(IssueChildLinkOrphaned, c.pool.store.key(poolChildrenKeyPrefix, link.Name), link.Block,
+				fmt.Sprintf("child pool (%s) has no matching delegated block", link.Name))
+		}
+	}
+
+	for _, block := range blocks {
+		if block.Pool == "" {
+			continue
+		}
+
+		if _, ok := links[block.Pool]; !ok {
+			c.issue(IssueChildLinkMissing, c.pool.store.key(poolBlocksKeyPrefix, block.Start), block.Start,
+				fmt.Sprintf("delegated block has no child pool link (%s)", block.Pool))
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package pool
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	pool := New(&Config{StartRange: "169.254.60.0", EndRange: "169.254.60.31", PoolBlockSize: 4}, newTestStore(t))
+	for _, key := range []string{"vm-1", "vm-2"} {
+		if _, err := pool.Allocate(key, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if report := pool.Check(false); !report.Consistent() || len(report.Issues) != 0 {
+		t.Fatalf("Check() = %+v, want no issues", report.Issues)
+	}
+
+	//a block saved beyond the next block, an undecodable block record and an address without a block
+	pool.store.SaveBlock(NewBlockInfo("169.254.60.16", "vm-3"))
+	pool.store.SaveRecord(pool.store.key(poolBlocksKeyPrefix, "169.254.60.24"), []byte("{"))
+	pool.store.SaveAddress(&AddressInfo{Block: "169.254.60.28", Address: "169.254.60.29", Key: "eth0"})
+
+	want := []string{IssueBeyondNext, IssueAddressOrphaned, IssueUndecodable}
+	report := pool.Check(false)
+	if kinds := issueKinds(report); !equalStrings(kinds, want) {
+		t.Fatalf("Check() issues = %v, want %v", kinds, want)
+	}
+
+	if report.Consistent() || report.Repaired != 0 || report.ReportKey != "" {
+		t.Errorf("Check() without repair = %d repaired, report key %q, want nothing repaired", report.Repaired, report.ReportKey)
+	}
+
+	report = pool.Check(true)
+	if kinds := issueKinds(report); !equalStrings(kinds, want) || !report.Consistent() {
+		t.Fatalf("Check() with repair issues = %v, consistent %v, want %v repaired", kinds, report.Consistent(), want)
+	}
+
+	if report.ReportKey == "" || pool.store.GetRecord(report.ReportKey) == nil {
+		t.Errorf("Check() with repair report key = %q, want the saved report", report.ReportKey)
+	}
+
+	if records := pool.store.ListRecords(pool.store.key(poolLostFoundKeyPrefix) + "/"); len(records) != 1 || string(records[0].Value) != "{" {
+		t.Error("Check() with repair didn't move the undecodable block record to lost+found")
+	}
+
+	if report := pool.Check(false); len(report.Issues) != 0 {
+		t.Errorf("Check() after the repair = %+v, want no issues", report.Issues)
+	}
+
+	//the next block was advanced past the block beyond it
+	block, err := pool.Allocate("vm-4", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if block.Start != "169.254.60.20" {
+		t.Errorf("Allocate() after the repair = %s, want 169.254.60.20", block.Start)
+	}
+}
+
+func issueKinds(report *CheckReport) []string {
+	var kinds []string
+	for _, issue := range report.Issues {
+		kinds = append(kinds, issue.Kind)
+	}
+
+	sort.Strings(kinds)
+	return kinds
+}
@@ -0,0 +1,241 @@
+package pool
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net"
+
+	"github.com/segmentio/ksuid"
+)
+
+const (
+	poolGroupsKeyPrefix = "groups"
+)
+
+// Group allocation errors
+var (
+	//
+	ErrInvalidGroupSize = errors.New("Group block count must be a power of two")
+	//
+	ErrNoContiguousRun = errors.New("No contiguous run of free blocks")
+	//
+	ErrGroupNotFound = errors.New("Block group not found")
+	//
+	ErrBlockGrouped = errors.New("Block is a part of a block group")
+)
+
+// GroupInfo contains the metadata for a group of adjacent IP Blocks allocated together
+// (the blocks form one aligned prefix)
+type GroupInfo struct {
+	ID        string   `json:"id"`
+	Key       string   `json:"key"`
+	Start     string   `json:"start"`
+	End       string   `json:"end"`
+	Count     int64    `json:"count"`
+	Blocks    []string `json:"blocks"`
+	Tenant    string   `json:"tenant,omitempty"`
+	Owner     string   `json:"owner,omitempty"`
+	Namespace string   `json:"namespace,omitempty"`
+	//HolderToken is the secret holder token of the group blocks
+	//(it's returned only when the group is allocated and it's not persisted)
+	HolderToken string `json:"holder_token,omitempty"`
+}
+
+// AllocateGroup allocates count (a power of two) adjacent IP Blocks that form one aligned prefix
+// or returns an existing group if the provided key matches an existing group allocation
+func (pool *Manager) AllocateGroup(req *AllocationRequest, count int64) (*GroupInfo, error) {
+	if count <= 0 || count&(count-1) != 0 {
+		return nil, ErrInvalidGroupSize
+	}
+
+	if !ValidLabels(req.Labels) {
+		return nil, ErrInvalidLabels
+	}
+
+	lock := pool.acquireLock("Pool.AllocateGroup")
+	defer lock.Unlock()
+
+	if req.Key != "" {
+		if group := pool.store.FindGroup(pool.namespace, req.Key); group != nil {
+			pool.logger.Debug("Block group is already allocated", "key", req.Key, "group", group.ID)
+			return group, nil
+		}
+	}
+
+	pool, err := pool.load()
+	if err != nil {
+		return nil, err
+	}
+
+	size := big.NewInt(0).Mul(pool.blockSize(), big.NewInt(count))
+
+	tenant := TenantOf(req.Key, req.Tenant)
+	if err := pool.checkQuota(tenant, count, size); err != nil {
+		return nil, err
+	}
+
+	groupStart, err := pool.nextBlockFromRange(size)
+	if err == ErrPoolExhausted {
+		return nil, ErrNoContiguousRun
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := ksuid.NewRandom()
+	if err != nil {
+		panic(err)
+	}
+
+	startIP := net.ParseIP(groupStart)
+	group := &GroupInfo{
+		ID:        id.String(),
+		Key:       req.Key,
+		Start:     groupStart,
+		End:       intToIP(newExtent(ipToInt(startIP), size).end, isIPv6(startIP)).String(),
+		Count:     count,
+		Tenant:    tenant,
+		Owner:     req.Owner,
+		Namespace: pool.namespace,
+	}
+
+	token, hash := newHolderToken()
+	for i := int64(0); i < count; i++ {
+		offset := big.NewInt(0).Mul(pool.blockSize(), big.NewInt(i))
+		block := NewBlockInfo(addToIP(startIP, offset).String(), "")
+		block.Tenant = tenant
+		block.Namespace = pool.namespace
+		block.Group = group.ID
+		block.Labels = req.Labels
+		block.Description = req.Description
+		block.Owner = req.Owner
+		block.HolderHash = hash
+
+		pool.store.SaveBlock(block)
+		group.Blocks = append(group.Blocks, block.Start)
+	}
+
+	pool.store.SaveGroup(group)
+	group.HolderToken = token
+
+	pool.logger.Info("Allocated block group", "group", group.ID, "count", count, "start", group.Start, "end", group.End)
+	return group, nil
+}
+
+// LookupGroup returns the block group by its ID, its key or any address in the group
+// (nil if the group is not allocated)
+func (pool *Manager) LookupGroup(groupID, groupKey, address string) *GroupInfo {
+	var group *GroupInfo
+	switch {
+	case groupID != "":
+		group = pool.store.GetGroup(groupID)
+	case groupKey != "":
+		group = pool.store.FindGroup(pool.namespace, groupKey)
+	case address != "":
+		if ip := net.ParseIP(address); ip != nil {
+			if block := pool.findBlockContaining(ip); block != nil && block.Group != "" {
+				group = pool.store.GetGroup(block.Group)
+			}
+		}
+	}
+
+	if group == nil || group.Namespace != pool.namespace {
+		return nil
+	}
+
+	return group
+}
+
+// FreeGroup releases all IP Blocks in the block group
+func (pool *Manager) FreeGroup(groupID, groupKey, address string) error {
+	lock := pool.acquireLock("Pool.FreeGroup")
+	defer lock.Unlock()
+
+	group := pool.LookupGroup(groupID, groupKey, address)
+	if group == nil {
+		return ErrGroupNotFound
+	}
+
+	var blocks []*BlockInfo
+	for _, start := range group.Blocks {
+		if block := pool.store.GetBlock(start); block != nil && block.Group == group.ID {
+			if err := pool.checkHolder(block); err != nil {
+				return err
+			}
+
+			blocks = append(blocks, block)
+		}
+	}
+
+	for _, block := range blocks {
+		pool.release(block)
+	}
+
+	pool.store.RemoveGroup(group.ID)
+
+	pool.logger.Info("Freed block group", "group", group.ID, "count", group.Count, "start", group.Start, "end", group.End)
+	return nil
+}
+
+// ListGroups returns the block group records
+func (s *Store) ListGroups() []*GroupInfo {
+	var groups []*GroupInfo
+	for _, p := range s.ListRecords(s.key(poolGroupsKeyPrefix) + "/") {
+		var group GroupInfo
+		if err := json.Unmarshal(p.Value, &group); err != nil {
+			panic(err)
+		}
+
+		groups = append(groups, &group)
+	}
+
+	return groups
+}
+
+// FindGroup returns the block group selected by its key in the selected namespace
+func (s *Store) FindGroup(namespace, key string) *GroupInfo {
+	for _, group := range s.ListGroups() {
+		if group.Namespace == namespace && group.Key == key {
+			return group
+		}
+	}
+
+	return nil
+}
+
+// GetGroup returns the block group selected by its ID
+func (s *Store) GetGroup(id string) *GroupInfo {
+	raw := s.GetRecord(s.key(poolGroupsKeyPrefix, id))
+	if raw == nil {
+		return nil
+	}
+
+	var group GroupInfo
+	if err := json.Unmarshal(raw, &group); err != nil {
+		panic(err)
+	}
+
+	return &group
+}
+
+// SaveGroup saves the provided block group record (without the holder token)
+func (s *Store) SaveGroup(group *GroupInfo) {
+	record := *group
+	record.HolderToken = ""
+
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(&record); err != nil {
+		panic(err)
+	}
+
+	s.SaveRecord(s.key(poolGroupsKeyPrefix, group.ID), buf.Bytes())
+}
+
+// RemoveGroup removes the block group record selected by its ID
+func (s *Store) RemoveGroup(id string) {
+	s.RemoveRecord(s.key(poolGroupsKeyPrefix, id))
+}
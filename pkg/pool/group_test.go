@@ -0,0 +1,80 @@
+package pool
+
+import (
+	"testing"
+)
+
+func TestAllocateGroup(t *testing.T) {
+	pool := New(&Config{StartRange: "169.254.60.0", EndRange: "169.254.60.63", PoolBlockSize: 4}, newTestStore(t))
+	if _, err := pool.Allocate("vm-1", false); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := pool.AllocateGroup(&AllocationRequest{Key: "web"}, 3); err != ErrInvalidGroupSize {
+		t.Errorf("AllocateGroup() of 3 blocks error = %v, want %v", err, ErrInvalidGroupSize)
+	}
+
+	if _, err := pool.AllocateGroup(&AllocationRequest{Key: "web"}, 16); err != ErrNoContiguousRun {
+		t.Errorf("AllocateGroup() of 16 blocks error = %v, want %v", err, ErrNoContiguousRun)
+	}
+
+	group, err := pool.AllocateGroup(&AllocationRequest{Key: "acme:web", Labels: map[string]string{"tier": "web"}}, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the group blocks form one aligned prefix
+	want := []string{"169.254.60.16", "169.254.60.20", "169.254.60.24", "169.254.60.28"}
+	if group.Start != "169.254.60.16" || group.End != "169.254.60.31" || group.Tenant != "acme" ||
+		group.HolderToken == "" || !equalStrings(group.Blocks, want) {
+		t.Fatalf("AllocateGroup() = %+v, want the blocks %v", group, want)
+	}
+
+	for _, start := range group.Blocks {
+		if block := pool.Lookup(start, ""); block == nil || block.Group != group.ID || block.Labels["tier"] != "web" {
+			t.Errorf("Lookup(%s) = %+v, want a block in the group %s", start, block, group.ID)
+		}
+	}
+
+	if existing, err := pool.AllocateGroup(&AllocationRequest{Key: "acme:web"}, 4); err != nil || existing.ID != group.ID {
+		t.Errorf("AllocateGroup() with the existing key = %v, %v, want the group %s", existing, err, group.ID)
+	}
+
+	if found := pool.LookupGroup("", "", "169.254.60.27"); found == nil || found.ID != group.ID {
+		t.Errorf("LookupGroup() by address = %+v, want the group %s", found, group.ID)
+	}
+
+	if err := pool.WithHolderOverride().Free(group.Blocks[1], ""); err != ErrBlockGrouped {
+		t.Errorf("Free() of a group block error = %v, want %v", err, ErrBlockGrouped)
+	}
+}
+
+func TestFreeGroup(t *testing.T) {
+	pool := New(&Config{StartRange: "169.254.60.0", EndRange: "169.254.60.63", PoolBlockSize: 4}, newTestStore(t))
+	group, err := pool.AllocateGroup(&AllocationRequest{Key: "web"}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pool.FreeGroup(group.ID, "", ""); err != ErrHolderRequired {
+		t.Errorf("FreeGroup() without the holder token error = %v, want %v", err, ErrHolderRequired)
+	}
+
+	if err := pool.WithHolder("other").FreeGroup(group.ID, "", ""); err != ErrHolderMismatch {
+		t.Errorf("FreeGroup() with another holder token error = %v, want %v", err, ErrHolderMismatch)
+	}
+
+	if err := pool.WithHolder(group.HolderToken).FreeGroup("", "web", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, start := range group.Blocks {
+		if block := pool.Lookup(start, ""); block != nil {
+			t.Errorf("Lookup(%s) after FreeGroup() = %+v, want no block", start, block)
+		}
+	}
+
+	if err := pool.FreeGroup(group.ID, "", ""); err != ErrGroupNotFound {
+		t.Errorf("FreeGroup() of the freed group error = %v, want %v", err, ErrGroupNotFound)
+	}
+}
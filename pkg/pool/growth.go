@@ -0,0 +1,255 @@
+package pool
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/hashicorp/consul/api"
+)
+
+const (
+	supernetsKeyPrefix       = "poc/supernets"
+	supernetClaimsKeyPrefix  = "claims"
+	metricPoolGrowth         = "growth"
+	metricPoolGrowthAddrs    = "growth_addresses"
+	metricPoolGrowthFailures = "growth_failures"
+)
+
+// Growth errors
+var (
+	//
+	ErrInvalidGrowth = errors.New("Invalid pool growth configuration")
+	//
+	ErrSupernetExhausted = errors.New("No free chunks in supernet")
+)
+
+// SupernetClaim records a supernet chunk claimed by a pool
+// (the claims are shared by all pools growing from the same supernet)
+type SupernetClaim struct {
+	Start   string    `json:"start"`
+	End     string    `json:"end"`
+	Pool    string    `json:"pool"`
+	Claimed time.Time `json:"claimed"`
+}
+
+// growth contains the automatic pool growth configuration
+type growth struct {
+	supernet  *ipRange
+	cidr      string
+	size      *big.Int
+	threshold float64
+}
+
+// newGrowth validates the pool growth configuration (nil if the growth is not configured)
+func newGrowth(config *Config, blockSize *big.Int) (*growth, error) {
+	if config.Supernet == "" {
+		return nil, nil
+	}
+
+	supernet, err := parseIPRange(config.Supernet)
+	if err != nil || !strings.Contains(config.Supernet, "/") {
+		return nil, ErrInvalidGrowth
+	}
+
+	if config.GrowthSize <= 0 || big.NewInt(0).Mod(big.NewInt(config.GrowthSize), blockSize).Sign() != 0 ||
+		big.NewInt(config.GrowthSize).Cmp(supernet.size()) > 0 ||
+		config.GrowthThreshold < 0 || config.GrowthThreshold > 1 {
+		return nil, ErrInvalidGrowth
+	}
+
+	g := &growth{
+		supernet:  supernet,
+		cidr:      config.Supernet,
+		size:      big.NewInt(config.GrowthSize),
+		threshold: config.GrowthThreshold,
+	}
+
+	return g, nil
+}
+
+// shouldGrow returns true if the pool utilization crossed the growth threshold
+// (the threshold is optional: without it the pool grows only when it's exhausted)
+func (pool *Manager) shouldGrow() bool {
+	if pool.growth == nil || pool.growth.threshold <= 0 {
+		return false
+	}
+
+	return layoutStats(pool.layout()).Utilization >= pool.growth.threshold
+}
+
+// growOnThreshold grows the pool if its utilization reached the growth threshold
+// (the threshold growth is best effort: the allocation continues even if the pool can't grow)
+func (pool *Manager) growOnThreshold() {
+	if pool.shouldGrow() {
+		pool.grow("threshold")
+	}
+}
+
+// grow claims the next free supernet chunk and appends it to the pool as a new range
+// (must be called with the pool lock held and with fresh pool info)
+func (pool *Manager) grow(reason string) error {
+	if pool.growth == nil || pool.info.ParentBlock != "" {
+		return ErrPoolExhausted
+	}
+
+	claim, err := pool.store.ClaimSupernet(pool.growth.cidr, pool.name, pool.growth.size, pool.ranges())
+	if err != nil {
+		pool.logger.Warn("Can't grow the pool", "reason", reason, "supernet", pool.growth.cidr, "error", err)
+		metrics.IncrCounter(pool.metricKey(metricPoolGrowthFailures), 1)
+		return err
+	}
+
+	rng, err := newIPRange(claim.Start, claim.End)
+	if err != nil {
+		panic(err)
+	}
+
+	ranges, err := sortRanges(append(pool.ranges(), rng))
+	if err != nil {
+		panic(err)
+	}
+
+	//NOTE: Next doesn't change (it's still the allocation boundary and the new range is free)
+	pool.info.SetRanges(toRanges(ranges))
+	pool.store.SavePool(pool.info)
+	if err := pool.setInfo(pool.info); err != nil {
+		return err
+	}
+
+	pool.logger.Info("Grew the pool", "reason", reason, "supernet", pool.growth.cidr,
+		"start", claim.Start, "end", claim.End, "ranges", len(ranges))
+	metrics.IncrCounter(pool.metricKey(metricPoolGrowth), 1)
+	metrics.IncrCounter(pool.metricKey(metricPoolGrowthAddrs), float32(pool.growth.size.Int64()))
+	return nil
+}
+
+// claimInitialRanges records the pool ranges that are inside the supernet as claimed
+// (so the other pools growing from the same supernet don't claim them)
+func (pool *Manager) claimInitialRanges() {
+	if pool.growth == nil {
+		return
+	}
+
+	for _, r := range pool.ranges() {
+		if r.ipv6 == pool.growth.supernet.ipv6 && pool.growth.supernet.overlaps(r.start, r.end) {
+			pool.store.SaveSupernetClaim(pool.growth.cidr, &SupernetClaim{
+				Start:   intToIP(r.start, r.ipv6).String(),
+				End:     intToIP(r.end, r.ipv6).String(),
+				Pool:    pool.name,
+				Claimed: time.Now().UTC(),
+			})
+		}
+	}
+}
+
+func supernetKey(supernet string, parts ...string) string {
+	id := strings.Replace(supernet, "/", "_", -1)
+	return strings.Join(append([]string{supernetsKeyPrefix, id}, parts...), "/")
+}
+
+// GetSupernetLock returns the lock object for the supernet claims
+func (s *Store) GetSupernetLock(supernet string) *api.Lock {
+	lock, err := s.consul.LockKey(supernetKey(supernet, poolLockKey))
+	if err != nil {
+		panic(err)
+	}
+
+	return lock
+}
+
+// ListSupernetClaims returns the claimed supernet chunks
+func (s *Store) ListSupernetClaims(supernet string) []*SupernetClaim {
+	var claims []*SupernetClaim
+	for _, p := range s.ListRecords(supernetKey(supernet, supernetClaimsKeyPrefix) + "/") {
+		var claim SupernetClaim
+		if err := json.Unmarshal(p.Value, &claim); err != nil {
+			panic(err)
+		}
+
+		claims = append(claims, &claim)
+	}
+
+	return claims
+}
+
+// SaveSupernetClaim saves the provided supernet claim
+func (s *Store) SaveSupernetClaim(supernet string, claim *SupernetClaim) {
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(claim); err != nil {
+		panic(err)
+	}
+
+	s.SaveRecord(supernetKey(supernet, supernetClaimsKeyPrefix, claim.Start), buf.Bytes())
+}
+
+// ClaimSupernet claims the first free supernet chunk with the requested number of addresses
+// (the chunks are aligned relative to the supernet start and they can't overlap
+// the existing claims or the provided ranges)
+func (s *Store) ClaimSupernet(supernet, pool string, size *big.Int, ranges []*ipRange) (*SupernetClaim, error) {
+	rng, err := parseIPRange(supernet)
+	if err != nil {
+		return nil, ErrInvalidGrowth
+	}
+
+	lock := s.GetSupernetLock(supernet)
+	lockCh, err := lock.Lock(nil)
+	if err != nil {
+		panic(err)
+	}
+	if lockCh == nil {
+		panic("did not lock")
+	}
+	defer lock.Unlock()
+
+	var used []extent
+	for _, claim := range s.ListSupernetClaims(supernet) {
+		start, end := net.ParseIP(claim.Start), net.ParseIP(claim.End)
+		if start != nil && end != nil {
+			used = append(used, extent{start: ipToInt(start), end: ipToInt(end)})
+		}
+	}
+
+	for _, r := range ranges {
+		if r.ipv6 == rng.ipv6 {
+			used = append(used, extent{start: r.start, end: r.end})
+		}
+	}
+
+	used = mergeExtents(used)
+	for start := rng.start; ; {
+		chunk := newExtent(start, size)
+		if chunk.end.Cmp(rng.end) > 0 {
+			return nil, ErrSupernetExhausted
+		}
+
+		var overlap *extent
+		for i := range used {
+			if used[i].start.Cmp(chunk.end) <= 0 && used[i].end.Cmp(chunk.start) >= 0 {
+				overlap = &used[i]
+				break
+			}
+		}
+
+		if overlap == nil {
+			claim := &SupernetClaim{
+				Start:   intToIP(chunk.start, rng.ipv6).String(),
+				End:     intToIP(chunk.end, rng.ipv6).String(),
+				Pool:    pool,
+				Claimed: time.Now().UTC(),
+			}
+
+			s.SaveSupernetClaim(supernet, claim)
+			return claim, nil
+		}
+
+		start = alignUp(big.NewInt(0).Add(overlap.end, big.NewInt(1)), rng.start, size)
+	}
+}
@@ -0,0 +1,106 @@
+package pool
+
+import (
+	"fmt"
+	"math/big"
+	"testing"
+)
+
+func TestNewGrowth(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		config *Config
+		err    error
+	}{
+		{name: "no supernet", config: &Config{GrowthSize: 16}},
+		{name: "valid", config: &Config{Supernet: "169.254.60.0/24", GrowthSize: 16, GrowthThreshold: 0.8}},
+		{name: "not a cidr", config: &Config{Supernet: "169.254.60.0-169.254.60.255", GrowthSize: 16}, err: ErrInvalidGrowth},
+		{name: "no size", config: &Config{Supernet: "169.254.60.0/24"}, err: ErrInvalidGrowth},
+		{name: "not a block multiple", config: &Config{Supernet: "169.254.60.0/24", GrowthSize: 6}, err: ErrInvalidGrowth},
+		{name: "larger than the supernet", config: &Config{Supernet: "169.254.60.0/28", GrowthSize: 32}, err: ErrInvalidGrowth},
+		{name: "threshold over 1", config: &Config{Supernet: "169.254.60.0/24", GrowthSize: 16, GrowthThreshold: 1.5}, err: ErrInvalidGrowth},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := newGrowth(test.config, big.NewInt(4)); err != test.err {
+				t.Errorf("newGrowth() error = %v, want %v", err, test.err)
+			}
+		})
+	}
+}
+
+func TestGrowWhenExhausted(t *testing.T) {
+	store := newTestStore(t)
+	pool := New(&Config{
+		Name:          "a",
+		StartRange:    "169.254.60.0",
+		EndRange:      "169.254.60.7",
+		PoolBlockSize: 4,
+		Supernet:      "169.254.60.0/27",
+		GrowthSize:    8,
+	}, store)
+
+	//the other pool growing from the same supernet claims its initial range
+	New(&Config{
+		Name:          "b",
+		StartRange:    "169.254.60.8",
+		EndRange:      "169.254.60.15",
+		PoolBlockSize: 4,
+		Supernet:      "169.254.60.0/27",
+		GrowthSize:    8,
+	}, store.ForPool("b"))
+
+	var starts []string
+	for i := 0; i < 6; i++ {
+		block, err := pool.Allocate(fmt.Sprintf("vm-%d", i), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		starts = append(starts, block.Start)
+	}
+
+	want := []string{"169.254.60.0", "169.254.60.4", "169.254.60.16", "169.254.60.20", "169.254.60.24", "169.254.60.28"}
+	if !equalStrings(starts, want) {
+		t.Errorf("allocated blocks = %v, want %v", starts, want)
+	}
+
+	if _, err := pool.Allocate("vm-6", false); err != ErrPoolExhausted {
+		t.Errorf("Allocate() in the exhausted supernet error = %v, want %v", err, ErrPoolExhausted)
+	}
+
+	ranges, err := pool.Ranges()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ranges) != 3 || ranges[1].Start != "169.254.60.16" || ranges[2].End != "169.254.60.31" {
+		t.Errorf("Ranges() = %+v, want the initial range and two claimed chunks", ranges)
+	}
+}
+
+func TestGrowOnThreshold(t *testing.T) {
+	pool := New(&Config{
+		StartRange:      "169.254.60.0",
+		EndRange:        "169.254.60.15",
+		PoolBlockSize:   4,
+		Supernet:        "169.254.60.0/26",
+		GrowthSize:      16,
+		GrowthThreshold: 0.5,
+	}, newTestStore(t))
+
+	for i := 0; i < 3; i++ {
+		if _, err := pool.Allocate(fmt.Sprintf("vm-%d", i), false); err != nil {
+			t.Fatal(err)
+		}
+
+		ranges, err := pool.Ranges()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		//the pool grows with the allocation after the utilization reached the threshold
+		if want := map[bool]int{true: 2, false: 1}[i == 2]; len(ranges) != want {
+			t.Errorf("Ranges() after %d allocations = %+v, want %d ranges", i+1, ranges, want)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package pool
+
+import (
+	"errors"
+)
+
+// Health errors
+var (
+	//
+	ErrNoLeader = errors.New("Consul cluster has no leader")
+	//
+	ErrPoolInfoMissing = errors.New("Pool info is missing")
+	//
+	ErrInvalidPoolInfo = errors.New("Pool info is invalid")
+)
+
+// Ping checks that the pool records in the Store backend can be read
+// (unlike the other Store operations it returns the errors)
+func (pool *Manager) Ping() error {
+	_, _, err := pool.store.kvAPI.Get(pool.store.key(poolInfoKey), nil)
+	return err
+}
+
+// Leader returns the address of the Consul cluster leader
+// (the pool lock needs a Consul session, so it can't be acquired without a leader)
+func (pool *Manager) Leader() (string, error) {
+	leader, err := pool.store.consul.Status().Leader()
+	if err != nil {
+		return "", err
+	}
+
+	if leader == "" {
+		return "", ErrNoLeader
+	}
+
+	return leader, nil
+}
+
+// CheckInfo checks that the pool metadata is stored and valid
+func (pool *Manager) CheckInfo() error {
+	_, err := pool.store.loadPool()
+	return err
+}
@@ -0,0 +1,53 @@
+package pool
+
+import (
+	"testing"
+)
+
+func TestHealthChecks(t *testing.T) {
+	consul, store := newTestConsul(t)
+	pool := New(&Config{StartRange: "169.254.60.0", EndRange: "169.254.60.15", PoolBlockSize: 4}, store)
+
+	if err := pool.Ping(); err != nil {
+		t.Errorf("Ping() error = %v", err)
+	}
+
+	if leader, err := pool.Leader(); err != nil || leader != "127.0.0.1:8300" {
+		t.Errorf("Leader() = %q, %v, want 127.0.0.1:8300", leader, err)
+	}
+
+	if err := pool.CheckInfo(); err != nil {
+		t.Errorf("CheckInfo() error = %v", err)
+	}
+
+	consul.mu.Lock()
+	consul.leader = ""
+	consul.mu.Unlock()
+
+	if _, err := pool.Leader(); err != ErrNoLeader {
+		t.Errorf("Leader() without a leader error = %v, want %v", err, ErrNoLeader)
+	}
+
+	infoKey := store.key(poolInfoKey)
+	for _, test := range []struct {
+		name  string
+		value []byte
+		err   error
+	}{
+		{name: "undecodable", value: []byte("{"), err: ErrInvalidPoolInfo},
+		{name: "invalid range", value: []byte(`{"start":"169.254.60.0","end":"bad"}`), err: ErrInvalidPoolInfo},
+		{name: "missing", err: ErrPoolInfoMissing},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if test.value == nil {
+				store.RemoveRecord(infoKey)
+			} else {
+				store.SaveRecord(infoKey, test.value)
+			}
+
+			if err := pool.CheckInfo(); err != test.err {
+				t.Errorf("CheckInfo() error = %v, want %v", err, test.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package pool
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+)
+
+const (
+	holderTokenSize = 32
+)
+
+// Holder errors
+var (
+	//
+	ErrHolderRequired = errors.New("Block holder token required")
+	//
+	ErrHolderMismatch = errors.New("Block holder token doesn't match")
+)
+
+// newHolderToken returns a new secret holder token and its hash (only the hash is stored)
+func newHolderToken() (string, string) {
+	raw := make([]byte, holderTokenSize)
+	if _, err := rand.Read(raw); err != nil {
+		panic(err)
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	return token, holderHash(token)
+}
+
+func holderHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// WithHolder returns a pool manager that presents the holder token when it frees or changes the IP Blocks
+func (pool *Manager) WithHolder(token string) *Manager {
+	scoped := *pool
+	scoped.holderToken = token
+	return &scoped
+}
+
+// WithHolderOverride returns a pool manager that frees and changes the IP Blocks
+// without their holder tokens (for the pool admins)
+func (pool *Manager) WithHolderOverride() *Manager {
+	scoped := *pool
+	scoped.holderOverride = true
+	return &scoped
+}
+
+// checkHolder returns an error if the pool manager can't free or change the IP Block
+// (the blocks allocated without the holder tokens are not protected)
+func (pool *Manager) checkHolder(block *BlockInfo) error {
+	if block.HolderHash == "" || pool.holderOverride {
+		return nil
+	}
+
+	if pool.holderToken == "" {
+		return ErrHolderRequired
+	}
+
+	if subtle.ConstantTimeCompare([]byte(holderHash(pool.holderToken)), []byte(block.HolderHash)) != 1 {
+		return ErrHolderMismatch
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+package pool
+
+import (
+	"testing"
+)
+
+func TestCheckHolder(t *testing.T) {
+	pool := New(&Config{StartRange: "169.254.60.0", EndRange: "169.254.60.15", PoolBlockSize: 4}, newTestStore(t))
+	token, hash := newHolderToken()
+	other, _ := newHolderToken()
+
+	for _, test := range []struct {
+		name  string
+		pool  *Manager
+		block *BlockInfo
+		err   error
+	}{
+		{name: "unprotected block", pool: pool, block: &BlockInfo{}},
+		{name: "no token", pool: pool, block: &BlockInfo{HolderHash: hash}, err: ErrHolderRequired},
+		{name: "holder token", pool: pool.WithHolder(token), block: &BlockInfo{HolderHash: hash}},
+		{name: "other token", pool: pool.WithHolder(other), block: &BlockInfo{HolderHash: hash}, err: ErrHolderMismatch},
+		{name: "hash as token", pool: pool.WithHolder(hash), block: &BlockInfo{HolderHash: hash}, err: ErrHolderMismatch},
+		{name: "override", pool: pool.WithHolderOverride(), block: &BlockInfo{HolderHash: hash}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.pool.checkHolder(test.block); err != test.err {
+				t.Errorf("checkHolder() error = %v, want %v", err, test.err)
+			}
+		})
+	}
+}
+
+func TestFreeWithHolder(t *testing.T) {
+	pool := New(&Config{StartRange: "169.254.60.0", EndRange: "169.254.60.15", PoolBlockSize: 4}, newTestStore(t))
+	block, err := pool.Allocate("vm-1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//only the token hash is stored
+	stored := pool.Lookup(block.Start, "")
+	if block.HolderToken == "" || stored.HolderToken != "" || stored.HolderHash != holderHash(block.HolderToken) {
+		t.Fatalf("stored block = %+v, want only the holder token hash", stored)
+	}
+
+	//an existing allocation doesn't return the token again
+	if existing, err := pool.Allocate("vm-1", false); err != nil || existing.HolderToken != "" {
+		t.Errorf("Allocate() of the existing key = %+v, %v, want no holder token", existing, err)
+	}
+
+	if err := pool.Free("", "vm-1"); err != ErrHolderRequired {
+		t.Errorf("Free() without the holder token error = %v, want %v", err, ErrHolderRequired)
+	}
+
+	if err := pool.WithHolder("other").Free("", "vm-1"); err != ErrHolderMismatch {
+		t.Errorf("Free() with another holder token error = %v, want %v", err, ErrHolderMismatch)
+	}
+
+	if err := pool.WithHolder(block.HolderToken).Free("", "vm-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if pool.Lookup(block.Start, "") != nil {
+		t.Error("Free() with the holder token didn't free the block")
+	}
+}
@@ -0,0 +1,44 @@
+package pool
+
+import (
+	"math/big"
+	"net"
+)
+
+// ipToInt returns the numeric value of the provided IP address
+func ipToInt(ip net.IP) *big.Int {
+	if ipVal := ip.To4(); ipVal != nil {
+		return big.NewInt(0).SetBytes(ipVal)
+	}
+
+	return big.NewInt(0).SetBytes(ip.To16())
+}
+
+// intToIP converts the numeric IP value back to an IP address
+// (returns nil if the value doesn't fit the selected IP address family)
+func intToIP(val *big.Int, ipv6 bool) net.IP {
+	size := net.IPv4len
+	if ipv6 {
+		size = net.IPv6len
+	}
+
+	raw := val.Bytes()
+	if val.Sign() < 0 || len(raw) > size {
+		return nil
+	}
+
+	ip := make(net.IP, size)
+	copy(ip[size-len(raw):], raw)
+	return ip
+}
+
+// isIPv6 returns true if the provided IP address is not an IPv4 address
+func isIPv6(ip net.IP) bool {
+	return ip.To4() == nil
+}
+
+// addToIP returns the IP address at the selected offset from the provided IP address
+func addToIP(ip net.IP, offset *big.Int) net.IP {
+	val := ipToInt(ip)
+	return intToIP(val.Add(val, offset), isIPv6(ip))
+}
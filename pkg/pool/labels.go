@@ -0,0 +1,128 @@
+package pool
+
+import (
+	"errors"
+	"strings"
+)
+
+// Label errors
+var (
+	//
+	ErrInvalidLabels = errors.New("Invalid block labels")
+	//
+	ErrInvalidSelector = errors.New("Invalid label selector")
+)
+
+const (
+	selectorEquals    = "="
+	selectorNotEquals = "!="
+	selectorExists    = "exists"
+	selectorNotExists = "!"
+)
+
+// Selector is a parsed label selector
+type Selector []*selectorRequirement
+
+type selectorRequirement struct {
+	op    string
+	key   string
+	value string
+}
+
+// validLabelKey returns true if the label key can be used in the label lists and the selectors
+func validLabelKey(key string) bool {
+	return key != "" && !strings.ContainsAny(key, "=!, ")
+}
+
+// ValidLabels returns true if all label keys and values can be used in the label lists and the selectors
+func ValidLabels(labels map[string]string) bool {
+	for k, v := range labels {
+		if !validLabelKey(k) || strings.ContainsAny(v, "=!,") {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ParseLabels parses a comma separated list of labels ("env=prod,tier=web")
+func ParseLabels(value string) (map[string]string, error) {
+	labels := map[string]string{}
+	if strings.TrimSpace(value) == "" {
+		return labels, nil
+	}
+
+	for _, item := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(item), "=", 2)
+		if len(parts) != 2 {
+			return nil, ErrInvalidLabels
+		}
+
+		labels[parts[0]] = parts[1]
+	}
+
+	if !ValidLabels(labels) {
+		return nil, ErrInvalidLabels
+	}
+
+	return labels, nil
+}
+
+// ParseSelector parses a comma separated label selector: "env=prod" (equals),
+// "tier!=db" (not equals), "gpu" (the label exists) and "!spot" (the label doesn't exist).
+// All requirements must match.
+func ParseSelector(value string) (Selector, error) {
+	var selector Selector
+	if strings.TrimSpace(value) == "" {
+		return selector, nil
+	}
+
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+
+		req := &selectorRequirement{op: selectorExists, key: item}
+		switch {
+		case strings.Contains(item, selectorNotEquals):
+			parts := strings.SplitN(item, selectorNotEquals, 2)
+			req = &selectorRequirement{op: selectorNotEquals, key: parts[0], value: parts[1]}
+		case strings.Contains(item, selectorEquals):
+			parts := strings.SplitN(item, selectorEquals, 2)
+			req = &selectorRequirement{op: selectorEquals, key: parts[0], value: parts[1]}
+		case strings.HasPrefix(item, selectorNotExists):
+			req = &selectorRequirement{op: selectorNotExists, key: item[1:]}
+		}
+
+		if !validLabelKey(req.key) || strings.ContainsAny(req.value, "=!") {
+			return nil, ErrInvalidSelector
+		}
+
+		selector = append(selector, req)
+	}
+
+	return selector, nil
+}
+
+// Matches returns true if the labels match all selector requirements
+func (s Selector) Matches(labels map[string]string) bool {
+	for _, req := range s {
+		value, ok := labels[req.key]
+
+		var match bool
+		switch req.op {
+		case selectorEquals:
+			match = ok && value == req.value
+		case selectorNotEquals:
+			match = !ok || value != req.value
+		case selectorExists:
+			match = ok
+		case selectorNotExists:
+			match = !ok
+		}
+
+		if !match {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,244 @@
+package pool
+
+import (
+	"math/big"
+	"net"
+	"sort"
+)
+
+// extent is an inclusive numeric IP address range used to describe the pool layout
+type extent struct {
+	start *big.Int
+	end   *big.Int
+}
+
+func newExtent(start, size *big.Int) extent {
+	end := big.NewInt(0).Add(start, size)
+	return extent{start: start, end: end.Sub(end, big.NewInt(1))}
+}
+
+func (e extent) size() *big.Int {
+	size := big.NewInt(0).Sub(e.end, e.start)
+	return size.Add(size, big.NewInt(1))
+}
+
+// mergeExtents sorts the extents and merges the overlapping/adjacent ones
+func mergeExtents(extents []extent) []extent {
+	sorted := make([]extent, len(extents))
+	copy(sorted, extents)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].start.Cmp(sorted[j].start) < 0
+	})
+
+	var merged []extent
+	for _, e := range sorted {
+		if n := len(merged); n > 0 {
+			next := big.NewInt(0).Add(merged[n-1].end, big.NewInt(1))
+			if e.start.Cmp(next) <= 0 {
+				if e.end.Cmp(merged[n-1].end) > 0 {
+					merged[n-1].end = e.end
+				}
+				continue
+			}
+		}
+
+		merged = append(merged, extent{start: e.start, end: e.end})
+	}
+
+	return merged
+}
+
+// rangeLayout describes the allocation state of a pool address range
+type rangeLayout struct {
+	rng         *ipRange
+	blockSize   *big.Int
+	allocated   []extent
+	quarantined []extent
+	excluded    []extent
+}
+
+// totalBlocks returns the number of IP Blocks that fit in the range
+func (l *rangeLayout) totalBlocks() *big.Int {
+	total := big.NewInt(0).Sub(l.rng.end, l.rng.start)
+	total.Add(total, big.NewInt(1))
+	return total.Div(total, l.blockSize)
+}
+
+// slot returns the index of the IP Block slot that contains the address
+func (l *rangeLayout) slot(val *big.Int) *big.Int {
+	idx := big.NewInt(0).Sub(val, l.rng.start)
+	return idx.Div(idx, l.blockSize)
+}
+
+// alignUp returns the first IP Block boundary at or after the address
+func (l *rangeLayout) alignUp(val *big.Int) *big.Int {
+	return alignUp(val, l.rng.start, l.blockSize)
+}
+
+// alignUp returns the first IP Block boundary (relative to base) at or after the address
+func alignUp(val, base, blockSize *big.Int) *big.Int {
+	offset := big.NewInt(0).Sub(val, base)
+	rem := big.NewInt(0).Mod(offset, blockSize)
+	if rem.Sign() == 0 {
+		return big.NewInt(0).Set(val)
+	}
+
+	aligned := big.NewInt(0).Sub(val, rem)
+	return aligned.Add(aligned, blockSize)
+}
+
+// lastBlockEnd returns the last address of the last IP Block that fits in the range
+func (l *rangeLayout) lastBlockEnd() *big.Int {
+	end := big.NewInt(0).Mul(l.totalBlocks(), l.blockSize)
+	end.Add(end, l.rng.start)
+	return end.Sub(end, big.NewInt(1))
+}
+
+// inRange clips the extents to the range
+func (l *rangeLayout) inRange(extents []extent) []extent {
+	var clipped []extent
+	for _, e := range extents {
+		if !l.rng.overlaps(e.start, e.end) {
+			continue
+		}
+
+		c := extent{start: e.start, end: e.end}
+		if c.start.Cmp(l.rng.start) < 0 {
+			c.start = l.rng.start
+		}
+		if c.end.Cmp(l.rng.end) > 0 {
+			c.end = l.rng.end
+		}
+
+		clipped = append(clipped, c)
+	}
+
+	return clipped
+}
+
+// occupied returns the merged extents that can't be used for new allocations
+func (l *rangeLayout) occupied() []extent {
+	var all []extent
+	all = append(all, l.allocated...)
+	all = append(all, l.quarantined...)
+	all = append(all, l.excluded...)
+	return mergeExtents(l.inRange(all))
+}
+
+// freeRuns returns the aligned free extents in the range
+// (each free run contains one or more whole IP Blocks)
+func (l *rangeLayout) freeRuns() []extent {
+	var runs []extent
+
+	limit := l.lastBlockEnd()
+	cursor := l.rng.start
+	addRun := func(end *big.Int) {
+		start := l.alignUp(cursor)
+		if end.Cmp(limit) > 0 {
+			end = limit
+		}
+
+		//trim the partial IP Block at the end of the gap
+		size := big.NewInt(0).Sub(end, start)
+		size.Add(size, big.NewInt(1))
+		size.Sub(size, big.NewInt(0).Mod(size, l.blockSize))
+		if size.Cmp(l.blockSize) >= 0 {
+			runs = append(runs, newExtent(start, size))
+		}
+	}
+
+	for _, e := range l.occupied() {
+		if e.start.Cmp(cursor) > 0 {
+			addRun(big.NewInt(0).Sub(e.start, big.NewInt(1)))
+		}
+
+		cursor = big.NewInt(0).Add(e.end, big.NewInt(1))
+	}
+
+	if cursor.Cmp(limit) <= 0 {
+		addRun(limit)
+	}
+
+	return runs
+}
+
+// countBlocks returns the number of distinct IP Block slots touched by the extents
+func (l *rangeLayout) countBlocks(extents []extent) *big.Int {
+	count := big.NewInt(0)
+	lastSlot := big.NewInt(-1)
+	maxSlot := big.NewInt(0).Sub(l.totalBlocks(), big.NewInt(1))
+
+	for _, e := range mergeExtents(l.inRange(extents)) {
+		first := l.slot(e.start)
+		last := l.slot(e.end)
+		if last.Cmp(maxSlot) > 0 {
+			last = maxSlot
+		}
+		if first.Cmp(lastSlot) <= 0 {
+			first = big.NewInt(0).Add(lastSlot, big.NewInt(1))
+		}
+		if first.Cmp(last) > 0 {
+			continue
+		}
+
+		n := big.NewInt(0).Sub(last, first)
+		count.Add(count, n.Add(n, big.NewInt(1)))
+		lastSlot = last
+	}
+
+	return count
+}
+
+// layout returns the current allocation state of the pool ranges
+func (pool *Manager) layout() []*rangeLayout {
+	var allocated, quarantined, excluded []extent
+	for _, block := range pool.store.ListBlocks() {
+		allocated = append(allocated, newExtent(ipToInt(net.ParseIP(block.Start)), pool.sizeOf(block)))
+	}
+
+	for _, q := range pool.activeQuarantine() {
+		quarantined = append(quarantined, newExtent(ipToInt(net.ParseIP(q.Start)), pool.blockSizeOr(q.Size)))
+	}
+
+	var layouts []*rangeLayout
+	for _, rng := range pool.ranges() {
+		excluded = nil
+		for _, r := range pool.excluded {
+			if r.ipv6 == rng.ipv6 {
+				excluded = append(excluded, extent{start: r.start, end: r.end})
+			}
+		}
+
+		l := &rangeLayout{
+			rng:       rng,
+			blockSize: pool.blockSize(),
+			excluded:  excluded,
+		}
+
+		l.allocated = l.inRange(allocated)
+		l.quarantined = l.inRange(quarantined)
+		layouts = append(layouts, l)
+	}
+
+	return layouts
+}
+
+// allocationView returns the pool state for the allocation strategies
+func (pool *Manager) allocationView(size *big.Int) *AllocationView {
+	view := &AllocationView{
+		BlockSize: pool.blockSize(),
+		Size:      size,
+		Next:      pool.nextBlock,
+	}
+
+	for _, l := range pool.layout() {
+		for _, run := range l.freeRuns() {
+			view.Free = append(view.Free, &FreeRun{
+				Start: intToIP(run.start, l.rng.ipv6),
+				Size:  run.size(),
+			})
+		}
+	}
+
+	return view
+}
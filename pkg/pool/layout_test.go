@@ -0,0 +1,299 @@
+package pool
+
+import (
+	"math"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func testExtent(t *testing.T, start, end string) extent {
+	return extent{start: ipValue(t, start), end: ipValue(t, end)}
+}
+
+func extentStrings(extents []extent) []string {
+	var result []string
+	for _, e := range extents {
+		result = append(result, (&ipRange{start: e.start, end: e.end}).String())
+	}
+
+	return result
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestMergeExtents(t *testing.T) {
+	extents := []extent{
+		testExtent(t, "169.254.60.8", "169.254.60.11"),
+		testExtent(t, "169.254.60.0", "169.254.60.3"),
+		//adjacent to the first extent
+		testExtent(t, "169.254.60.4", "169.254.60.5"),
+		//inside the merged extent
+		testExtent(t, "169.254.60.1", "169.254.60.2"),
+		testExtent(t, "169.254.60.20", "169.254.60.20"),
+	}
+
+	want := []string{"169.254.60.0-169.254.60.5", "169.254.60.8-169.254.60.11", "169.254.60.20-169.254.60.20"}
+	if got := extentStrings(mergeExtents(extents)); !equalStrings(got, want) {
+		t.Errorf("mergeExtents() = %v, want %v", got, want)
+	}
+
+	//the input extents are not changed
+	if got := extentStrings(extents[:1]); got[0] != "169.254.60.8-169.254.60.11" {
+		t.Errorf("mergeExtents() changed the input extent: %s", got[0])
+	}
+}
+
+func TestAlignUp(t *testing.T) {
+	base := big.NewInt(100)
+	blockSize := big.NewInt(8)
+	for _, test := range []struct {
+		val  int64
+		want int64
+	}{
+		{100, 100},
+		{101, 108},
+		{107, 108},
+		{108, 108},
+		{109, 116},
+	} {
+		if got := alignUp(big.NewInt(test.val), base, blockSize).Int64(); got != test.want {
+			t.Errorf("alignUp(%d) = %d, want %d", test.val, got, test.want)
+		}
+	}
+}
+
+// testLayout is a range with 34 addresses (8 blocks of 4 and a partial block):
+// the allocated blocks are .0 and .8 (with 8 addresses), the quarantined block is .16
+// and the excluded address .30 is in the last block
+func testLayout(t *testing.T) *rangeLayout {
+	rng, err := newIPRange("169.254.60.0", "169.254.60.33")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &rangeLayout{
+		rng:       rng,
+		blockSize: big.NewInt(4),
+		allocated: []extent{
+			testExtent(t, "169.254.60.0", "169.254.60.3"),
+			testExtent(t, "169.254.60.8", "169.254.60.15"),
+		},
+		quarantined: []extent{testExtent(t, "169.254.60.16", "169.254.60.19")},
+		excluded:    []extent{testExtent(t, "169.254.60.30", "169.254.60.30")},
+	}
+}
+
+func TestRangeLayout(t *testing.T) {
+	l := testLayout(t)
+
+	if got := l.totalBlocks().Int64(); got != 8 {
+		t.Errorf("totalBlocks() = %d, want 8", got)
+	}
+
+	if got := intToIP(l.lastBlockEnd(), false).String(); got != "169.254.60.31" {
+		t.Errorf("lastBlockEnd() = %s, want 169.254.60.31", got)
+	}
+
+	want := []string{"169.254.60.0-169.254.60.3", "169.254.60.8-169.254.60.19", "169.254.60.30-169.254.60.30"}
+	if got := extentStrings(l.occupied()); !equalStrings(got, want) {
+		t.Errorf("occupied() = %v, want %v", got, want)
+	}
+
+	//the partial block after the excluded address isn't a free run
+	want = []string{"169.254.60.4-169.254.60.7", "169.254.60.20-169.254.60.27"}
+	if got := extentStrings(l.freeRuns()); !equalStrings(got, want) {
+		t.Errorf("freeRuns() = %v, want %v", got, want)
+	}
+
+	if got := l.countBlocks(l.allocated).Int64(); got != 3 {
+		t.Errorf("countBlocks(allocated) = %d, want 3", got)
+	}
+
+	//the extents beyond the last whole block are not counted
+	if got := l.countBlocks([]extent{testExtent(t, "169.254.60.28", "169.254.60.40")}).Int64(); got != 1 {
+		t.Errorf("countBlocks(last block) = %d, want 1", got)
+	}
+}
+
+func TestLayoutStats(t *testing.T) {
+	stats := layoutStats([]*rangeLayout{testLayout(t)})
+
+	for _, test := range []struct {
+		name string
+		got  int64
+		want int64
+	}{
+		{"BlockSize", stats.BlockSize, 4},
+		{"TotalBlocks", stats.TotalBlocks, 8},
+		{"AllocatedBlocks", stats.AllocatedBlocks, 3},
+		{"QuarantinedBlocks", stats.QuarantinedBlocks, 1},
+		{"ExcludedBlocks", stats.ExcludedBlocks, 1},
+		{"FreeBlocks", stats.FreeBlocks, 3},
+		{"FreeRuns", stats.FreeRuns, 2},
+		{"LargestFree", stats.LargestFree, 2},
+	} {
+		if test.got != test.want {
+			t.Errorf("%s = %d, want %d", test.name, test.got, test.want)
+		}
+	}
+
+	if stats.Range != "169.254.60.0-169.254.60.33" {
+		t.Errorf("Range = %s, want 169.254.60.0-169.254.60.33", stats.Range)
+	}
+
+	if stats.Exhausted {
+		t.Error("Exhausted = true, want false")
+	}
+
+	for _, test := range []struct {
+		name string
+		got  float64
+		want float64
+	}{
+		{"Utilization", stats.Utilization, 3.0 / 7},
+		{"Fragmentation", stats.Fragmentation, 1 - 2.0/3},
+		{"HighWaterMarkRatio", stats.HighWaterMarkRatio, 15.0 / 33},
+	} {
+		if math.Abs(test.got-test.want) > 1e-9 {
+			t.Errorf("%s = %v, want %v", test.name, test.got, test.want)
+		}
+	}
+
+	if stats.HighWaterMark != "169.254.60.15" {
+		t.Errorf("HighWaterMark = %s, want 169.254.60.15", stats.HighWaterMark)
+	}
+}
+
+func TestLayoutStatsMultipleRanges(t *testing.T) {
+	second, err := newIPRange("169.254.70.0", "169.254.70.7")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	layouts := []*rangeLayout{
+		testLayout(t),
+		{
+			rng:       second,
+			blockSize: big.NewInt(4),
+			allocated: []extent{testExtent(t, "169.254.70.4", "169.254.70.7")},
+		},
+	}
+
+	stats := layoutStats(layouts)
+	if stats.TotalBlocks != 10 || stats.AllocatedBlocks != 4 || stats.FreeBlocks != 4 {
+		t.Errorf("blocks (total, allocated, free) = (%d, %d, %d), want (10, 4, 4)",
+			stats.TotalBlocks, stats.AllocatedBlocks, stats.FreeBlocks)
+	}
+
+	//the high-water mark is in the last range (its offset counts the sizes of the previous ranges)
+	if stats.HighWaterMark != "169.254.70.7" {
+		t.Errorf("HighWaterMark = %s, want 169.254.70.7", stats.HighWaterMark)
+	}
+
+	if stats.HighWaterMarkRatio != 1 {
+		t.Errorf("HighWaterMarkRatio = %v, want 1", stats.HighWaterMarkRatio)
+	}
+
+	if stats.Range != "169.254.60.0-169.254.60.33,169.254.70.0-169.254.70.7" {
+		t.Errorf("Range = %s", stats.Range)
+	}
+}
+
+func TestStats(t *testing.T) {
+	store := newTestStore(t)
+	//the pool manager restores the saved pool range
+	store.SavePool(NewPoolInfo("169.254.60.0", "169.254.60.33", "169.254.60.0"))
+	pool := New(&Config{PoolBlockSize: 4, Exclude: []string{"169.254.60.30"}}, store)
+
+	for _, key := range []string{"vm-1", "vm-2", "vm-3"} {
+		pool.Allocate(key, false)
+	}
+
+	if err := pool.WithHolderOverride().Free("", "vm-2"); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := pool.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name string
+		got  int64
+		want int64
+	}{
+		{"BlockSize", stats.BlockSize, 4},
+		{"TotalBlocks", stats.TotalBlocks, 8},
+		{"AllocatedBlocks", stats.AllocatedBlocks, 2},
+		{"ExcludedBlocks", stats.ExcludedBlocks, 1},
+		{"FreeBlocks", stats.FreeBlocks, 5},
+		{"FreeRuns", stats.FreeRuns, 2},
+		{"LargestFree", stats.LargestFree, 4},
+	} {
+		if test.got != test.want {
+			t.Errorf("%s = %d, want %d", test.name, test.got, test.want)
+		}
+	}
+
+	for _, test := range []struct {
+		name string
+		got  float64
+		want float64
+	}{
+		{"Utilization", stats.Utilization, 2.0 / 7},
+		{"Fragmentation", stats.Fragmentation, 1 - 4.0/5},
+		{"HighWaterMarkRatio", stats.HighWaterMarkRatio, 11.0 / 33},
+	} {
+		if math.Abs(test.got-test.want) > 1e-9 {
+			t.Errorf("%s = %v, want %v", test.name, test.got, test.want)
+		}
+	}
+
+	if stats.Range != "169.254.60.0-169.254.60.33" || stats.HighWaterMark != "169.254.60.11" || stats.Next != "169.254.60.12" {
+		t.Errorf("(Range, HighWaterMark, Next) = (%s, %s, %s), want (169.254.60.0-169.254.60.33, 169.254.60.11, 169.254.60.12)",
+			stats.Range, stats.HighWaterMark, stats.Next)
+	}
+}
+
+func TestStatsQuarantine(t *testing.T) {
+	store := newTestStore(t)
+	store.SavePool(NewPoolInfo("169.254.60.0", "169.254.60.33", "169.254.60.0"))
+	pool := New(&Config{PoolBlockSize: 4, QuarantinePeriod: time.Hour}, store)
+
+	for _, key := range []string{"vm-1", "vm-2", "vm-3"} {
+		pool.Allocate(key, false)
+	}
+
+	if err := pool.WithHolderOverride().Free("", "vm-2"); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := pool.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats.AllocatedBlocks != 2 || stats.QuarantinedBlocks != 1 || stats.FreeBlocks != 5 || stats.FreeRuns != 1 {
+		t.Errorf("(allocated, quarantined, free, free runs) = (%d, %d, %d, %d), want (2, 1, 5, 1)",
+			stats.AllocatedBlocks, stats.QuarantinedBlocks, stats.FreeBlocks, stats.FreeRuns)
+	}
+
+	//the quarantined blocks are not free, but they count as allocatable blocks
+	if want := 2.0 / 8; stats.Utilization != want {
+		t.Errorf("Utilization = %v, want %v", stats.Utilization, want)
+	}
+}
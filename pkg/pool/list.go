@@ -0,0 +1,404 @@
+package pool
+
+import (
+	"encoding/base64"
+	"errors"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// Allocation list sort orders
+const (
+	SortByAddress = "address"
+	SortByTime    = "time"
+)
+
+// Allocation states
+const (
+	StateAllocated   = "allocated"
+	StateDelegated   = "delegated"
+	StatePaired      = "paired"
+	StateGrouped     = "grouped"
+	StateQuarantined = "quarantined"
+)
+
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+	//listBatchSize is the number of block records fetched in one Consul transaction (the transaction limit is 64)
+	listBatchSize   = 64
+	cursorSeparator = "|"
+)
+
+// List errors
+var (
+	//
+	ErrInvalidListOptions = errors.New("Invalid allocation list options")
+	//
+	ErrInvalidCursor = errors.New("Invalid allocation list cursor")
+)
+
+// ListOptions contains the allocation list filters and the pagination parameters
+// (the empty filters match all allocations)
+type ListOptions struct {
+	//KeyPrefix selects the blocks with the Block Key prefix
+	KeyPrefix string
+	//Selector is the label selector (see ParseSelector)
+	Selector string
+	//Range is the IP range ("start-end") or the CIDR the block start address is in
+	Range string
+	//State is the allocation state (allocated, delegated, paired, grouped or quarantined)
+	State         string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	//Sort is the list order (address or time; the default is address)
+	Sort string
+	//Cursor is the Next value from the previous page
+	Cursor string
+	//Limit is the page size (the default is 100 and the maximum is 1000)
+	Limit int
+}
+
+// Allocation is an allocation list entry
+type Allocation struct {
+	*BlockInfo
+	State string `json:"state"`
+	//Until is the end of the quarantine period (for the quarantined blocks)
+	Until *time.Time `json:"until,omitempty"`
+}
+
+// AllocationPage is a page of the allocation list
+type AllocationPage struct {
+	Allocations []*Allocation `json:"allocations"`
+	//Next is the cursor for the next page (empty on the last page)
+	Next string `json:"next,omitempty"`
+}
+
+// State returns the block allocation state
+func (block *BlockInfo) State() string {
+	switch {
+	case block.Pool != "":
+		return StateDelegated
+	case block.PairBlock != "":
+		return StatePaired
+	case block.Group != "":
+		return StateGrouped
+	}
+
+	return StateAllocated
+}
+
+// listFilter is the parsed allocation list filter
+type listFilter struct {
+	opts     *ListOptions
+	selector Selector
+	rng      *ipRange
+}
+
+func (f *listFilter) inRange(start net.IP) bool {
+	if f.rng == nil {
+		return true
+	}
+
+	return start != nil && isIPv6(start) == f.rng.ipv6 && f.rng.contains(ipToInt(start))
+}
+
+func (f *listFilter) matches(a *Allocation) bool {
+	if a.State != f.opts.State && f.opts.State != "" {
+		return false
+	}
+
+	if !strings.HasPrefix(a.Key, f.opts.KeyPrefix) || !f.selector.Matches(a.Labels) {
+		return false
+	}
+
+	if !f.opts.CreatedAfter.IsZero() && !a.Created.After(f.opts.CreatedAfter) {
+		return false
+	}
+
+	if !f.opts.CreatedBefore.IsZero() && !a.Created.Before(f.opts.CreatedBefore) {
+		return false
+	}
+
+	return f.inRange(net.ParseIP(a.Start))
+}
+
+// listPosition is the allocation position in the list order (also encoded in the cursors)
+type listPosition struct {
+	created time.Time
+	start   net.IP
+}
+
+func (p *listPosition) less(other *listPosition, byTime bool) bool {
+	if byTime && !p.created.Equal(other.created) {
+		return p.created.Before(other.created)
+	}
+
+	if isIPv6(p.start) != isIPv6(other.start) {
+		return !isIPv6(p.start)
+	}
+
+	return ipToInt(p.start).Cmp(ipToInt(other.start)) < 0
+}
+
+func (p *listPosition) cursor(byTime bool) string {
+	value := p.start.String()
+	if byTime {
+		value = p.created.Format(time.RFC3339Nano) + cursorSeparator + value
+	}
+
+	return base64.RawURLEncoding.EncodeToString([]byte(value))
+}
+
+func parseCursor(cursor string, byTime bool) (*listPosition, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	pos := &listPosition{}
+	value := string(raw)
+	if byTime {
+		parts := strings.SplitN(value, cursorSeparator, 2)
+		if len(parts) != 2 {
+			return nil, ErrInvalidCursor
+		}
+
+		if pos.created, err = time.Parse(time.RFC3339Nano, parts[0]); err != nil {
+			return nil, ErrInvalidCursor
+		}
+
+		value = parts[1]
+	}
+
+	if pos.start = net.ParseIP(value); pos.start == nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return pos, nil
+}
+
+func positionOf(a *Allocation) *listPosition {
+	return &listPosition{created: a.Created, start: net.ParseIP(a.Start)}
+}
+
+// List returns a page of the allocations (in the pool manager namespace) that match the filters.
+// The address order pages fetch only the block records they need,
+// the time order pages (and the quarantined blocks) load all records.
+func (pool *Manager) List(opts *ListOptions) (*AllocationPage, error) {
+	if opts == nil {
+		opts = &ListOptions{}
+	}
+
+	byTime := false
+	switch opts.Sort {
+	case "", SortByAddress:
+	case SortByTime:
+		byTime = true
+	default:
+		return nil, ErrInvalidListOptions
+	}
+
+	switch opts.State {
+	case "", StateAllocated, StateDelegated, StatePaired, StateGrouped, StateQuarantined:
+	default:
+		return nil, ErrInvalidListOptions
+	}
+
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+	if limit > maxListLimit {
+		return nil, ErrInvalidListOptions
+	}
+
+	filter := &listFilter{opts: opts}
+
+	var err error
+	if filter.selector, err = ParseSelector(opts.Selector); err != nil {
+		return nil, err
+	}
+
+	if opts.Range != "" {
+		if filter.rng, err = parseIPRange(opts.Range); err != nil {
+			return nil, ErrInvalidListOptions
+		}
+	}
+
+	var after *listPosition
+	if opts.Cursor != "" {
+		if after, err = parseCursor(opts.Cursor, byTime); err != nil {
+			return nil, err
+		}
+	}
+
+	var found []*Allocation
+	if byTime || opts.State == StateQuarantined {
+		found = pool.listAll(filter, after, byTime, limit+1)
+	} else {
+		found = pool.listByAddress(filter, after, limit+1)
+	}
+
+	page := &AllocationPage{Allocations: found}
+	if len(found) > limit {
+		page.Allocations = found[:limit]
+		page.Next = positionOf(found[limit-1]).cursor(byTime)
+	}
+
+	return page, nil
+}
+
+// listByAddress walks the block keys in the address order and fetches the block records in batches
+// until the page is full
+func (pool *Manager) listByAddress(filter *listFilter, after *listPosition, count int) []*Allocation {
+	var starts []*listPosition
+	for _, start := range pool.store.ListBlockStarts() {
+		pos := &listPosition{start: net.ParseIP(start)}
+		if pos.start == nil || !filter.inRange(pos.start) {
+			continue
+		}
+
+		if after != nil && !after.less(pos, false) {
+			continue
+		}
+
+		starts = append(starts, pos)
+	}
+
+	sort.Slice(starts, func(i, j int) bool {
+		return starts[i].less(starts[j], false)
+	})
+
+	found := []*Allocation{}
+	for len(starts) > 0 && len(found) < count {
+		n := listBatchSize
+		if n > len(starts) {
+			n = len(starts)
+		}
+
+		var batch []string
+		for _, pos := range starts[:n] {
+			batch = append(batch, pos.start.String())
+		}
+		starts = starts[n:]
+
+		for _, block := range pool.store.GetBlocks(batch) {
+			a := &Allocation{BlockInfo: block, State: block.State()}
+			if pool.inNamespace(block) && filter.matches(a) && len(found) < count {
+				found = append(found, a)
+			}
+		}
+	}
+
+	return found
+}
+
+// listAll loads all block records (or the quarantine records) and sorts the matching ones
+func (pool *Manager) listAll(filter *listFilter, after *listPosition, byTime bool, count int) []*Allocation {
+	var all []*Allocation
+	if filter.opts.State == StateQuarantined {
+		//NOTE: the quarantine records don't belong to a namespace
+		if pool.namespace == "" {
+			for _, q := range pool.store.ListQuarantine() {
+				until := q.Until
+				all = append(all, &Allocation{
+					BlockInfo: &BlockInfo{Start: q.Start, Key: q.Key, Size: q.Size, Created: q.Freed},
+					State:     StateQuarantined,
+					Until:     &until,
+				})
+			}
+		}
+	} else {
+		for _, block := range pool.Blocks() {
+			all = append(all, &Allocation{BlockInfo: block, State: block.State()})
+		}
+	}
+
+	found := []*Allocation{}
+	for _, a := range all {
+		if !filter.matches(a) {
+			continue
+		}
+
+		if after != nil && !after.less(positionOf(a), byTime) {
+			continue
+		}
+
+		found = append(found, a)
+	}
+
+	sort.Slice(found, func(i, j int) bool {
+		return positionOf(found[i]).less(positionOf(found[j]), byTime)
+	})
+
+	if len(found) > count {
+		found = found[:count]
+	}
+
+	return found
+}
+
+// ListBlockStarts returns the start addresses of all IP Blocks (only the record keys are fetched)
+func (s *Store) ListBlockStarts() []string {
+	prefix := s.key(poolBlocksKeyPrefix) + "/"
+	keys, _, err := s.kvAPI.Keys(prefix, "", nil)
+	if err != nil {
+		panic(err)
+	}
+
+	var starts []string
+	for _, key := range keys {
+		starts = append(starts, strings.TrimPrefix(key, prefix))
+	}
+
+	return starts
+}
+
+// GetBlocks returns the BlockInfo objects selected by the IP Block starting addresses
+// (the records are fetched in one Consul transaction; the removed blocks are skipped)
+func (s *Store) GetBlocks(starts []string) []*BlockInfo {
+	var ops api.KVTxnOps
+	for _, start := range starts {
+		ops = append(ops, &api.KVTxnOp{Verb: api.KVGet, Key: s.key(poolBlocksKeyPrefix, start)})
+	}
+
+	ok, resp, _, err := s.kvAPI.Txn(ops, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	var blocks []*BlockInfo
+	if !ok {
+		//the transaction fails if a block was removed after its key was listed
+		for _, start := range starts {
+			if block := s.GetBlock(start); block != nil {
+				blocks = append(blocks, block)
+			}
+		}
+
+		return blocks
+	}
+
+	byStart := map[string]*BlockInfo{}
+	for _, p := range resp.Results {
+		if p == nil || p.Value == nil {
+			continue
+		}
+
+		block := decodeBlock(p)
+		byStart[block.Start] = block
+	}
+
+	for _, start := range starts {
+		if block, found := byStart[start]; found {
+			blocks = append(blocks, block)
+		}
+	}
+
+	return blocks
+}
@@ -0,0 +1,208 @@
+package pool
+
+import (
+	"encoding/base64"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	created := time.Date(2020, 5, 17, 10, 30, 0, 123456789, time.UTC)
+	for _, test := range []struct {
+		name   string
+		start  string
+		byTime bool
+	}{
+		{name: "ipv4 by address", start: "169.254.60.4"},
+		{name: "ipv6 by address", start: "fd00::40"},
+		{name: "ipv4 by time", start: "169.254.60.4", byTime: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			pos := &listPosition{created: created, start: net.ParseIP(test.start)}
+			parsed, err := parseCursor(pos.cursor(test.byTime), test.byTime)
+			if err != nil {
+				t.Fatalf("parseCursor() error = %v", err)
+			}
+
+			if !parsed.start.Equal(pos.start) {
+				t.Errorf("start = %s, want %s", parsed.start, pos.start)
+			}
+
+			if test.byTime && !parsed.created.Equal(created) {
+				t.Errorf("created = %s, want %s", parsed.created, created)
+			}
+		})
+	}
+}
+
+func TestParseCursorErrors(t *testing.T) {
+	encode := func(value string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(value))
+	}
+
+	for _, test := range []struct {
+		name   string
+		cursor string
+		byTime bool
+	}{
+		{name: "not base64", cursor: "!!!"},
+		{name: "padded base64", cursor: base64.URLEncoding.EncodeToString([]byte("169.254.60.40"))},
+		{name: "not an address", cursor: encode("vm-1")},
+		{name: "address cursor by time", cursor: encode("169.254.60.4"), byTime: true},
+		{name: "invalid time", cursor: encode("yesterday|169.254.60.4"), byTime: true},
+		{name: "time cursor by address", cursor: encode("2020-05-17T10:30:00Z|169.254.60.4")},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := parseCursor(test.cursor, test.byTime); err != ErrInvalidCursor {
+				t.Errorf("parseCursor(%q) error = %v, want %v", test.cursor, err, ErrInvalidCursor)
+			}
+		})
+	}
+}
+
+func TestListPositionOrder(t *testing.T) {
+	early := &listPosition{created: time.Unix(100, 0), start: net.ParseIP("169.254.60.8")}
+	late := &listPosition{created: time.Unix(200, 0), start: net.ParseIP("169.254.60.4")}
+	v6 := &listPosition{created: time.Unix(50, 0), start: net.ParseIP("fd00::")}
+
+	if !late.less(early, false) || early.less(late, false) {
+		t.Error("address order doesn't sort by the start address")
+	}
+
+	if !early.less(late, true) || late.less(early, true) {
+		t.Error("time order doesn't sort by the creation time")
+	}
+
+	if !late.less(v6, false) || v6.less(late, false) {
+		t.Error("address order doesn't put the IPv4 blocks first")
+	}
+}
+
+func TestListInvalidOptions(t *testing.T) {
+	//the options are validated before the Store is used
+	pool := &Manager{}
+	for _, test := range []struct {
+		name    string
+		opts    *ListOptions
+		wantErr error
+	}{
+		{name: "sort", opts: &ListOptions{Sort: "size"}, wantErr: ErrInvalidListOptions},
+		{name: "state", opts: &ListOptions{State: "free"}, wantErr: ErrInvalidListOptions},
+		{name: "limit", opts: &ListOptions{Limit: maxListLimit + 1}, wantErr: ErrInvalidListOptions},
+		{name: "range", opts: &ListOptions{Range: "169.254.60.0/33"}, wantErr: ErrInvalidListOptions},
+		{name: "selector", opts: &ListOptions{Selector: "env in prod"}, wantErr: ErrInvalidSelector},
+		{name: "cursor", opts: &ListOptions{Cursor: "!!!"}, wantErr: ErrInvalidCursor},
+		{
+			name:    "address cursor by time",
+			opts:    &ListOptions{Sort: SortByTime, Cursor: (&listPosition{start: net.ParseIP("169.254.60.4")}).cursor(false)},
+			wantErr: ErrInvalidCursor,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := pool.List(test.opts); err != test.wantErr {
+				t.Errorf("List() error = %v, want %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestListFilter(t *testing.T) {
+	selector, err := ParseSelector("env=prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rng, err := parseIPRange("169.254.60.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created := time.Date(2020, 5, 17, 0, 0, 0, 0, time.UTC)
+	filter := &listFilter{
+		opts: &ListOptions{
+			KeyPrefix:     "acme:",
+			State:         StateAllocated,
+			CreatedAfter:  created.Add(-time.Hour),
+			CreatedBefore: created.Add(time.Hour),
+		},
+		selector: selector,
+		rng:      rng,
+	}
+
+	allocation := func(change func(block *BlockInfo)) *Allocation {
+		block := &BlockInfo{Start: "169.254.60.4", Key: "acme:vm-1", Labels: map[string]string{"env": "prod"}, Created: created}
+		change(block)
+		return &Allocation{BlockInfo: block, State: block.State()}
+	}
+
+	for _, test := range []struct {
+		name   string
+		change func(block *BlockInfo)
+		want   bool
+	}{
+		{name: "match", change: func(block *BlockInfo) {}, want: true},
+		{name: "key prefix", change: func(block *BlockInfo) { block.Key = "other:vm-1" }},
+		{name: "labels", change: func(block *BlockInfo) { block.Labels = map[string]string{"env": "dev"} }},
+		{name: "state", change: func(block *BlockInfo) { block.Group = "g1" }},
+		{name: "range", change: func(block *BlockInfo) { block.Start = "169.254.61.4" }},
+		{name: "created after", change: func(block *BlockInfo) { block.Created = created.Add(-2 * time.Hour) }},
+		{name: "created before", change: func(block *BlockInfo) { block.Created = created.Add(2 * time.Hour) }},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := filter.matches(allocation(test.change)); got != test.want {
+				t.Errorf("matches() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestListPages(t *testing.T) {
+	pool := New(&Config{StartRange: "169.254.60.0", EndRange: "169.254.60.63", PoolBlockSize: 4}, newTestStore(t))
+	for i, key := range []string{"acme:vm-1", "acme:vm-2", "other:vm-3", "acme:vm-4", "acme:vm-5"} {
+		labels := map[string]string{"env": "prod"}
+		if i == 1 {
+			labels["env"] = "dev"
+		}
+
+		if _, err := pool.AllocateWith(&AllocationRequest{Key: key, Labels: labels}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	opts := &ListOptions{KeyPrefix: "acme:", Selector: "env=prod", Limit: 2}
+	var keys []string
+	for pages := 0; pages < 3; pages++ {
+		page, err := pool.List(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, allocation := range page.Allocations {
+			keys = append(keys, allocation.Key)
+		}
+
+		if page.Next == "" {
+			break
+		}
+
+		opts.Cursor = page.Next
+	}
+
+	want := []string{"acme:vm-1", "acme:vm-4", "acme:vm-5"}
+	if !equalStrings(keys, want) {
+		t.Errorf("listed keys = %v, want %v", keys, want)
+	}
+
+	//the last page has no cursor
+	opts.Cursor = ""
+	opts.Limit = 3
+	page, err := pool.List(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(page.Allocations) != 3 || page.Next != "" {
+		t.Errorf("List(limit=3) = %d allocations (next %q), want 3 allocations without the next cursor", len(page.Allocations), page.Next)
+	}
+}
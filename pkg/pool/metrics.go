@@ -0,0 +1,91 @@
+package pool
+
+import (
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/hashicorp/consul/api"
+)
+
+// The pool metric keys are "pool.<metric>" followed by the "<label>:<value>" parts
+// (e.g., "pool:default"; the statsd sink flattens them to "pool_default")
+const (
+	metricAllocations       = "allocations"
+	metricFrees             = "frees"
+	metricErrors            = "errors"
+	metricLockWait          = "lock_wait_ms"
+	metricLockHold          = "lock_hold_ms"
+	metricBlocksCapacity    = "blocks_capacity"
+	metricBlocksAllocated   = "blocks_allocated"
+	metricBlocksQuarantined = "blocks_quarantined"
+	metricUtilization       = "utilization"
+
+	errorTypeOther = "other"
+)
+
+// errorTypes are the error type labels of the allocation errors
+var errorTypes = map[error]string{
+	ErrInvalidLabels:     "invalid_labels",
+	ErrQuotaExceeded:     "quota_exceeded",
+	ErrPoolExhausted:     "pool_exhausted",
+	ErrSupernetExhausted: "supernet_exhausted",
+	ErrBlockNotFound:     "block_not_found",
+	ErrBlockDelegated:    "block_delegated",
+	ErrBlockPaired:       "block_paired",
+	ErrBlockGrouped:      "block_grouped",
+	ErrHolderRequired:    "holder_required",
+	ErrHolderMismatch:    "holder_mismatch",
+}
+
+// MetricLabel returns the metric key part for the label
+func MetricLabel(name, value string) string {
+	return name + ":" + value
+}
+
+// metricKey returns the pool metric key with the pool name label
+func (pool *Manager) metricKey(name string, labels ...string) []string {
+	return append([]string{"pool", name, MetricLabel("pool", poolLabel(pool.name))}, labels...)
+}
+
+// countResult counts the successful operations (with the operation metric)
+// and the failed operations (with the errors metric by the operation and the error type)
+func (pool *Manager) countResult(op, metric string, err error) {
+	if err == nil {
+		metrics.IncrCounter(pool.metricKey(metric), 1)
+		return
+	}
+
+	errorType, ok := errorTypes[err]
+	if !ok {
+		errorType = errorTypeOther
+	}
+
+	metrics.IncrCounter(pool.metricKey(metricErrors, MetricLabel("op", op), MetricLabel("type", errorType)), 1)
+}
+
+// EmitStats sets the pool utilization gauges (the blocks used versus the allocatable blocks)
+func (pool *Manager) EmitStats() error {
+	stats, err := pool.Stats()
+	if err != nil {
+		return err
+	}
+
+	metrics.SetGauge(pool.metricKey(metricBlocksCapacity), float32(stats.TotalBlocks-stats.ExcludedBlocks))
+	metrics.SetGauge(pool.metricKey(metricBlocksAllocated), float32(stats.AllocatedBlocks))
+	metrics.SetGauge(pool.metricKey(metricBlocksQuarantined), float32(stats.QuarantinedBlocks))
+	metrics.SetGauge(pool.metricKey(metricUtilization), float32(stats.Utilization))
+	return nil
+}
+
+// poolLock is the acquired pool lock (it records the lock hold time)
+type poolLock struct {
+	pool     *Manager
+	lock     *api.Lock
+	acquired time.Time
+}
+
+// Unlock releases the pool lock
+func (l *poolLock) Unlock() error {
+	metrics.MeasureSince(l.pool.metricKey(metricLockHold), l.acquired)
+	return l.lock.Unlock()
+}
@@ -0,0 +1,95 @@
+package pool
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+)
+
+// Namespace errors
+var (
+	//
+	ErrInvalidNamespace = errors.New("Invalid namespace")
+)
+
+// NamespaceStats contains the namespace allocation statistics
+type NamespaceStats struct {
+	Namespace       string `json:"namespace"`
+	AllocatedBlocks int64  `json:"allocated_blocks"`
+	Addresses       int64  `json:"addresses"`
+	//Utilization is the ratio of the namespace addresses to the allocatable (non-excluded) pool addresses
+	Utilization float64 `json:"utilization"`
+}
+
+// ValidNamespace returns true if the namespace name can be used in the Block Keys and the Store keys
+func ValidNamespace(namespace string) bool {
+	return !strings.ContainsAny(namespace, "/*")
+}
+
+// WithNamespace returns a pool manager scoped to the selected namespace
+// (the Block Keys are unique per namespace and the IP Blocks from the other namespaces are not visible).
+// The default namespace is "".
+func (pool *Manager) WithNamespace(namespace string) (*Manager, error) {
+	if !ValidNamespace(namespace) {
+		return nil, ErrInvalidNamespace
+	}
+
+	scoped := *pool
+	scoped.namespace = namespace
+	return &scoped, nil
+}
+
+// Namespace returns the namespace the pool manager is scoped to
+func (pool *Manager) Namespace() string {
+	return pool.namespace
+}
+
+// inNamespace returns true if the IP Block belongs to the pool manager namespace
+func (pool *Manager) inNamespace(block *BlockInfo) bool {
+	return block != nil && block.Namespace == pool.namespace
+}
+
+// getBlock returns the IP Block selected by its start address (only if it's in the pool manager namespace)
+func (pool *Manager) getBlock(ipBlock string) *BlockInfo {
+	if block := pool.store.GetBlock(ipBlock); pool.inNamespace(block) {
+		return block
+	}
+
+	return nil
+}
+
+// Blocks returns the IP Blocks allocated in the pool manager namespace
+func (pool *Manager) Blocks() []*BlockInfo {
+	var blocks []*BlockInfo
+	for _, block := range pool.store.ListBlocks() {
+		if pool.inNamespace(block) {
+			blocks = append(blocks, block)
+		}
+	}
+
+	return blocks
+}
+
+// NamespaceStats returns the allocation statistics for the pool manager namespace
+func (pool *Manager) NamespaceStats() (*NamespaceStats, error) {
+	pool, err := pool.load()
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &NamespaceStats{Namespace: pool.namespace}
+	addresses := big.NewInt(0)
+	for _, block := range pool.Blocks() {
+		stats.AllocatedBlocks++
+		addresses.Add(addresses, pool.sizeOf(block))
+	}
+	stats.Addresses = addresses.Int64()
+
+	poolStats := layoutStats(pool.layout())
+	if allocatable := poolStats.TotalBlocks - poolStats.ExcludedBlocks; allocatable > 0 {
+		allocatableAddresses := big.NewInt(0).Mul(big.NewInt(allocatable), pool.blockSize())
+		stats.Utilization = ratio(addresses, allocatableAddresses)
+	}
+
+	return stats, nil
+}
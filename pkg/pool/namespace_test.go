@@ -0,0 +1,78 @@
+package pool
+
+import (
+	"testing"
+)
+
+func TestNamespaces(t *testing.T) {
+	pool := New(&Config{StartRange: "169.254.60.0", EndRange: "169.254.60.15", PoolBlockSize: 4}, newTestStore(t))
+	for _, namespace := range []string{"a/b", "*"} {
+		if _, err := pool.WithNamespace(namespace); err != ErrInvalidNamespace {
+			t.Errorf("WithNamespace(%q) error = %v, want %v", namespace, err, ErrInvalidNamespace)
+		}
+	}
+
+	red, err := pool.WithNamespace("red")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blue, err := pool.WithNamespace("blue")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the Block Keys are unique per namespace
+	redBlock, err := red.Allocate("vm-1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blueBlock, err := blue.Allocate("vm-1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if redBlock.Start == blueBlock.Start || redBlock.Namespace != "red" || blueBlock.Namespace != "blue" {
+		t.Fatalf("Allocate(vm-1) = %s in %q and %s in %q, want different blocks in both namespaces",
+			redBlock.Start, redBlock.Namespace, blueBlock.Start, blueBlock.Namespace)
+	}
+
+	if block, err := red.Allocate("vm-1", false); err != nil || block.Start != redBlock.Start {
+		t.Errorf("Allocate(vm-1) again = %v, %v, want the existing block %s", block, err, redBlock.Start)
+	}
+
+	//the IP Blocks from the other namespaces are not visible
+	if block := pool.Lookup("", "vm-1"); block != nil {
+		t.Errorf("Lookup(vm-1) in the default namespace = %+v, want no block", block)
+	}
+
+	if block := blue.Lookup(redBlock.Start, ""); block != nil {
+		t.Errorf("Lookup(%s) in blue = %+v, want no block", redBlock.Start, block)
+	}
+
+	if err := blue.WithHolderOverride().Free(redBlock.Start, ""); err != ErrBlockNotFound {
+		t.Errorf("Free(%s) in blue error = %v, want %v", redBlock.Start, err, ErrBlockNotFound)
+	}
+
+	if blocks := red.Blocks(); len(blocks) != 1 || blocks[0].Start != redBlock.Start {
+		t.Errorf("Blocks() in red = %+v, want %s", blocks, redBlock.Start)
+	}
+
+	stats, err := red.NamespaceStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats.Namespace != "red" || stats.AllocatedBlocks != 1 || stats.Addresses != 4 || stats.Utilization != 0.25 {
+		t.Errorf("NamespaceStats() = %+v, want 1 block with 4 of 16 addresses", stats)
+	}
+
+	if err := red.WithHolderOverride().Free("", "vm-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if block := blue.Lookup("", "vm-1"); block == nil || block.Start != blueBlock.Start {
+		t.Errorf("Lookup(vm-1) in blue after the red free = %+v, want %s", block, blueBlock.Start)
+	}
+}
@@ -0,0 +1,239 @@
+package pool
+
+import (
+	"errors"
+	"net"
+
+	"github.com/kcq/poc-ipblock-pool/pkg/logging"
+)
+
+// Pool pair errors
+var (
+	//
+	ErrInvalidPair = errors.New("Pool pair needs an IPv4 and an IPv6 pool")
+	//
+	ErrBlockPaired = errors.New("Block is paired with a block from another pool")
+	//
+	ErrPairMismatch = errors.New("Block Key is used by an unpaired block")
+)
+
+// PairInfo contains the dual-stack (IPv4 and IPv6) IP Block pair
+type PairInfo struct {
+	Key  string     `json:"key"`
+	IPv4 *BlockInfo `json:"ipv4"`
+	IPv6 *BlockInfo `json:"ipv6"`
+}
+
+// Pair allocates the dual-stack IP Block pairs from an IPv4 and an IPv6 pool
+// (the IPv6 pool usually uses the prefix based block size, e.g., /64)
+type Pair struct {
+	v4 *Manager
+	v6 *Manager
+}
+
+// NewPair creates a new pool pair
+func NewPair(v4, v6 *Manager) (*Pair, error) {
+	if v4 == nil || v6 == nil || v4.ipv6() || !v6.ipv6() || v4.name == v6.name {
+		return nil, ErrInvalidPair
+	}
+
+	if v4.namespace != v6.namespace {
+		return nil, ErrInvalidPair
+	}
+
+	return &Pair{v4: v4, v6: v6}, nil
+}
+
+// WithNamespace returns a pool pair scoped to the selected namespace
+func (p *Pair) WithNamespace(namespace string) (*Pair, error) {
+	v4, err := p.v4.WithNamespace(namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	v6, err := p.v6.WithNamespace(namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Pair{v4: v4, v6: v6}, nil
+}
+
+// WithLogger returns a pool pair that uses the logger (e.g., the request logger)
+func (p *Pair) WithLogger(logger logging.Logger) *Pair {
+	return &Pair{v4: p.v4.WithLogger(logger), v6: p.v6.WithLogger(logger)}
+}
+
+// WithHolder returns a pool pair that presents the holder token when it frees the IP Block pairs
+func (p *Pair) WithHolder(token string) *Pair {
+	return &Pair{v4: p.v4.WithHolder(token), v6: p.v6.WithHolder(token)}
+}
+
+// WithHolderOverride returns a pool pair that frees the IP Block pairs without their holder tokens
+func (p *Pair) WithHolderOverride() *Pair {
+	return &Pair{v4: p.v4.WithHolderOverride(), v6: p.v6.WithHolderOverride()}
+}
+
+// lock acquires the locks for both pools (always in the same order)
+func (p *Pair) lock(caller string) func() {
+	lock4 := p.v4.acquireLock(caller + "(ipv4)")
+	lock6 := p.v6.acquireLock(caller + "(ipv6)")
+
+	return func() {
+		lock6.Unlock()
+		lock4.Unlock()
+	}
+}
+
+// Allocate reserves an IPv4 block and an IPv6 block together under one Block Key
+// (an existing pair is returned if the Block Key is already allocated)
+func (p *Pair) Allocate(req *AllocationRequest) (*PairInfo, error) {
+	if !ValidLabels(req.Labels) {
+		return nil, ErrInvalidLabels
+	}
+
+	unlock := p.lock("Pair.Allocate")
+	defer unlock()
+
+	tenant := TenantOf(req.Key, req.Tenant)
+
+	if req.Key != "" {
+		block4 := p.v4.store.FindBlock(p.v4.namespace, req.Key)
+		block6 := p.v6.store.FindBlock(p.v6.namespace, req.Key)
+
+		if block4 != nil || block6 != nil {
+			if block4 == nil || block6 == nil || block4.PairBlock != block6.Start {
+				return nil, ErrPairMismatch
+			}
+
+			p.v4.logger.Debug("Block pair is already allocated", "key", req.Key)
+			return &PairInfo{Key: req.Key, IPv4: block4, IPv6: block6}, nil
+		}
+	}
+
+	//NOTE: the blocks are selected in both pools before the pool metadata is changed,
+	//so a failed allocation in the second pool doesn't leave a half allocated pair
+	selection4, selection6, err := p.selectBlocks(req.Key, tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	block4, block6 := selection4.reserve(), selection6.reserve()
+	block4.PairPool, block4.PairBlock = p.v6.name, block6.Start
+	block6.PairPool, block6.PairBlock = p.v4.name, block4.Start
+	block4.Labels, block6.Labels = req.Labels, req.Labels
+	block4.Description, block6.Description = req.Description, req.Description
+	block4.Owner, block6.Owner = req.Owner, req.Owner
+	//both blocks share one holder token
+	block4.HolderToken, block4.HolderHash = newHolderToken()
+	block6.HolderToken, block6.HolderHash = block4.HolderToken, block4.HolderHash
+	p.v4.store.SaveBlock(block4)
+	p.v6.store.SaveBlock(block6)
+
+	//the threshold growth waits for the pair allocation (it's best effort)
+	selection4.pool.growOnThreshold()
+	selection6.pool.growOnThreshold()
+
+	p.v4.logger.Info("Allocated IP block pair", "key", req.Key, "ipv4", block4.Start, "ipv6", block6.Start)
+	return &PairInfo{Key: req.Key, IPv4: block4, IPv6: block6}, nil
+}
+
+// selectBlocks selects a new IP Block in both pools (must be called with both pool locks held).
+// The pools don't grow until the blocks are selected in both of them:
+// an exhausted pool grows only when the other pool has a block for the pair
+// (if both pools are exhausted the IPv4 pool keeps its new range even if the IPv6 pool can't grow).
+func (p *Pair) selectBlocks(key, tenant string) (*blockSelection, *blockSelection, error) {
+	selection4, err4 := p.v4.selectNewBlock(key, tenant, false)
+	if err4 != nil && err4 != ErrPoolExhausted {
+		return nil, nil, err4
+	}
+
+	selection6, err6 := p.v6.selectNewBlock(key, tenant, false)
+	if err6 != nil && err6 != ErrPoolExhausted {
+		return nil, nil, err6
+	}
+
+	if err4 != nil {
+		if selection4, err4 = p.v4.selectNewBlock(key, tenant, true); err4 != nil {
+			return nil, nil, err4
+		}
+	}
+
+	if err6 != nil {
+		if selection6, err6 = p.v6.selectNewBlock(key, tenant, true); err6 != nil {
+			return nil, nil, err6
+		}
+	}
+
+	return selection4, selection6, nil
+}
+
+// Lookup returns the IP Block pair by any address in either block or by the Block Key
+// (nil if the pair is not allocated)
+func (p *Pair) Lookup(address, blockKey string) *PairInfo {
+	var block *BlockInfo
+	var pool *Manager
+
+	if address != "" {
+		ip := net.ParseIP(address)
+		if ip == nil {
+			return nil
+		}
+
+		pool = p.v4
+		if isIPv6(ip) {
+			pool = p.v6
+		}
+
+		block = pool.findBlockContaining(ip)
+	} else if blockKey != "" {
+		pool = p.v4
+		block = p.v4.store.FindBlock(p.v4.namespace, blockKey)
+	}
+
+	if block == nil || !pool.inNamespace(block) || block.PairBlock == "" {
+		return nil
+	}
+
+	other := p.v6
+	if pool == p.v6 {
+		other = p.v4
+	}
+
+	pairBlock := other.store.GetBlock(block.PairBlock)
+	if pairBlock == nil {
+		return nil
+	}
+
+	info := &PairInfo{Key: block.Key, IPv4: block, IPv6: pairBlock}
+	if pool == p.v6 {
+		info.IPv4, info.IPv6 = pairBlock, block
+	}
+
+	return info
+}
+
+// Free releases both IP Blocks in the pair selected by any address in either block or by the Block Key
+func (p *Pair) Free(address, blockKey string) error {
+	unlock := p.lock("Pair.Free")
+	defer unlock()
+
+	info := p.Lookup(address, blockKey)
+	if info == nil {
+		return ErrBlockNotFound
+	}
+
+	if err := p.v4.checkHolder(info.IPv4); err != nil {
+		return err
+	}
+
+	if err := p.v6.checkHolder(info.IPv6); err != nil {
+		return err
+	}
+
+	p.v4.release(info.IPv4)
+	p.v6.release(info.IPv6)
+
+	p.v4.logger.Info("Freed IP block pair", "key", info.Key, "ipv4", info.IPv4.Start, "ipv6", info.IPv6.Start)
+	return nil
+}
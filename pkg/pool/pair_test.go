@@ -0,0 +1,109 @@
+package pool
+
+import (
+	"testing"
+)
+
+// newTestPair returns a pool pair with 4 IPv4 blocks (the IPv4 pool grows from its supernet)
+// and 2 IPv6 blocks sharing one test store
+func newTestPair(t *testing.T) *Pair {
+	store := newTestStore(t)
+	v4 := New(&Config{
+		StartRange:    "169.254.60.0",
+		EndRange:      "169.254.60.15",
+		PoolBlockSize: 4,
+		Supernet:      "169.254.60.0/24",
+		GrowthSize:    16,
+	}, store)
+	v6 := New(&Config{Name: "ipv6", StartRange: "fd00::", EndRange: "fd00:0:0:1:ffff:ffff:ffff:ffff", BlockPrefix: 64}, store)
+
+	pair, err := NewPair(v4, v6)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pair
+}
+
+func TestPairAllocate(t *testing.T) {
+	pair := newTestPair(t)
+	info, err := pair.Allocate(&AllocationRequest{
+		Key:         "acme:vm-1",
+		Labels:      map[string]string{"env": "prod"},
+		Description: "web server",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, block := range []*BlockInfo{info.IPv4, info.IPv6} {
+		if block.Labels["env"] != "prod" || block.Description != "web server" || block.Tenant != "acme" {
+			t.Errorf("block %s = labels %v, description %q, tenant %q, want the request values",
+				block.Start, block.Labels, block.Description, block.Tenant)
+		}
+	}
+
+	if info.IPv4.PairBlock != info.IPv6.Start || info.IPv6.PairBlock != info.IPv4.Start {
+		t.Errorf("pair blocks = %s and %s, want the blocks paired with each other", info.IPv4.PairBlock, info.IPv6.PairBlock)
+	}
+
+	//the saved blocks have the same metadata
+	saved := pair.Lookup(info.IPv6.Start, "")
+	if saved == nil || saved.IPv4.Description != "web server" || saved.IPv6.Labels["env"] != "prod" {
+		t.Errorf("Lookup() = %+v, want the pair with the request labels and description", saved)
+	}
+}
+
+func TestPairAllocateExhausted(t *testing.T) {
+	pair := newTestPair(t)
+	for _, key := range []string{"vm-1", "vm-2"} {
+		if _, err := pair.Allocate(&AllocationRequest{Key: key}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	before, err := pair.v4.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the IPv6 pool is exhausted and it can't grow
+	if _, err := pair.Allocate(&AllocationRequest{Key: "vm-3"}); err != ErrPoolExhausted {
+		t.Fatalf("Allocate() error = %v, want %v", err, ErrPoolExhausted)
+	}
+
+	//the IPv4 pool doesn't change (it doesn't even grow when it's exhausted)
+	after, err := pair.v4.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if after.Next != before.Next || after.AllocatedBlocks != 2 || after.TotalBlocks != before.TotalBlocks {
+		t.Errorf("IPv4 pool = next %s, %d of %d blocks allocated, want next %s, 2 of %d blocks allocated",
+			after.Next, after.AllocatedBlocks, after.TotalBlocks, before.Next, before.TotalBlocks)
+	}
+
+	if block := pair.v4.Lookup("", "vm-3"); block != nil {
+		t.Errorf("Lookup(vm-3) = %s, want no IPv4 block", block.Start)
+	}
+}
+
+func TestPairAllocateGrowth(t *testing.T) {
+	pair := newTestPair(t)
+	pair.v6 = New(&Config{Name: "ipv6", StartRange: "fd00::", EndRange: "fd00:0:0:7:ffff:ffff:ffff:ffff", BlockPrefix: 64}, newTestStore(t))
+	for _, key := range []string{"vm-1", "vm-2", "vm-3", "vm-4"} {
+		if _, err := pair.Allocate(&AllocationRequest{Key: key}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//the exhausted IPv4 pool grows when the IPv6 pool has a block for the pair
+	info, err := pair.Allocate(&AllocationRequest{Key: "vm-5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.IPv4.Start != "169.254.60.16" {
+		t.Errorf("IPv4 block = %s, want 169.254.60.16 (from the new range)", info.IPv4.Start)
+	}
+}
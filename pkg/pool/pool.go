@@ -5,28 +5,39 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"math/big"
 	"net"
+	"reflect"
+	"strings"
 	"time"
 
+	"github.com/armon/go-metrics"
 	"github.com/hashicorp/consul/api"
 	"github.com/segmentio/ksuid"
+
+	"github.com/kcq/poc-ipblock-pool/pkg/logging"
 )
 
 const (
-	poolInfoKey          = "poc/pool/info"
-	poolLockKey          = "poc/pool/.lock"
-	poolBlocksKeyPrefix  = "poc/pool/blocks"
+	defaultPoolKeyPrefix = "poc/pool"
+	namedPoolsKeyPrefix  = "poc/pools"
+	poolInfoKey          = "info"
+	poolLockKey          = ".lock"
+	poolBlocksKeyPrefix  = "blocks"
 	defaultBaseSubnet    = "169.254.0.0/16"
 	defaultStartRange    = "169.254.51.0"
 	defaultEndRange      = "169.254.255.244"
 	defaultPoolBlockSize = 4
+	defaultPoolLabel     = "default"
 )
 
 // Pool errors
 var (
 	//
 	ErrBlockNotFound = errors.New("Block not found")
+	//
+	ErrPoolExhausted = errors.New("No free blocks in pool")
 )
 
 // StoreConfig contains the Pool Store configurations
@@ -34,14 +45,65 @@ type StoreConfig struct {
 	Address    string
 	Scheme     string
 	Datacenter string
+	//Token is the Consul ACL token
+	Token string
+	//TokenFile is the file with the Consul ACL token (used if Token is empty)
+	TokenFile string
+	//CAFile is the CA certificate (PEM) that verifies the Consul server certificate
+	CAFile string
+	//CertFile and KeyFile are the client certificate and key (PEM) for the Consul mTLS
+	CertFile string
+	KeyFile  string
+	//TLSServerName is the name in the Consul server certificate (the default is the Address host)
+	TLSServerName string
+}
+
+// tlsEnabled returns true if any TLS option is set (the TLS options switch the default scheme to https)
+func (c *StoreConfig) tlsEnabled() bool {
+	return c.CAFile != "" || c.CertFile != "" || c.KeyFile != "" || c.TLSServerName != ""
 }
 
 // Config contains the Pool (Manager) configurations
 type Config struct {
-	StartRange    string
-	EndRange      string
+	//Name is the pool name (the default pool has no name)
+	Name       string
+	StartRange string
+	EndRange   string
+	//Ranges contains the pool address ranges (IP ranges or CIDRs) for non-contiguous pools
+	//(StartRange/EndRange are ignored when Ranges is not empty)
+	Ranges        []string
 	PoolBlockSize int64
-	Store         *StoreConfig
+	//BlockPrefix is the block prefix length (e.g., 64 for the /64 IPv6 prefixes);
+	//it overrides PoolBlockSize and it's required for the blocks that are too large for PoolBlockSize
+	BlockPrefix int
+	Exclude     []string
+	//QuarantinePeriod is the time a freed IP Block can't be allocated again (disabled if 0)
+	QuarantinePeriod time.Duration
+	//ReserveNetworkAddresses keeps the network and the broadcast addresses (the first and the last address)
+	//of the IPv4 blocks with more than 2 addresses out of the address allocations
+	ReserveNetworkAddresses bool
+	//Strategy is the allocation strategy name (sequential, sequential-wrap, first-fit, random or best-fit)
+	Strategy string
+	//StrategySeed is the random strategy seed (0 means a time based seed)
+	StrategySeed int64
+	//AllocationStrategy is a custom allocation strategy (overrides Strategy)
+	AllocationStrategy AllocationStrategy
+	//Supernet is the CIDR the pool claims new ranges from when it grows (growth is disabled if it's empty)
+	Supernet string
+	//GrowthSize is the number of addresses claimed from the supernet every time the pool grows
+	GrowthSize int64
+	//GrowthThreshold is the utilization (0..1) that triggers the pool growth
+	//(0 means the pool grows only when an allocation would fail)
+	GrowthThreshold float64
+	Store           *StoreConfig
+	//Logger is the pool manager and Store logger (the pool manager is silent if it's nil)
+	Logger logging.Logger
+}
+
+// Range is a pool IP address range (inclusive)
+type Range struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
 }
 
 // Info contains the Pool metadata persisted in the Pool Store
@@ -49,6 +111,19 @@ type Info struct {
 	Start string `json:"start"`
 	End   string `json:"end"`
 	Next  string `json:"next"`
+	//Ranges contains the pool address ranges ordered by their start address
+	//(Start and End are the pool boundaries; if Ranges is empty Start/End is the only range)
+	Ranges []*Range `json:"ranges,omitempty"`
+	//Exclude contains the IP addresses, CIDRs or IP ranges excluded from allocation
+	Exclude []string `json:"exclude,omitempty"`
+	//BlockSize is the pool block size (it can't be changed once the pool is created)
+	BlockSize int64 `json:"block_size,omitempty"`
+	//BlockPrefix is the pool block prefix length (it overrides BlockSize)
+	BlockPrefix int `json:"block_prefix,omitempty"`
+	//Parent is the parent pool name (for the child pools created from a parent pool block)
+	Parent string `json:"parent,omitempty"`
+	//ParentBlock is the parent pool block delegated to the child pool (empty if there's no parent)
+	ParentBlock string `json:"parent_block,omitempty"`
 }
 
 // NewPoolInfo creates a new Pool Info object
@@ -62,11 +137,75 @@ func NewPoolInfo(start, end, next string) *Info {
 	return &info
 }
 
+// RangeList returns the pool address ranges
+func (info *Info) RangeList() []*Range {
+	if len(info.Ranges) > 0 {
+		return info.Ranges
+	}
+
+	return []*Range{{Start: info.Start, End: info.End}}
+}
+
+// SetRanges updates the pool address ranges and the pool boundaries
+func (info *Info) SetRanges(ranges []*Range) {
+	info.Start = ranges[0].Start
+	info.End = ranges[len(ranges)-1].End
+	info.Ranges = nil
+	if len(ranges) > 1 {
+		info.Ranges = ranges
+	}
+}
+
 // BlockInfo contains the IP Block metadata persisted in the Pool Store
 type BlockInfo struct {
 	ID    string `json:"id"`
 	Start string `json:"start"`
 	Key   string `json:"key"`
+	//Size is the number of addresses in the block (0 means the pool block size)
+	Size int64 `json:"size,omitempty"`
+	//Pool is the child pool name if the block is delegated to a child pool
+	Pool string `json:"pool,omitempty"`
+	//Tenant is the tenant that allocated the block (its quota limits the tenant allocations)
+	Tenant string `json:"tenant,omitempty"`
+	//Owner is the authenticated identity that allocated the block
+	Owner string `json:"owner,omitempty"`
+	//Namespace is the namespace the Block Key belongs to (the default namespace is "")
+	Namespace string `json:"namespace,omitempty"`
+	//PairPool is the pool with the paired (other address family) block for the dual-stack allocations
+	PairPool string `json:"pair_pool,omitempty"`
+	//PairBlock is the start address of the paired block
+	PairBlock string `json:"pair_block,omitempty"`
+	//Group is the ID of the block group (for the blocks allocated together with AllocateGroup)
+	Group string `json:"group,omitempty"`
+	//Labels are the user defined block labels (used by the label selectors)
+	Labels map[string]string `json:"labels,omitempty"`
+	//Description is the user defined block description
+	Description string `json:"description,omitempty"`
+	//Created is the block allocation time (zero for the blocks allocated before it was recorded)
+	Created time.Time `json:"created"`
+	//Version is the Consul ModifyIndex of the block record (it's not persisted in the record)
+	Version uint64 `json:"version,omitempty"`
+	//HolderHash is the SHA-256 hash of the holder token (the token is required to free or change the block;
+	//the hash is persisted in the block record, but it's not a part of the API output)
+	HolderHash string `json:"-"`
+	//HolderToken is the secret holder token (it's returned only when the block is allocated and it's not persisted)
+	HolderToken string `json:"holder_token,omitempty"`
+}
+
+// AllocationRequest contains the IP Block allocation parameters
+type AllocationRequest struct {
+	//Key is the Block Key (an existing allocation with the same key is returned)
+	Key string
+	//Tenant is the tenant name (if it's empty the tenant is the Block Key prefix: "tenant:key")
+	Tenant string
+	//Labels are the new block labels
+	Labels map[string]string
+	//Description is the new block description
+	Description string
+	//Owner is the authenticated identity that requests the allocation
+	Owner string
+	//DelayUnlock keeps the pool lock for a while to demo concurrent allocations
+	DelayUnlock bool
 }
 
 // NewBlockInfo creates a new IP Block Info object
@@ -77,9 +216,10 @@ func NewBlockInfo(start, key string) *BlockInfo {
 	}
 
 	info := BlockInfo{
-		ID:    id.String(),
-		Start: start,
-		Key:   key,
+		ID:      id.String(),
+		Start:   start,
+		Key:     key,
+		Created: time.Now().UTC(),
 	}
 
 	return &info
@@ -87,14 +227,28 @@ func NewBlockInfo(start, key string) *BlockInfo {
 
 // Manager is responsible for managing the IP Block Pool
 type Manager struct {
-	store         *Store
-	info          *Info
-	startIP       net.IP
-	endIP         net.IP
-	nextBlock     net.IP
-	poolBlockSize int64
-	startRange    string
-	endRange      string
+	name             string
+	store            *Store
+	info             *Info
+	startIP          net.IP
+	endIP            net.IP
+	nextBlock        net.IP
+	poolBlockSize    int64
+	blockPrefix      int
+	startRange       string
+	endRange         string
+	rangeList        []string
+	exclude          []string
+	excluded         []*ipRange
+	quarantinePeriod time.Duration
+	reserveNetwork   bool
+	strategy         AllocationStrategy
+	growth           *growth
+	namespace        string
+	holderToken      string
+	holderOverride   bool
+	children         *childManagers
+	logger           logging.Logger
 }
 
 // New creates a new Pool Manager object
@@ -104,109 +258,277 @@ func New(configInfo *Config, store *Store) *Manager {
 	}
 
 	if store == nil {
-		fmt.Println("pool.New: using the default Store...")
 		store = NewStore(nil)
 	}
+
+	if configInfo != nil && configInfo.Logger != nil {
+		store = store.WithLogger(configInfo.Logger)
+	}
+
+	var name string
+	if configInfo != nil {
+		name = configInfo.Name
+	}
+
 	pool := Manager{
-		store:         store,
+		name:          name,
+		store:         store.ForPool(name),
+		logger:        store.logger.With("pool", poolLabel(name)),
 		poolBlockSize: defaultPoolBlockSize,
 		startRange:    defaultStartRange,
 		endRange:      defaultEndRange,
+		children:      &childManagers{managers: map[string]*Manager{}},
 	}
 
 	if configInfo != nil {
+		if configInfo.AllocationStrategy != nil {
+			pool.strategy = configInfo.AllocationStrategy
+		} else {
+			strategy, err := NewStrategy(configInfo.Strategy, configInfo.StrategySeed)
+			if err != nil {
+				panic(err)
+			}
+
+			pool.strategy = strategy
+		}
+
 		if configInfo.StartRange != "" {
 			pool.startRange = configInfo.StartRange
 		}
 
-		if configInfo.EndRange == "" {
+		if configInfo.EndRange != "" {
 			pool.endRange = configInfo.EndRange
 		}
 
-		if configInfo.PoolBlockSize == 0 {
+		if configInfo.PoolBlockSize > 0 {
 			pool.poolBlockSize = configInfo.PoolBlockSize
 		}
+
+		pool.blockPrefix = configInfo.BlockPrefix
+
+		pool.rangeList = configInfo.Ranges
+		pool.exclude = configInfo.Exclude
+		pool.quarantinePeriod = configInfo.QuarantinePeriod
+		pool.reserveNetwork = configInfo.ReserveNetworkAddresses
+	}
+
+	if pool.strategy == nil {
+		pool.strategy = &SequentialStrategy{}
+	}
+
+	if configInfo != nil {
+		growth, err := newGrowth(configInfo, pool.blockSize())
+		if err != nil {
+			panic(err)
+		}
+
+		pool.growth = growth
 	}
 
-	fmt.Printf("pool.New: manager => %+v\n", pool)
+	pool.logger.Debug("Created pool manager",
+		"start", pool.startRange, "end", pool.endRange, "ranges", pool.rangeList,
+		"block_size", pool.poolBlockSize, "block_prefix", pool.blockPrefix, "strategy", pool.strategy.Name())
 
 	pool.init()
 	return &pool
 }
 
 func (pool *Manager) init() {
-	fmt.Println("Pool.init: trying to get the pool lock...")
-
-	lock := pool.store.GetLock()
-	lockCh, err := lock.Lock(nil)
-	if err != nil {
-		panic(err)
-	}
-	if lockCh == nil {
-		panic("did not lock")
-	}
+	lock := pool.acquireLock("Pool.init")
 	defer lock.Unlock()
-	fmt.Println("Pool.init: got the pool lock...")
 
 	pool.info = pool.store.GetPool()
 
 	if pool.info == nil {
-		fmt.Println("Pool Info - not initialized yet...")
-
 		pool.info = NewPoolInfo(pool.startRange, pool.endRange, pool.startRange)
+		pool.info.Exclude = pool.exclude
+		pool.info.BlockSize = pool.poolBlockSize
+		pool.info.BlockPrefix = pool.blockPrefix
+
+		if len(pool.rangeList) > 0 {
+			ranges, err := parseIPRanges(pool.rangeList)
+			if err != nil {
+				panic(err)
+			}
+
+			ranges, err = sortRanges(ranges)
+			if err != nil {
+				panic(err)
+			}
+
+			pool.info.SetRanges(toRanges(ranges))
+			pool.info.Next = pool.info.Start
+		}
+
 		pool.store.SavePool(pool.info)
+		pool.claimInitialRanges()
+		pool.logger.Info("Initialized pool info", "start", pool.info.Start, "end", pool.info.End)
 
-		pool.startIP = net.ParseIP(pool.startRange)
-		pool.endIP = net.ParseIP(pool.endRange)
+		pool.startIP = net.ParseIP(pool.info.Start)
+		pool.endIP = net.ParseIP(pool.info.End)
 		pool.nextBlock = pool.startIP
 
 	} else {
-		fmt.Printf("Pool Info - restored => %#v\n", pool.info)
+		pool.logger.Debug("Restored pool info", "start", pool.info.Start, "end", pool.info.End, "next", pool.info.Next)
 		pool.startIP = net.ParseIP(pool.info.Start)
 		pool.endIP = net.ParseIP(pool.info.End)
 		pool.nextBlock = net.ParseIP(pool.info.Next)
+
+		if pool.info.BlockSize > 0 {
+			pool.poolBlockSize = pool.info.BlockSize
+		}
+
+		pool.blockPrefix = pool.info.BlockPrefix
+
+		//NOTE: exclusions are allocation policy (not allocation state), so the config wins
+		if len(pool.exclude) > 0 && !reflect.DeepEqual(pool.exclude, pool.info.Exclude) {
+			pool.logger.Info("Updating pool exclusions", "exclude", pool.exclude)
+			pool.info.Exclude = pool.exclude
+			pool.store.SavePool(pool.info)
+		}
 	}
+
+	excluded, err := parseIPRanges(pool.info.Exclude)
+	if err != nil {
+		panic(err)
+	}
+
+	pool.excluded = excluded
 }
 
-func (pool *Manager) nextBlockFromRange() string {
-	fmt.Printf("nextBlockFromRange - pool.nextBlock => %#v\n", pool.nextBlock)
-	//NOTE: nextBlock needs to be fresh when nextBlockFromRange is called
-	allocated := pool.nextBlock.String()
+// load returns a copy of the pool manager with the current pool metadata from the Store.
+// The pool manager itself isn't changed (so the shared pool managers can be used concurrently):
+// each operation works with its own copy (the pool lock must be held to update the pool metadata).
+func (pool *Manager) load() (*Manager, error) {
+	info, err := pool.store.loadPool()
+	if err != nil {
+		return nil, err
+	}
 
-	if ipVal := pool.nextBlock.To4(); ipVal != nil {
-		ipNum := big.NewInt(0).SetBytes(ipVal)
-		pool.nextBlock = net.IP(ipNum.Add(ipNum, big.NewInt(pool.poolBlockSize)).Bytes())
+	loaded := *pool
+	if err := loaded.setInfo(info); err != nil {
+		return nil, err
+	}
 
-		//NOTE: info needs to be fresh when nextBlockFromRange is called
-		pool.info.Next = pool.nextBlock.String()
-		pool.store.SavePool(pool.info)
+	return &loaded, nil
+}
+
+// setInfo sets the pool metadata (only for the pool manager copies returned by load)
+func (pool *Manager) setInfo(info *Info) error {
+	excluded, err := parseIPRanges(info.Exclude)
+	if err != nil {
+		return ErrInvalidPoolInfo
+	}
 
-		fmt.Println("nextBlockFromRange - updated current pool info (nextBlock)...")
+	pool.info = info
+	pool.startIP = net.ParseIP(info.Start)
+	pool.endIP = net.ParseIP(info.End)
+	pool.nextBlock = net.ParseIP(info.Next)
+	pool.excluded = excluded
 
-	} else {
-		panic("nextBlockFromRange - unexpected IP value")
+	if info.BlockSize > 0 {
+		pool.poolBlockSize = info.BlockSize
 	}
 
-	return allocated
+	pool.blockPrefix = info.BlockPrefix
+	return nil
 }
 
-// Lookup returns the IP Block metadata by the IP Block start address 
-// or the Block Key or nil if the IP Block is not allocated yet
-func (pool *Manager) Lookup(ipBlock, blockKey string) *BlockInfo {
-	if ipBlock != "" {
-		return pool.store.GetBlock(ipBlock)
-	} else if blockKey != "" {
-		return pool.store.FindBlock(blockKey)
+// poolLabel returns the pool name for the logs and the metrics ("default" for the default pool)
+func poolLabel(name string) string {
+	if name == "" {
+		return defaultPoolLabel
 	}
 
-	return nil
+	return name
 }
 
-// Allocate returns the newly allocated IP Block or an existing IP Block
-// if the provided Block Key matches an existing IP Block allocation
-func (pool *Manager) Allocate(blockKey string, delayUnlock bool) *BlockInfo {
-	fmt.Println("Pool.Allocate - Trying to get the pool lock...")
+// WithLogger returns a pool manager that uses the logger (e.g., the request logger)
+func (pool *Manager) WithLogger(logger logging.Logger) *Manager {
+	scoped := *pool
+	scoped.store = pool.store.WithLogger(logger)
+	scoped.logger = logger.With("pool", poolLabel(pool.name))
+	return &scoped
+}
+
+// Name returns the pool name ("" for the default pool)
+func (pool *Manager) Name() string {
+	return pool.name
+}
+
+// Info returns the pool metadata
+func (pool *Manager) Info() (*Info, error) {
+	pool, err := pool.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return pool.info, nil
+}
+
+// ranges returns the pool address ranges
+func (pool *Manager) ranges() []*ipRange {
+	var ranges []*ipRange
+	for _, r := range pool.info.RangeList() {
+		rng, err := newIPRange(r.Start, r.End)
+		if err != nil {
+			panic(err)
+		}
+
+		ranges = append(ranges, rng)
+	}
+
+	return ranges
+}
+
+func (pool *Manager) blockSize() *big.Int {
+	if pool.blockPrefix > 0 {
+		bits := 32
+		if pool.ipv6() {
+			bits = 128
+		}
+
+		return big.NewInt(0).Lsh(big.NewInt(1), uint(bits-pool.blockPrefix))
+	}
+
+	return big.NewInt(pool.poolBlockSize)
+}
+
+// ipv6 returns true if the pool manages IPv6 addresses
+// (the pool info is used when it's available, otherwise the family comes from the configured ranges)
+func (pool *Manager) ipv6() bool {
+	if pool.startIP != nil {
+		return isIPv6(pool.startIP)
+	}
+
+	if len(pool.rangeList) > 0 {
+		if r, err := parseIPRange(pool.rangeList[0]); err == nil {
+			return r.ipv6
+		}
+	}
+
+	return isIPv6(net.ParseIP(pool.startRange))
+}
 
+// sizeOf returns the number of addresses in the IP Block
+func (pool *Manager) sizeOf(block *BlockInfo) *big.Int {
+	return pool.blockSizeOr(block.Size)
+}
+
+// blockSizeOr returns the provided block size or the pool block size if it's not set
+func (pool *Manager) blockSizeOr(size int64) *big.Int {
+	if size > 0 {
+		return big.NewInt(size)
+	}
+
+	return pool.blockSize()
+}
+
+// acquireLock acquires the pool lock (the lock wait time and the lock hold time are recorded)
+func (pool *Manager) acquireLock(caller string) *poolLock {
+	pool.logger.Debug("Trying to get the pool lock", "caller", caller)
+
+	started := time.Now()
 	lock := pool.store.GetLock()
 	lockCh, err := lock.Lock(nil)
 	if err != nil {
@@ -216,83 +538,314 @@ func (pool *Manager) Allocate(blockKey string, delayUnlock bool) *BlockInfo {
 		panic("did not lock")
 	}
 
-	if !delayUnlock {
-		defer lock.Unlock()
+	metrics.MeasureSince(pool.metricKey(metricLockWait), started)
+	pool.logger.Debug("Got the pool lock", "caller", caller)
+	return &poolLock{pool: pool, lock: lock, acquired: time.Now()}
+}
+
+// nextBlockFromRange selects and reserves the next IP Block to allocate using the pool allocation strategy
+// (the size is the number of addresses in the block: the pool block size or a larger power of two)
+func (pool *Manager) nextBlockFromRange(size *big.Int) (string, error) {
+	selected, err := pool.selectBlock(size, true)
+	if err != nil {
+		return "", err
+	}
+
+	pool.reserveBlock(selected, size)
+	return selected.String(), nil
+}
+
+// selectBlock selects the next IP Block to allocate without changing the pool metadata
+// (if the growth is allowed the pool may grow first, which saves its new range)
+func (pool *Manager) selectBlock(size *big.Int, growth bool) (net.IP, error) {
+	pool.logger.Debug("Selecting the next block", "next", pool.nextBlock.String())
+	//NOTE: nextBlock needs to be fresh when selectBlock is called
+	if growth {
+		pool.growOnThreshold()
+	}
+
+	selected := pool.strategy.Select(pool.allocationView(size))
+	if selected == nil && growth && pool.growth != nil && pool.grow("exhausted") == nil {
+		selected = pool.strategy.Select(pool.allocationView(size))
+	}
+
+	if selected == nil {
+		return nil, ErrPoolExhausted
+	}
+
+	return selected, nil
+}
+
+// reserveBlock updates the pool metadata for the selected IP Block (the caller saves the block)
+func (pool *Manager) reserveBlock(selected net.IP, size *big.Int) {
+	//NOTE: info needs to be fresh when reserveBlock is called
+	pool.advanceNext(selected, size)
+
+	//the expired quarantine record is not needed anymore
+	if pool.quarantinePeriod > 0 && pool.store.GetQuarantine(selected.String()) != nil {
+		pool.store.RemoveQuarantine(selected.String())
 	}
+}
 
-	fmt.Println("Pool.Allocate - Got the pool lock...")
+// advanceNext moves the Next boundary past the allocated addresses
+// (Next stays the allocation boundary: nothing is allocated at or after it)
+func (pool *Manager) advanceNext(start net.IP, size *big.Int) {
+	end := newExtent(ipToInt(start), size).end
+	if pool.nextBlock == nil || end.Cmp(ipToInt(pool.nextBlock)) >= 0 {
+		pool.nextBlock = intToIP(end.Add(end, big.NewInt(1)), isIPv6(start))
+		pool.info.Next = pool.nextBlock.String()
+		pool.store.SavePool(pool.info)
+
+		pool.logger.Debug("Updated the next block", "next", pool.info.Next)
+	}
+}
+
+// Lookup returns the IP Block metadata by the IP Block start address
+// or the Block Key or nil if the IP Block is not allocated yet
+func (pool *Manager) Lookup(ipBlock, blockKey string) *BlockInfo {
+	if ipBlock != "" {
+		return pool.getBlock(ipBlock)
+	} else if blockKey != "" {
+		return pool.store.FindBlock(pool.namespace, blockKey)
+	}
+
+	return nil
+}
+
+// Allocate returns the newly allocated IP Block or an existing IP Block
+// if the provided Block Key matches an existing IP Block allocation
+func (pool *Manager) Allocate(blockKey string, delayUnlock bool) (*BlockInfo, error) {
+	return pool.AllocateWith(&AllocationRequest{Key: blockKey, DelayUnlock: delayUnlock})
+}
+
+// AllocateWith allocates an IP Block using the provided allocation request
+// (the new allocations are limited by the tenant quota).
+// Only the new allocations return the holder token (an existing allocation is returned without it).
+func (pool *Manager) AllocateWith(req *AllocationRequest) (*BlockInfo, error) {
+	blockInfo, err := pool.allocate(req)
+	if err != nil || blockInfo.HolderToken != "" {
+		//only the new allocations are counted (they are the only ones with the holder token)
+		pool.countResult("allocate", metricAllocations, err)
+	}
+
+	return blockInfo, err
+}
+
+func (pool *Manager) allocate(req *AllocationRequest) (*BlockInfo, error) {
+	blockKey := req.Key
+	delayUnlock := req.DelayUnlock
+	tenant := TenantOf(req.Key, req.Tenant)
+
+	if !ValidLabels(req.Labels) {
+		return nil, ErrInvalidLabels
+	}
+
+	lock := pool.acquireLock("Pool.Allocate")
+
+	//NOTE: the lock release is delayed only for new allocations
+	unlock := true
+	defer func() {
+		if unlock {
+			lock.Unlock()
+		}
+	}()
 
 	if blockKey != "" {
-		if blockInfo := pool.store.FindBlock(blockKey); blockInfo != nil {
-			fmt.Println("Pool.Allocate - Already allocated... Returning existing record")
-			return blockInfo
+		if blockInfo := pool.store.FindBlock(pool.namespace, blockKey); blockInfo != nil {
+			pool.logger.Debug("Block is already allocated", "key", blockKey, "block", blockInfo.Start)
+			return blockInfo, nil
 		}
 	}
 
-	//TODO - refresh pool info here
-	blockStart := pool.nextBlockFromRange()
-	fmt.Println("Pool.Allocate - Allocated IP block =>", blockStart)
+	blockInfo, err := pool.newBlock(blockKey, tenant)
+	if err != nil {
+		return nil, err
+	}
 
-	blockInfo := NewBlockInfo(blockStart, blockKey)
+	blockInfo.Labels = req.Labels
+	blockInfo.Description = req.Description
+	blockInfo.Owner = req.Owner
+	blockInfo.HolderToken, blockInfo.HolderHash = newHolderToken()
 	pool.store.SaveBlock(blockInfo)
+	pool.logger.Info("Allocated IP block", "block", blockInfo.Start, "key", blockKey, "tenant", tenant)
 
 	if delayUnlock {
+		unlock = false
 		go func() {
-			fmt.Println("Pool.Allocate - Keeping the lock for 15 seconds to demo concurrent IP block allocation...")
+			pool.logger.Info("Keeping the lock for 15 seconds to demo concurrent IP block allocation")
 			time.Sleep(15 * time.Second)
-			fmt.Println("Pool.Allocate - delayed lock release...")
+			pool.logger.Info("Delayed lock release")
 			lock.Unlock()
 		}()
 	}
 
-	return blockInfo
+	return blockInfo, nil
 }
 
-// Free releases the selected IP Block allocation 
-// based on the provided IP Block starting address or its Block Key
-func (pool *Manager) Free(ipBlock, blockKey string) error {
-	fmt.Println("Pool.Free - Trying to get the pool lock...")
-	lock := pool.store.GetLock()
-	lockCh, err := lock.Lock(nil)
+// newBlock selects and reserves the next IP Block for the tenant allocation (the caller saves it)
+// (must be called with the pool lock held)
+func (pool *Manager) newBlock(blockKey, tenant string) (*BlockInfo, error) {
+	selection, err := pool.selectNewBlock(blockKey, tenant, true)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	if lockCh == nil {
-		panic("did not lock")
+
+	return selection.reserve(), nil
+}
+
+// blockSelection is a new IP Block selected in the loaded pool
+// (the pool metadata doesn't change until the selection is reserved)
+type blockSelection struct {
+	pool  *Manager
+	start net.IP
+	block *BlockInfo
+}
+
+// selectNewBlock selects the next IP Block for the tenant allocation
+// (must be called with the pool lock held)
+func (pool *Manager) selectNewBlock(blockKey, tenant string, growth bool) (*blockSelection, error) {
+	pool, err := pool.load()
+	if err != nil {
+		return nil, err
 	}
-	defer lock.Unlock()
 
-	fmt.Println("Pool.Free - Got the pool lock...")
+	if err := pool.checkQuota(tenant, 1, pool.blockSize()); err != nil {
+		pool.logger.Warn("Tenant quota exceeded", "tenant", tenant)
+		return nil, err
+	}
+
+	selected, err := pool.selectBlock(pool.blockSize(), growth)
+	if err != nil {
+		return nil, err
+	}
+
+	blockInfo := NewBlockInfo(selected.String(), blockKey)
+	blockInfo.Tenant = tenant
+	blockInfo.Namespace = pool.namespace
+	return &blockSelection{pool: pool, start: selected, block: blockInfo}, nil
+}
+
+// reserve updates the pool metadata for the selected IP Block and returns the new block (the caller saves it)
+func (s *blockSelection) reserve() *BlockInfo {
+	s.pool.reserveBlock(s.start, s.pool.blockSize())
+	return s.block
+}
+
+// Free releases the selected IP Block allocation
+// based on the provided IP Block starting address or its Block Key
+func (pool *Manager) Free(ipBlock, blockKey string) error {
+	err := pool.free(ipBlock, blockKey)
+	pool.countResult("free", metricFrees, err)
+	return err
+}
 
+func (pool *Manager) free(ipBlock, blockKey string) error {
+	lock := pool.acquireLock("Pool.Free")
+	defer lock.Unlock()
+
+	var blockInfo *BlockInfo
 	if ipBlock != "" {
-		if blockInfo := pool.store.GetBlock(ipBlock); blockInfo != nil {
-			fmt.Println("Pool.Free - Found record by IP")
-			pool.store.RemoveBlock(ipBlock)
-			return nil
-		}
+		blockInfo = pool.getBlock(ipBlock)
 	} else if blockKey != "" {
-		if blockInfo := pool.store.FindBlock(blockKey); blockInfo != nil {
-			fmt.Println("Pool.Free - Found record by Key")
-			pool.store.RemoveBlock(blockInfo.Start)
-			return nil
-		}
+		blockInfo = pool.store.FindBlock(pool.namespace, blockKey)
+	}
+
+	if blockInfo == nil {
+		return ErrBlockNotFound
+	}
+
+	if err := standalone(blockInfo); err != nil {
+		return err
+	}
+
+	if err := pool.checkHolder(blockInfo); err != nil {
+		return err
+	}
+
+	pool.release(blockInfo)
+	pool.logger.Info("Freed IP block", "block", blockInfo.Start, "key", blockInfo.Key)
+	return nil
+}
+
+// standalone returns an error if the IP Block is managed together with other records
+// (and it can't be released or changed on its own)
+func standalone(blockInfo *BlockInfo) error {
+	if blockInfo.Pool != "" {
+		//the delegated blocks are released by deleting (or shrinking) their child pools
+		return ErrBlockDelegated
+	}
+
+	if blockInfo.PairBlock != "" {
+		//the paired blocks are released together (using the pool pair)
+		return ErrBlockPaired
+	}
+
+	if blockInfo.Group != "" {
+		//the grouped blocks are released together (using FreeGroup)
+		return ErrBlockGrouped
 	}
 
-	return ErrBlockNotFound
+	return nil
+}
+
+// release removes the IP Block allocation (and its addresses) and puts the block in quarantine
+// (must be called with the pool lock held)
+func (pool *Manager) release(blockInfo *BlockInfo) {
+	pool.store.RemoveAddresses(blockInfo.Start)
+	pool.store.RemoveBlock(blockInfo.Start)
+	pool.quarantineBlock(blockInfo)
 }
 
 // Store represents the Pool data store (Consul is used as the store backend)
 type Store struct {
 	consul *api.Client
 	kvAPI  *api.KV
+	pool   string
+	prefix string
+	logger logging.Logger
+}
+
+// ForPool returns the Store object for the selected pool
+// (the pools share the Store backend, but each pool has its own keyspace)
+func (s *Store) ForPool(name string) *Store {
+	store := *s
+	store.pool = name
+	store.prefix = defaultPoolKeyPrefix
+	if name != "" {
+		store.prefix = fmt.Sprintf("%s/%s", namedPoolsKeyPrefix, name)
+	}
+
+	return &store
+}
+
+// WithLogger returns the Store object that uses the logger
+func (s *Store) WithLogger(logger logging.Logger) *Store {
+	store := *s
+	store.logger = logger
+	return &store
+}
+
+// fail logs the Store backend error and panics
+func (s *Store) fail(op, key string, err error) {
+	s.logger.Error("Store operation failed", "op", op, "key", key, "error", err)
+	panic(err)
+}
+
+// Pool returns the name of the pool selected in the Store object ("" is the default pool)
+func (s *Store) Pool() string {
+	return s.pool
+}
+
+func (s *Store) key(parts ...string) string {
+	return strings.Join(append([]string{s.prefix}, parts...), "/")
 }
 
 // GetLock returns the data store lock object
 // You must explicitly acquired/lock the lock object to ensure exclusive access to the Store
 func (s *Store) GetLock() *api.Lock {
-	lock, err := s.consul.LockKey(poolLockKey)
+	lock, err := s.consul.LockKey(s.key(poolLockKey))
 	if err != nil {
-		panic(err)
+		s.fail("lock", s.key(poolLockKey), err)
 	}
 
 	return lock
@@ -300,55 +853,72 @@ func (s *Store) GetLock() *api.Lock {
 
 // GetRecord returns the selected record
 func (s *Store) GetRecord(key string) []byte {
+	if pair := s.getPair(key); pair != nil {
+		return pair.Value
+	}
+
+	return nil
+}
+
+func (s *Store) getPair(key string) *api.KVPair {
 	pair, _, err := s.kvAPI.Get(key, nil)
 	if err != nil {
-		panic(err)
+		s.fail("get", key, err)
 	}
 
 	if pair == nil || pair.Value == nil {
 		return nil
 	}
 
-	return pair.Value
+	return pair
+}
+
+// ListRecords returns the raw records selected by the key prefix
+func (s *Store) ListRecords(prefix string) api.KVPairs {
+	pairs, _, err := s.kvAPI.List(prefix, nil)
+	if err != nil {
+		s.fail("list", prefix, err)
+	}
+
+	return pairs
 }
 
 // SaveRecord saves the provided record in the Store
 func (s *Store) SaveRecord(key string, data []byte) {
 	pair := &api.KVPair{Key: key, Value: data}
 	if _, err := s.kvAPI.Put(pair, nil); err != nil {
-		panic(err)
+		s.fail("put", key, err)
 	}
 }
 
 // RemoveRecord removes the selected record from the Store
 func (s *Store) RemoveRecord(key string) {
 	if _, err := s.kvAPI.Delete(key, nil); err != nil {
-		panic(err)
+		s.fail("delete", key, err)
 	}
 }
 
-// FindBlock returns the BlockInfo object selected by IP Block Key
-func (s *Store) FindBlock(key string) *BlockInfo {
-	//NOTE: this is a hacky way to find the record by key (good enough for a PoC :-))
-	if pairs, _, err := s.kvAPI.List(poolBlocksKeyPrefix, nil); err != nil {
-		panic(err)
-	} else if len(pairs) == 0 {
-		return nil
-	} else {
-		for _, p := range pairs {
-			var record map[string]string
-			if err := json.Unmarshal(p.Value, &record); err != nil {
-				panic(err)
-			}
+// ListBlocks returns all IP Block records
+func (s *Store) ListBlocks() []*BlockInfo {
+	pairs, _, err := s.kvAPI.List(s.key(poolBlocksKeyPrefix)+"/", nil)
+	if err != nil {
+		s.fail("list", s.key(poolBlocksKeyPrefix), err)
+	}
 
-			var block BlockInfo
-			if err := json.Unmarshal(p.Value, &block); err != nil {
-				panic(err)
-			}
+	var blocks []*BlockInfo
+	for _, p := range pairs {
+		blocks = append(blocks, decodeBlock(p))
+	}
 
-			if block.Key == key {
-				return &block
-			}
+	return blocks
+}
+
+// FindBlock returns the BlockInfo object selected by IP Block Key in the selected namespace
+func (s *Store) FindBlock(namespace, key string) *BlockInfo {
+	//NOTE: this is a hacky way to find the record by key (good enough for a PoC :-))
+	for _, block := range s.ListBlocks() {
+		if block.Namespace == namespace && block.Key == key {
+			return block
 		}
 	}
 
@@ -357,37 +927,75 @@ func (s *Store) FindBlock(key string) *BlockInfo {
 
 // GetBlock returns the BlockInfo object selected by the IP Block starting address
 func (s *Store) GetBlock(blockStart string) *BlockInfo {
-	key := fmt.Sprintf("%s/%s", poolBlocksKeyPrefix, blockStart)
-	raw := s.GetRecord(key)
-	if raw == nil {
+	pair := s.getPair(s.key(poolBlocksKeyPrefix, blockStart))
+	if pair == nil {
 		return nil
 	}
 
-	var block BlockInfo
-	if err := json.Unmarshal(raw, &block); err != nil {
+	return decodeBlock(pair)
+}
+
+// blockRecord is the persisted block record (it keeps the holder token hash hidden in the BlockInfo output)
+type blockRecord struct {
+	BlockInfo
+	HolderHash string `json:"holder_hash,omitempty"`
+}
+
+// decodeBlock decodes the block record (the block version is the record ModifyIndex)
+func decodeBlock(pair *api.KVPair) *BlockInfo {
+	var record blockRecord
+	if err := json.Unmarshal(pair.Value, &record); err != nil {
 		panic(err)
 	}
 
+	block := record.BlockInfo
+	block.HolderHash = record.HolderHash
+	block.Version = pair.ModifyIndex
 	return &block
 }
 
-// SaveBlock saves the provided BlockInfo object
-func (s *Store) SaveBlock(block *BlockInfo) {
-	key := fmt.Sprintf("%s/%s", poolBlocksKeyPrefix, block.Start)
+func encodeBlock(block *BlockInfo) []byte {
+	record := blockRecord{BlockInfo: *block, HolderHash: block.HolderHash}
+	record.Version = 0
+	record.HolderToken = ""
 
 	buf := &bytes.Buffer{}
 	enc := json.NewEncoder(buf)
 	enc.SetEscapeHTML(false)
-	if err := enc.Encode(block); err != nil {
+	if err := enc.Encode(&record); err != nil {
 		panic(err)
 	}
 
-	s.SaveRecord(key, buf.Bytes())
+	return buf.Bytes()
+}
+
+// SaveBlock saves the provided BlockInfo object
+// (the block version is reset; the new version is known when the block is read again)
+func (s *Store) SaveBlock(block *BlockInfo) {
+	s.SaveRecord(s.key(poolBlocksKeyPrefix, block.Start), encodeBlock(block))
+	block.Version = 0
+}
+
+// SaveBlockCAS saves the provided BlockInfo object only if its record version is still the provided version
+// (false if the record was changed or removed)
+func (s *Store) SaveBlockCAS(block *BlockInfo, version uint64) bool {
+	pair := &api.KVPair{
+		Key:         s.key(poolBlocksKeyPrefix, block.Start),
+		Value:       encodeBlock(block),
+		ModifyIndex: version,
+	}
+
+	ok, _, err := s.kvAPI.CAS(pair, nil)
+	if err != nil {
+		s.fail("cas", pair.Key, err)
+	}
+
+	return ok
 }
 
 // RemoveBlock removes the BlockInfo object selected by the IP Block starting address
 func (s *Store) RemoveBlock(blockStart string) {
-	key := fmt.Sprintf("%s/%s", poolBlocksKeyPrefix, blockStart)
+	key := s.key(poolBlocksKeyPrefix, blockStart)
 	s.RemoveRecord(key)
 }
 
@@ -400,12 +1008,12 @@ func (s *Store) SavePool(pool *Info) {
 		panic(err)
 	}
 
-	s.SaveRecord(poolInfoKey, buf.Bytes())
+	s.SaveRecord(s.key(poolInfoKey), buf.Bytes())
 }
 
 // GetPool restores the Pool metadata from the Store backend
 func (s *Store) GetPool() *Info {
-	raw := s.GetRecord(poolInfoKey)
+	raw := s.GetRecord(s.key(poolInfoKey))
 	if raw == nil {
 		return nil
 	}
@@ -418,11 +1026,34 @@ func (s *Store) GetPool() *Info {
 	return &pool
 }
 
+// loadPool returns the pool metadata
+// (unlike GetPool it returns the errors, including the missing and the invalid pool info)
+func (s *Store) loadPool() (*Info, error) {
+	pair, _, err := s.kvAPI.Get(s.key(poolInfoKey), nil)
+	if err != nil {
+		s.logger.Error("Store operation failed", "op", "get", "key", s.key(poolInfoKey), "error", err)
+		return nil, err
+	}
+
+	if pair == nil || pair.Value == nil {
+		return nil, ErrPoolInfoMissing
+	}
+
+	var info Info
+	if err := json.Unmarshal(pair.Value, &info); err != nil {
+		return nil, ErrInvalidPoolInfo
+	}
+
+	if net.ParseIP(info.Start) == nil || net.ParseIP(info.End) == nil {
+		return nil, ErrInvalidPoolInfo
+	}
+
+	return &info, nil
+}
+
 // NewStore creates a new Store object based on the provided backend config
 func NewStore(config *api.Config) *Store {
-	fmt.Println("pool.NewStore...")
 	if config == nil {
-		fmt.Println("pool.NewStore: using the default Consul config...")
 		config = api.DefaultConfig()
 	}
 
@@ -434,6 +1065,8 @@ func NewStore(config *api.Config) *Store {
 	store := Store{
 		consul: client,
 		kvAPI:  client.KV(),
+		prefix: defaultPoolKeyPrefix,
+		logger: logging.Nop(),
 	}
 
 	return &store
@@ -450,11 +1083,41 @@ func NewStoreWithConfig(configInfo *StoreConfig) *Store {
 
 		if configInfo.Scheme != "" {
 			config.Scheme = configInfo.Scheme
+		} else if configInfo.tlsEnabled() {
+			config.Scheme = "https"
 		}
 
 		if configInfo.Datacenter != "" {
 			config.Datacenter = configInfo.Datacenter
 		}
+
+		if configInfo.Token != "" {
+			config.Token = configInfo.Token
+		} else if configInfo.TokenFile != "" {
+			raw, err := ioutil.ReadFile(configInfo.TokenFile)
+			if err != nil {
+				panic(err)
+			}
+
+			config.Token = strings.TrimSpace(string(raw))
+		}
+
+		if configInfo.CAFile != "" {
+			config.TLSConfig.CAFile = configInfo.CAFile
+		}
+
+		if configInfo.CertFile != "" {
+			config.TLSConfig.CertFile = configInfo.CertFile
+		}
+
+		if configInfo.KeyFile != "" {
+			config.TLSConfig.KeyFile = configInfo.KeyFile
+		}
+
+		if configInfo.TLSServerName != "" {
+			//the Consul API client uses the TLS address host as the server name
+			config.TLSConfig.Address = configInfo.TLSServerName
+		}
 	}
 
 	return NewStore(config)
@@ -0,0 +1,133 @@
+package pool
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"time"
+)
+
+const (
+	poolQuarantineKeyPrefix = "quarantine"
+)
+
+// QuarantineInfo contains the metadata for a freed IP Block
+// that can't be allocated again until the quarantine period is over
+type QuarantineInfo struct {
+	Start string `json:"start"`
+	Key   string `json:"key"`
+	//Size is the number of addresses in the block (0 means the pool block size)
+	Size  int64     `json:"size,omitempty"`
+	Freed time.Time `json:"freed"`
+	Until time.Time `json:"until"`
+}
+
+// NewQuarantineInfo creates a new IP Block Quarantine Info object
+func NewQuarantineInfo(block *BlockInfo, period time.Duration) *QuarantineInfo {
+	now := time.Now().UTC()
+	info := QuarantineInfo{
+		Start: block.Start,
+		Key:   block.Key,
+		Size:  block.Size,
+		Freed: now,
+		Until: now.Add(period),
+	}
+
+	return &info
+}
+
+// Active returns true if the quarantine period is not over yet
+func (q *QuarantineInfo) Active() bool {
+	return time.Now().Before(q.Until)
+}
+
+// quarantineBlock puts the freed IP Block in quarantine (if quarantine is enabled)
+func (pool *Manager) quarantineBlock(block *BlockInfo) {
+	if pool.quarantinePeriod <= 0 {
+		return
+	}
+
+	pool.logger.Debug("Quarantined IP block", "block", block.Start, "period", pool.quarantinePeriod)
+	pool.store.SaveQuarantine(NewQuarantineInfo(block, pool.quarantinePeriod))
+}
+
+// activeQuarantine returns the IP Blocks that are still in quarantine
+func (pool *Manager) activeQuarantine() []*QuarantineInfo {
+	var active []*QuarantineInfo
+	for _, q := range pool.store.ListQuarantine() {
+		if q.Active() {
+			active = append(active, q)
+		}
+	}
+
+	return active
+}
+
+// addressQuarantined returns the quarantine record if the IP address belongs to a quarantined IP Block
+func (pool *Manager) addressQuarantined(ip net.IP) *QuarantineInfo {
+	val := ipToInt(ip)
+	for _, q := range pool.activeQuarantine() {
+		start := net.ParseIP(q.Start)
+		if isIPv6(start) != isIPv6(ip) {
+			continue
+		}
+
+		if e := newExtent(ipToInt(start), pool.blockSizeOr(q.Size)); e.start.Cmp(val) <= 0 && e.end.Cmp(val) >= 0 {
+			return q
+		}
+	}
+
+	return nil
+}
+
+// ListQuarantine returns the quarantined IP Block records (including the expired ones)
+func (s *Store) ListQuarantine() []*QuarantineInfo {
+	pairs, _, err := s.kvAPI.List(s.key(poolQuarantineKeyPrefix)+"/", nil)
+	if err != nil {
+		panic(err)
+	}
+
+	var records []*QuarantineInfo
+	for _, p := range pairs {
+		var record QuarantineInfo
+		if err := json.Unmarshal(p.Value, &record); err != nil {
+			panic(err)
+		}
+
+		records = append(records, &record)
+	}
+
+	return records
+}
+
+// GetQuarantine returns the quarantine record selected by the IP Block starting address
+func (s *Store) GetQuarantine(blockStart string) *QuarantineInfo {
+	raw := s.GetRecord(s.key(poolQuarantineKeyPrefix, blockStart))
+	if raw == nil {
+		return nil
+	}
+
+	var record QuarantineInfo
+	if err := json.Unmarshal(raw, &record); err != nil {
+		panic(err)
+	}
+
+	return &record
+}
+
+// SaveQuarantine saves the provided quarantine record
+func (s *Store) SaveQuarantine(record *QuarantineInfo) {
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(record); err != nil {
+		panic(err)
+	}
+
+	s.SaveRecord(s.key(poolQuarantineKeyPrefix, record.Start), buf.Bytes())
+}
+
+// RemoveQuarantine removes the quarantine record selected by the IP Block starting address
+func (s *Store) RemoveQuarantine(blockStart string) {
+	s.RemoveRecord(s.key(poolQuarantineKeyPrefix, blockStart))
+}
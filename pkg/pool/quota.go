@@ -0,0 +1,221 @@
+package pool
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+const (
+	poolQuotasKeyPrefix = "quotas"
+	//tenantKeySeparator separates the tenant name from the rest of the block key ("tenant:key")
+	tenantKeySeparator = ":"
+)
+
+// Quota errors
+var (
+	//
+	ErrQuotaExceeded = errors.New("Tenant quota exceeded")
+	//
+	ErrInvalidQuota = errors.New("Invalid tenant quota")
+	//
+	ErrQuotaNotFound = errors.New("Tenant quota not found")
+)
+
+// Quota contains the tenant allocation limits (0 means no limit)
+type Quota struct {
+	Tenant       string `json:"tenant"`
+	MaxBlocks    int64  `json:"max_blocks"`
+	MaxAddresses int64  `json:"max_addresses"`
+}
+
+// QuotaUsage contains the tenant quota and its current usage
+// (the number of addresses doesn't fit in int64 for the IPv6 blocks, e.g., /64 blocks)
+type QuotaUsage struct {
+	Quota
+	Blocks    int64    `json:"blocks"`
+	Addresses *big.Int `json:"addresses"`
+}
+
+// newQuotaUsage returns the quota usage without any allocations
+func newQuotaUsage(quota *Quota) *QuotaUsage {
+	return &QuotaUsage{Quota: *quota, Addresses: big.NewInt(0)}
+}
+
+// TenantOf returns the tenant for the block key: the explicit tenant
+// or the key prefix before the tenant separator ("acme:vm-1" belongs to "acme")
+func TenantOf(blockKey, tenant string) string {
+	if tenant != "" {
+		return tenant
+	}
+
+	if parts := strings.SplitN(blockKey, tenantKeySeparator, 2); len(parts) == 2 {
+		return parts[0]
+	}
+
+	return ""
+}
+
+// checkQuota returns ErrQuotaExceeded if allocating the blocks (with the total number of addresses)
+// would exceed the tenant quota (must be called with the pool lock held)
+func (pool *Manager) checkQuota(tenant string, blocks int64, size *big.Int) error {
+	if tenant == "" {
+		return nil
+	}
+
+	quota := pool.store.GetQuota(tenant)
+	if quota == nil {
+		return nil
+	}
+
+	usage := pool.quotaUsage(quota)
+	if quota.MaxBlocks > 0 && usage.Blocks+blocks > quota.MaxBlocks {
+		return ErrQuotaExceeded
+	}
+
+	addresses := big.NewInt(0).Add(usage.Addresses, size)
+	if quota.MaxAddresses > 0 && addresses.Cmp(big.NewInt(quota.MaxAddresses)) > 0 {
+		return ErrQuotaExceeded
+	}
+
+	return nil
+}
+
+// quotaUsage counts the IP Blocks (and their addresses) allocated by the tenant
+func (pool *Manager) quotaUsage(quota *Quota) *QuotaUsage {
+	usage := newQuotaUsage(quota)
+	for _, block := range pool.store.ListBlocks() {
+		if block.Tenant == quota.Tenant {
+			usage.Blocks++
+			usage.Addresses.Add(usage.Addresses, pool.sizeOf(block))
+		}
+	}
+
+	return usage
+}
+
+// SetQuota creates or updates the tenant quota
+func (pool *Manager) SetQuota(quota *Quota) error {
+	if quota == nil || quota.Tenant == "" || strings.Contains(quota.Tenant, "/") ||
+		quota.MaxBlocks < 0 || quota.MaxAddresses < 0 {
+		return ErrInvalidQuota
+	}
+
+	pool.store.SaveQuota(quota)
+	pool.logger.Info("Set tenant quota", "tenant", quota.Tenant, "max_blocks", quota.MaxBlocks, "max_addresses", quota.MaxAddresses)
+	return nil
+}
+
+// RemoveQuota removes the tenant quota (the tenant allocations are not limited anymore)
+func (pool *Manager) RemoveQuota(tenant string) error {
+	if pool.store.GetQuota(tenant) == nil {
+		return ErrQuotaNotFound
+	}
+
+	pool.store.RemoveQuota(tenant)
+	return nil
+}
+
+// Quota returns the tenant quota usage (the tenant may have no quota)
+func (pool *Manager) Quota(tenant string) (*QuotaUsage, error) {
+	pool, err := pool.load()
+	if err != nil {
+		return nil, err
+	}
+
+	quota := pool.store.GetQuota(tenant)
+	if quota == nil {
+		quota = &Quota{Tenant: tenant}
+	}
+
+	return pool.quotaUsage(quota), nil
+}
+
+// Quotas returns the quota usage report for all tenants (with a quota or with allocated blocks)
+func (pool *Manager) Quotas() ([]*QuotaUsage, error) {
+	pool, err := pool.load()
+	if err != nil {
+		return nil, err
+	}
+
+	usage := map[string]*QuotaUsage{}
+	for _, quota := range pool.store.ListQuotas() {
+		usage[quota.Tenant] = newQuotaUsage(quota)
+	}
+
+	for _, block := range pool.store.ListBlocks() {
+		if block.Tenant == "" {
+			continue
+		}
+
+		u, ok := usage[block.Tenant]
+		if !ok {
+			u = newQuotaUsage(&Quota{Tenant: block.Tenant})
+			usage[block.Tenant] = u
+		}
+
+		u.Blocks++
+		u.Addresses.Add(u.Addresses, pool.sizeOf(block))
+	}
+
+	var report []*QuotaUsage
+	for _, u := range usage {
+		report = append(report, u)
+	}
+
+	sort.Slice(report, func(i, j int) bool {
+		return report[i].Tenant < report[j].Tenant
+	})
+
+	return report, nil
+}
+
+// ListQuotas returns the tenant quotas
+func (s *Store) ListQuotas() []*Quota {
+	var quotas []*Quota
+	for _, p := range s.ListRecords(s.key(poolQuotasKeyPrefix) + "/") {
+		var quota Quota
+		if err := json.Unmarshal(p.Value, &quota); err != nil {
+			panic(err)
+		}
+
+		quotas = append(quotas, &quota)
+	}
+
+	return quotas
+}
+
+// GetQuota returns the quota selected by the tenant name
+func (s *Store) GetQuota(tenant string) *Quota {
+	raw := s.GetRecord(s.key(poolQuotasKeyPrefix, tenant))
+	if raw == nil {
+		return nil
+	}
+
+	var quota Quota
+	if err := json.Unmarshal(raw, &quota); err != nil {
+		panic(err)
+	}
+
+	return &quota
+}
+
+// SaveQuota saves the provided tenant quota
+func (s *Store) SaveQuota(quota *Quota) {
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(quota); err != nil {
+		panic(err)
+	}
+
+	s.SaveRecord(s.key(poolQuotasKeyPrefix, quota.Tenant), buf.Bytes())
+}
+
+// RemoveQuota removes the quota selected by the tenant name
+func (s *Store) RemoveQuota(tenant string) {
+	s.RemoveRecord(s.key(poolQuotasKeyPrefix, tenant))
+}
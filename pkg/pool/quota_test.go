@@ -0,0 +1,132 @@
+package pool
+
+import (
+	"fmt"
+	"math/big"
+	"testing"
+)
+
+func TestQuotaIPv6Addresses(t *testing.T) {
+	pool := New(&Config{Name: "ipv6", StartRange: "fd00::", EndRange: "fd00:0:0:ff:ffff:ffff:ffff:ffff", BlockPrefix: 64}, newTestStore(t))
+
+	//2^64 addresses don't fit in int64: one /64 block is over any address quota
+	if err := pool.SetQuota(&Quota{Tenant: "acme", MaxAddresses: 1 << 40}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := pool.Allocate("acme:vm-1", false); err != ErrQuotaExceeded {
+		t.Fatalf("Allocate() error = %v, want %v", err, ErrQuotaExceeded)
+	}
+
+	if err := pool.SetQuota(&Quota{Tenant: "acme", MaxBlocks: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"acme:vm-1", "acme:vm-2"} {
+		if _, err := pool.Allocate(key, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	usage, err := pool.Quota("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := big.NewInt(0).Lsh(big.NewInt(1), 65)
+	if usage.Blocks != 2 || usage.Addresses.Cmp(want) != 0 {
+		t.Errorf("Quota() = %d blocks, %s addresses, want 2 blocks, %s addresses", usage.Blocks, usage.Addresses, want)
+	}
+}
+
+func TestTenantOf(t *testing.T) {
+	for _, test := range []struct {
+		key, tenant, want string
+	}{
+		{key: "acme:vm-1", want: "acme"},
+		{key: "acme:vm-1", tenant: "globex", want: "globex"},
+		{key: "vm-1", want: ""},
+		{key: "acme:web:vm-1", want: "acme"},
+	} {
+		if got := TenantOf(test.key, test.tenant); got != test.want {
+			t.Errorf("TenantOf(%q, %q) = %q, want %q", test.key, test.tenant, got, test.want)
+		}
+	}
+}
+
+func TestSetQuotaErrors(t *testing.T) {
+	pool := New(&Config{StartRange: "169.254.60.0", EndRange: "169.254.60.15", PoolBlockSize: 4}, newTestStore(t))
+	for _, quota := range []*Quota{
+		nil,
+		{MaxBlocks: 1},
+		{Tenant: "acme/web", MaxBlocks: 1},
+		{Tenant: "acme", MaxBlocks: -1},
+		{Tenant: "acme", MaxAddresses: -1},
+	} {
+		if err := pool.SetQuota(quota); err != ErrInvalidQuota {
+			t.Errorf("SetQuota(%+v) error = %v, want %v", quota, err, ErrInvalidQuota)
+		}
+	}
+
+	if err := pool.RemoveQuota("acme"); err != ErrQuotaNotFound {
+		t.Errorf("RemoveQuota() of a tenant without a quota error = %v, want %v", err, ErrQuotaNotFound)
+	}
+}
+
+func TestQuotaBlocks(t *testing.T) {
+	pool := New(&Config{StartRange: "169.254.60.0", EndRange: "169.254.60.63", PoolBlockSize: 4}, newTestStore(t))
+	if err := pool.SetQuota(&Quota{Tenant: "acme", MaxBlocks: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	//the key prefix and the explicit tenant count against the same quota
+	if _, err := pool.Allocate("acme:vm-1", false); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := pool.AllocateWith(&AllocationRequest{Key: "vm-2", Tenant: "acme"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := pool.Allocate("acme:vm-3", false); err != ErrQuotaExceeded {
+		t.Fatalf("Allocate() over the quota error = %v, want %v", err, ErrQuotaExceeded)
+	}
+
+	//the other tenants and the blocks without a tenant are not limited
+	for _, key := range []string{"globex:vm-1", "vm-4"} {
+		if _, err := pool.Allocate(key, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//the freed blocks don't count against the quota
+	if err := pool.WithHolderOverride().Free("", "acme:vm-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := pool.Allocate("acme:vm-3", false); err != nil {
+		t.Fatalf("Allocate() after the free error = %v", err)
+	}
+
+	report, err := pool.Quotas()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, usage := range report {
+		got = append(got, fmt.Sprintf("%s %d/%d %s", usage.Tenant, usage.Blocks, usage.MaxBlocks, usage.Addresses))
+	}
+
+	if want := []string{"acme 2/2 8", "globex 1/0 4"}; !equalStrings(got, want) {
+		t.Errorf("Quotas() = %v, want %v", got, want)
+	}
+
+	if err := pool.RemoveQuota("acme"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := pool.Allocate("acme:vm-5", false); err != nil {
+		t.Errorf("Allocate() without the quota error = %v", err)
+	}
+}
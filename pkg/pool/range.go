@@ -0,0 +1,331 @@
+package pool
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"sort"
+	"strings"
+)
+
+// Range errors
+var (
+	//
+	ErrInvalidRange = errors.New("Invalid IP range")
+)
+
+// ipRange is an inclusive numeric IP address range
+type ipRange struct {
+	start *big.Int
+	end   *big.Int
+	ipv6  bool
+}
+
+// parseIPRange parses a single IP address, a CIDR ("169.254.60.0/24")
+// or a start/end address pair ("169.254.60.0-169.254.60.255")
+func parseIPRange(value string) (*ipRange, error) {
+	value = strings.TrimSpace(value)
+
+	if strings.Contains(value, "/") {
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, ErrInvalidRange
+		}
+
+		ones, bits := network.Mask.Size()
+		start := ipToInt(network.IP)
+		size := big.NewInt(0).Lsh(big.NewInt(1), uint(bits-ones))
+		end := big.NewInt(0).Add(start, size)
+		end.Sub(end, big.NewInt(1))
+
+		return &ipRange{start: start, end: end, ipv6: isIPv6(network.IP)}, nil
+	}
+
+	startValue, endValue := value, value
+	if parts := strings.SplitN(value, "-", 2); len(parts) == 2 {
+		startValue, endValue = strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+	}
+
+	return newIPRange(startValue, endValue)
+}
+
+// newIPRange creates a numeric IP range from the start and end IP addresses
+func newIPRange(startValue, endValue string) (*ipRange, error) {
+	startIP := net.ParseIP(startValue)
+	endIP := net.ParseIP(endValue)
+	if startIP == nil || endIP == nil || isIPv6(startIP) != isIPv6(endIP) {
+		return nil, ErrInvalidRange
+	}
+
+	r := ipRange{
+		start: ipToInt(startIP),
+		end:   ipToInt(endIP),
+		ipv6:  isIPv6(startIP),
+	}
+
+	if r.start.Cmp(r.end) > 0 {
+		return nil, ErrInvalidRange
+	}
+
+	return &r, nil
+}
+
+func (r *ipRange) contains(val *big.Int) bool {
+	return r.start.Cmp(val) <= 0 && r.end.Cmp(val) >= 0
+}
+
+func (r *ipRange) overlaps(start, end *big.Int) bool {
+	return r.start.Cmp(end) <= 0 && r.end.Cmp(start) >= 0
+}
+
+func (r *ipRange) size() *big.Int {
+	size := big.NewInt(0).Sub(r.end, r.start)
+	return size.Add(size, big.NewInt(1))
+}
+
+func (r *ipRange) String() string {
+	return fmt.Sprintf("%s-%s", intToIP(r.start, r.ipv6), intToIP(r.end, r.ipv6))
+}
+
+// findRange returns the range that contains the address (or nil if no range contains it)
+func findRange(ranges []*ipRange, val *big.Int) *ipRange {
+	for _, r := range ranges {
+		if r.contains(val) {
+			return r
+		}
+	}
+
+	return nil
+}
+
+// sortRanges orders the pool ranges by their start address
+// and validates that they don't overlap and belong to the same IP address family
+func sortRanges(ranges []*ipRange) ([]*ipRange, error) {
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].start.Cmp(ranges[j].start) < 0
+	})
+
+	for i := 1; i < len(ranges); i++ {
+		if ranges[i].ipv6 != ranges[0].ipv6 {
+			return nil, ErrInvalidRange
+		}
+
+		if ranges[i-1].end.Cmp(ranges[i].start) >= 0 {
+			return nil, ErrRangeOverlap
+		}
+	}
+
+	return ranges, nil
+}
+
+// parseIPRanges parses a list of IP ranges (see parseIPRange for the supported formats)
+func parseIPRanges(values []string) ([]*ipRange, error) {
+	var ranges []*ipRange
+	for _, value := range values {
+		r, err := parseIPRange(value)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %s", err, value)
+		}
+
+		ranges = append(ranges, r)
+	}
+
+	return ranges, nil
+}
+
+// Range operations
+const (
+	RangeOpExtend = "extend"
+	RangeOpAdd    = "add"
+	RangeOpShrink = "shrink"
+)
+
+// Range operation errors
+var (
+	//
+	ErrRangeOverlap = errors.New("IP range overlaps an existing pool range")
+	//
+	ErrRangeInUse = errors.New("IP range has allocated blocks")
+)
+
+// RangeChange describes the result (or the preview in the dry-run mode) of a pool range operation
+type RangeChange struct {
+	Operation     string   `json:"operation"`
+	DryRun        bool     `json:"dry_run"`
+	Before        []*Range `json:"before"`
+	After         []*Range `json:"after"`
+	Next          string   `json:"next"`
+	AddedBlocks   int64    `json:"added_blocks"`
+	RemovedBlocks int64    `json:"removed_blocks"`
+}
+
+// ExtendRange moves the end of the (last) pool range to the provided IP address
+func (pool *Manager) ExtendRange(end string, dryRun bool) (*RangeChange, error) {
+	return pool.changeRanges(RangeOpExtend, dryRun, false, func(_ *Manager, ranges []*ipRange) ([]*ipRange, error) {
+		last := ranges[len(ranges)-1]
+		endIP := net.ParseIP(end)
+		if endIP == nil || isIPv6(endIP) != last.ipv6 {
+			return nil, ErrInvalidRange
+		}
+
+		newEnd := ipToInt(endIP)
+		if newEnd.Cmp(last.end) <= 0 {
+			return nil, ErrInvalidRange
+		}
+
+		ranges[len(ranges)-1] = &ipRange{start: last.start, end: newEnd, ipv6: last.ipv6}
+		return ranges, nil
+	})
+}
+
+// AddRange adds a new (non-overlapping) address range to the pool
+// (if end is empty start can be a CIDR or any other format supported in Config.Ranges)
+func (pool *Manager) AddRange(start, end string, dryRun bool) (*RangeChange, error) {
+	return pool.changeRanges(RangeOpAdd, dryRun, false, func(_ *Manager, ranges []*ipRange) ([]*ipRange, error) {
+		var rng *ipRange
+		var err error
+		if end == "" {
+			rng, err = parseIPRange(start)
+		} else {
+			rng, err = newIPRange(start, end)
+		}
+
+		if err != nil {
+			return nil, ErrInvalidRange
+		}
+
+		return sortRanges(append(ranges, rng))
+	})
+}
+
+// ShrinkRange moves the end of the (last) pool range back to the provided IP address
+// (the removed tail of the range must be unallocated)
+func (pool *Manager) ShrinkRange(end string, dryRun bool) (*RangeChange, error) {
+	return pool.shrinkRange(end, dryRun, false)
+}
+
+func (pool *Manager) shrinkRange(end string, dryRun, byParent bool) (*RangeChange, error) {
+	return pool.changeRanges(RangeOpShrink, dryRun, byParent, func(loaded *Manager, ranges []*ipRange) ([]*ipRange, error) {
+		last := ranges[len(ranges)-1]
+		endIP := net.ParseIP(end)
+		if endIP == nil || isIPv6(endIP) != last.ipv6 {
+			return nil, ErrInvalidRange
+		}
+
+		newEnd := ipToInt(endIP)
+		if newEnd.Cmp(last.start) < 0 || newEnd.Cmp(last.end) >= 0 {
+			return nil, ErrInvalidRange
+		}
+
+		tail := extent{start: big.NewInt(0).Add(newEnd, big.NewInt(1)), end: last.end}
+		//the layout comes from the pool metadata loaded under the lock (the allocations can't change meanwhile)
+		for _, l := range loaded.layout() {
+			var used []extent
+			used = append(used, l.allocated...)
+			used = append(used, l.quarantined...)
+
+			for _, e := range used {
+				if e.start.Cmp(tail.end) <= 0 && e.end.Cmp(tail.start) >= 0 {
+					return nil, ErrRangeInUse
+				}
+			}
+		}
+
+		ranges[len(ranges)-1] = &ipRange{start: last.start, end: newEnd, ipv6: last.ipv6}
+		return ranges, nil
+	})
+}
+
+// changeRanges validates and applies the pool range change under the pool lock
+// (the change gets the pool manager with the current pool metadata;
+// the child pool ranges can only be changed by the parent pool)
+func (pool *Manager) changeRanges(op string,
+	dryRun bool,
+	byParent bool,
+	change func(loaded *Manager, ranges []*ipRange) ([]*ipRange, error)) (*RangeChange, error) {
+	lock := pool.acquireLock("Pool.changeRanges(" + op + ")")
+	defer lock.Unlock()
+
+	pool, err := pool.load()
+	if err != nil {
+		return nil, err
+	}
+
+	if pool.info.ParentBlock != "" && !byParent {
+		return nil, ErrChildPoolRange
+	}
+
+	before := pool.ranges()
+
+	after, err := change(pool, pool.ranges())
+	if err != nil {
+		return nil, err
+	}
+
+	result := &RangeChange{
+		Operation: op,
+		DryRun:    dryRun,
+		Before:    toRanges(before),
+		After:     toRanges(after),
+		Next:      pool.info.Next,
+	}
+
+	//Next can't point beyond the (new) end of the pool, so it's moved back
+	//to the first block boundary after the pool end (the pool stays exhausted until it grows again)
+	lastRange := after[len(after)-1]
+	if next := ipToInt(pool.nextBlock); next.Cmp(lastRange.end) > 0 {
+		aligned := alignUp(big.NewInt(0).Add(lastRange.end, big.NewInt(1)), lastRange.start, pool.blockSize())
+		result.Next = intToIP(aligned, lastRange.ipv6).String()
+	}
+
+	diff := big.NewInt(0).Sub(totalBlocks(after, pool.blockSize()), totalBlocks(before, pool.blockSize()))
+	if diff.Sign() > 0 {
+		result.AddedBlocks = diff.Int64()
+	} else {
+		result.RemovedBlocks = diff.Neg(diff).Int64()
+	}
+
+	if dryRun {
+		return result, nil
+	}
+
+	pool.info.SetRanges(result.After)
+	pool.info.Next = result.Next
+	pool.store.SavePool(pool.info)
+
+	pool.logger.Info("Updated pool ranges", "op", op, "start", pool.info.Start, "end", pool.info.End,
+		"ranges", len(result.After))
+	return result, nil
+}
+
+func totalBlocks(ranges []*ipRange, blockSize *big.Int) *big.Int {
+	total := big.NewInt(0)
+	for _, r := range ranges {
+		total.Add(total, big.NewInt(0).Div(r.size(), blockSize))
+	}
+
+	return total
+}
+
+func toRanges(ranges []*ipRange) []*Range {
+	var result []*Range
+	for _, r := range ranges {
+		result = append(result, &Range{
+			Start: intToIP(r.start, r.ipv6).String(),
+			End:   intToIP(r.end, r.ipv6).String(),
+		})
+	}
+
+	return result
+}
+
+// Ranges returns the current pool address ranges
+func (pool *Manager) Ranges() ([]*Range, error) {
+	pool, err := pool.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return pool.info.RangeList(), nil
+}
@@ -0,0 +1,157 @@
+package pool
+
+import (
+	"math/big"
+	"net"
+	"testing"
+)
+
+func TestParseIPRanges(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  []string
+		want    []string
+		wantErr bool
+	}{
+		{name: "single address", values: []string{"169.254.60.7"}, want: []string{"169.254.60.7-169.254.60.7"}},
+		{name: "cidr", values: []string{"169.254.60.0/24"}, want: []string{"169.254.60.0-169.254.60.255"}},
+		{name: "cidr host bits", values: []string{"169.254.60.9/30"}, want: []string{"169.254.60.8-169.254.60.11"}},
+		{name: "start-end", values: []string{" 169.254.60.0 - 169.254.60.9 "}, want: []string{"169.254.60.0-169.254.60.9"}},
+		{name: "ipv6 cidr", values: []string{"fd00::/126"}, want: []string{"fd00::-fd00::3"}},
+		{
+			name:   "list",
+			values: []string{"169.254.60.0/31", "169.254.61.1"},
+			want:   []string{"169.254.60.0-169.254.60.1", "169.254.61.1-169.254.61.1"},
+		},
+		{name: "empty list"},
+		{name: "invalid address", values: []string{"169.254.60.256"}, wantErr: true},
+		{name: "invalid cidr", values: []string{"169.254.60.0/33"}, wantErr: true},
+		{name: "reversed range", values: []string{"169.254.60.9-169.254.60.0"}, wantErr: true},
+		{name: "mixed families", values: []string{"169.254.60.0-fd00::1"}, wantErr: true},
+		{name: "one invalid value", values: []string{"169.254.60.0/24", "bad"}, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ranges, err := parseIPRanges(test.values)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("parseIPRanges(%v) = %v, want an error", test.values, ranges)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("parseIPRanges(%v) error = %v", test.values, err)
+			}
+
+			if len(ranges) != len(test.want) {
+				t.Fatalf("parseIPRanges(%v) = %v, want %v", test.values, ranges, test.want)
+			}
+
+			for i, r := range ranges {
+				if r.String() != test.want[i] {
+					t.Errorf("range %d = %s, want %s", i, r, test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestIPRange(t *testing.T) {
+	r, err := newIPRange("169.254.60.4", "169.254.60.11")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if size := r.size().Int64(); size != 8 {
+		t.Errorf("size() = %d, want 8", size)
+	}
+
+	for _, test := range []struct {
+		address string
+		want    bool
+	}{
+		{"169.254.60.3", false},
+		{"169.254.60.4", true},
+		{"169.254.60.11", true},
+		{"169.254.60.12", false},
+	} {
+		if got := r.contains(ipValue(t, test.address)); got != test.want {
+			t.Errorf("contains(%s) = %v, want %v", test.address, got, test.want)
+		}
+	}
+
+	if !r.overlaps(ipValue(t, "169.254.60.0"), ipValue(t, "169.254.60.4")) {
+		t.Error("overlaps() = false for a range that ends at the range start")
+	}
+
+	if r.overlaps(ipValue(t, "169.254.60.12"), ipValue(t, "169.254.60.20")) {
+		t.Error("overlaps() = true for a range after the range end")
+	}
+}
+
+func TestSortRanges(t *testing.T) {
+	ranges := mustParseRanges(t, "169.254.62.0/24", "169.254.60.0/24")
+	sorted, err := sortRanges(ranges)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sorted[0].String() != "169.254.60.0-169.254.60.255" {
+		t.Errorf("first range = %s, want 169.254.60.0-169.254.60.255", sorted[0])
+	}
+
+	if _, err := sortRanges(mustParseRanges(t, "169.254.60.0/24", "169.254.60.128/25")); err != ErrRangeOverlap {
+		t.Errorf("sortRanges(overlapping) error = %v, want %v", err, ErrRangeOverlap)
+	}
+
+	if _, err := sortRanges(mustParseRanges(t, "169.254.60.0/24", "fd00::/64")); err != ErrInvalidRange {
+		t.Errorf("sortRanges(mixed families) error = %v, want %v", err, ErrInvalidRange)
+	}
+}
+
+func mustParseRanges(t *testing.T, values ...string) []*ipRange {
+	ranges, err := parseIPRanges(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ranges
+}
+
+func ipValue(t *testing.T, address string) *big.Int {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		t.Fatalf("invalid test address: %s", address)
+	}
+
+	return ipToInt(ip)
+}
+
+func TestShrinkRangeInUse(t *testing.T) {
+	pool := New(&Config{StartRange: "169.254.60.0", EndRange: "169.254.60.15", PoolBlockSize: 4}, newTestStore(t))
+	if _, err := pool.ExtendRange("169.254.60.31", false); err != nil {
+		t.Fatal(err)
+	}
+
+	//the last block is in the extended part of the range
+	for _, key := range []string{"vm-1", "vm-2", "vm-3", "vm-4", "vm-5"} {
+		if _, err := pool.Allocate(key, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := pool.ShrinkRange("169.254.60.15", false); err != ErrRangeInUse {
+		t.Fatalf("ShrinkRange(169.254.60.15) error = %v, want %v", err, ErrRangeInUse)
+	}
+
+	change, err := pool.ShrinkRange("169.254.60.19", false)
+	if err != nil {
+		t.Fatalf("ShrinkRange(169.254.60.19) error = %v", err)
+	}
+
+	if change.RemovedBlocks != 3 || change.After[0].End != "169.254.60.19" {
+		t.Errorf("ShrinkRange(169.254.60.19) = %+v, want 3 removed blocks", change)
+	}
+}
@@ -0,0 +1,173 @@
+package pool
+
+import (
+	"errors"
+	"math/big"
+	"net"
+)
+
+// Resize errors
+var (
+	//
+	ErrGrowBlocked = errors.New("Block can't grow in place")
+)
+
+// GrowBlock resizes the IP Block to the next larger aligned prefix (double the size)
+// when the neighbouring blocks are free. The block keeps its start address, its key and its addresses.
+func (pool *Manager) GrowBlock(ipBlock, blockKey string) (*BlockInfo, error) {
+	lock := pool.acquireLock("Pool.GrowBlock")
+	defer lock.Unlock()
+
+	pool, err := pool.load()
+	if err != nil {
+		return nil, err
+	}
+
+	blockInfo := pool.Lookup(ipBlock, blockKey)
+	if blockInfo == nil {
+		return nil, ErrBlockNotFound
+	}
+
+	if err := standalone(blockInfo); err != nil {
+		return nil, err
+	}
+
+	if err := pool.checkHolder(blockInfo); err != nil {
+		return nil, err
+	}
+
+	size := pool.sizeOf(blockInfo)
+	newSize := big.NewInt(0).Lsh(size, 1)
+	if !newSize.IsInt64() {
+		return nil, ErrInvalidSize
+	}
+
+	start := net.ParseIP(blockInfo.Start)
+	if big.NewInt(0).Mod(ipToInt(start), newSize).Sign() != 0 {
+		//the larger prefix would start before the block
+		return nil, ErrGrowBlocked
+	}
+
+	buddy := newExtent(big.NewInt(0).Add(ipToInt(start), size), size)
+	if !pool.extentFree(buddy) {
+		return nil, ErrGrowBlocked
+	}
+
+	if err := pool.checkQuota(blockInfo.Tenant, 0, size); err != nil {
+		return nil, err
+	}
+
+	pool.removeQuarantine(buddy)
+	blockInfo.Size = newSize.Int64()
+	pool.store.SaveBlock(blockInfo)
+	pool.advanceNext(start, newSize)
+
+	pool.logger.Info("Grew IP block", "block", blockInfo.Start, "size", blockInfo.Size)
+	return blockInfo, nil
+}
+
+// SplitBlock splits the IP Block into smaller aligned blocks with the selected number of addresses.
+// The first block keeps the ID, the key and the start address of the original block.
+// The allocated addresses move to the blocks that contain them and the new blocks have the same holder.
+func (pool *Manager) SplitBlock(ipBlock, blockKey string, size int64) ([]*BlockInfo, error) {
+	lock := pool.acquireLock("Pool.SplitBlock")
+	defer lock.Unlock()
+
+	pool, err := pool.load()
+	if err != nil {
+		return nil, err
+	}
+
+	blockInfo := pool.Lookup(ipBlock, blockKey)
+	if blockInfo == nil {
+		return nil, ErrBlockNotFound
+	}
+
+	if err := standalone(blockInfo); err != nil {
+		return nil, err
+	}
+
+	if err := pool.checkHolder(blockInfo); err != nil {
+		return nil, err
+	}
+
+	partSize := big.NewInt(size)
+	blockSize := pool.sizeOf(blockInfo)
+	if !pool.validSize(size) || partSize.Cmp(blockSize) >= 0 ||
+		big.NewInt(0).Mod(blockSize, partSize).Sign() != 0 {
+		return nil, ErrInvalidSize
+	}
+
+	count := big.NewInt(0).Div(blockSize, partSize).Int64()
+	if err := pool.checkQuota(blockInfo.Tenant, count-1, big.NewInt(0)); err != nil {
+		return nil, err
+	}
+
+	start := net.ParseIP(blockInfo.Start)
+	parts := []*BlockInfo{blockInfo}
+	for i := int64(1); i < count; i++ {
+		part := NewBlockInfo(addToIP(start, big.NewInt(0).Mul(partSize, big.NewInt(i))).String(), "")
+		part.Tenant = blockInfo.Tenant
+		part.Namespace = blockInfo.Namespace
+		part.Owner = blockInfo.Owner
+		part.HolderHash = blockInfo.HolderHash
+		part.Labels = blockInfo.Labels
+		part.Description = blockInfo.Description
+		parts = append(parts, part)
+	}
+
+	for _, part := range parts {
+		part.Size = 0
+		if partSize.Cmp(pool.blockSize()) != 0 {
+			part.Size = size
+		}
+	}
+
+	//NOTE: the new blocks are saved before the original block shrinks,
+	//so an interrupted split never leaves the addresses unallocated
+	for _, part := range parts[1:] {
+		pool.store.SaveBlock(part)
+	}
+
+	for _, address := range pool.store.ListAddresses(blockInfo.Start) {
+		offset := big.NewInt(0).Sub(ipToInt(net.ParseIP(address.Address)), ipToInt(start))
+		idx := offset.Div(offset, partSize).Int64()
+		if idx <= 0 || idx >= count {
+			continue
+		}
+
+		moved := *address
+		moved.Block = parts[idx].Start
+		pool.store.SaveAddress(&moved)
+		pool.store.RemoveAddress(blockInfo.Start, address.Address)
+	}
+
+	pool.store.SaveBlock(blockInfo)
+
+	pool.logger.Info("Split IP block", "block", blockInfo.Start, "count", count)
+	return parts, nil
+}
+
+// extentFree returns true if all addresses in the extent are free
+// (allocatable and not allocated, excluded or quarantined)
+func (pool *Manager) extentFree(e extent) bool {
+	for _, l := range pool.layout() {
+		for _, run := range l.freeRuns() {
+			if run.start.Cmp(e.start) <= 0 && run.end.Cmp(e.end) >= 0 {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// removeQuarantine removes the (expired) quarantine records in the extent
+// (must be called with the pool lock held after the extent is checked to be free)
+func (pool *Manager) removeQuarantine(e extent) {
+	for _, q := range pool.store.ListQuarantine() {
+		if val := ipToInt(net.ParseIP(q.Start)); val.Cmp(e.start) >= 0 && val.Cmp(e.end) <= 0 {
+			pool.store.RemoveQuarantine(q.Start)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package pool
+
+import (
+	"testing"
+)
+
+func TestSplitBlock(t *testing.T) {
+	pool := New(&Config{StartRange: "169.254.60.0", EndRange: "169.254.60.63", PoolBlockSize: 4}, newTestStore(t))
+	block, err := pool.Allocate("vm-1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	holder := pool.WithHolder(block.HolderToken)
+	if _, err := holder.GrowBlock(block.Start, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	block = pool.Lookup(block.Start, "")
+	block.Labels = map[string]string{"env": "prod"}
+	block.Description = "web tier"
+	pool.store.SaveBlock(block)
+
+	parts, err := holder.SplitBlock(block.Start, "", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(parts) != 2 {
+		t.Fatalf("SplitBlock() = %d blocks, want 2", len(parts))
+	}
+
+	for _, part := range parts {
+		saved := pool.Lookup(part.Start, "")
+		if saved == nil {
+			t.Fatalf("Lookup(%s) = nil, want the split block", part.Start)
+		}
+
+		if saved.Labels["env"] != "prod" || saved.Description != "web tier" || saved.Owner != block.Owner {
+			t.Errorf("split block %s = labels %v, description %q, owner %q, want the original block values",
+				saved.Start, saved.Labels, saved.Description, saved.Owner)
+		}
+	}
+}
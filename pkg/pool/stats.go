@@ -0,0 +1,199 @@
+package pool
+
+import (
+	"math/big"
+	"strings"
+)
+
+// Stats contains the pool utilization statistics
+type Stats struct {
+	//Pool is the pool name (the default pool has no name)
+	Pool      string `json:"pool,omitempty"`
+	Range     string `json:"range"`
+	BlockSize int64  `json:"block_size"`
+	//BlockPrefix is the block prefix length (only for the pools with the prefix based block size)
+	BlockPrefix       int   `json:"block_prefix,omitempty"`
+	TotalBlocks       int64 `json:"total_blocks"`
+	AllocatedBlocks   int64 `json:"allocated_blocks"`
+	FreeBlocks        int64 `json:"free_blocks"`
+	QuarantinedBlocks int64 `json:"quarantined_blocks"`
+	ExcludedBlocks    int64 `json:"excluded_blocks"`
+	//Utilization is the ratio of the allocated blocks to the allocatable (non-excluded) blocks
+	Utilization float64 `json:"utilization"`
+	//Fragmentation is 0 when all free blocks are contiguous and approaches 1 as the free space gets scattered
+	Fragmentation float64 `json:"fragmentation"`
+	FreeRuns      int64   `json:"free_runs"`
+	LargestFree   int64   `json:"largest_free_run"`
+	//HighWaterMark is the last address of the highest allocated IP Block
+	HighWaterMark string `json:"high_water_mark,omitempty"`
+	//HighWaterMarkRatio is the position of the high-water mark relative to the end of the pool range
+	HighWaterMarkRatio float64 `json:"high_water_mark_ratio"`
+	Next               string  `json:"next,omitempty"`
+	//Exhausted is true when there are no free blocks left in any of the pool ranges
+	Exhausted bool `json:"exhausted"`
+	//RangeStats contains the per range breakdown (only for pools with multiple ranges)
+	RangeStats []*Stats `json:"range_stats,omitempty"`
+	//DelegatedBlocks is the number of allocated blocks delegated to the child pools
+	//(the delegated blocks are allocated blocks in the parent pool statistics, even if the child pools are empty)
+	DelegatedBlocks int64 `json:"delegated_blocks,omitempty"`
+	//AllocatedAddresses is the number of addresses in the blocks allocated in the pool (without the delegated blocks)
+	//and in its child pools: the child pool usage rolled up into the parent pool (only for the pools with child pools)
+	AllocatedAddresses *big.Int `json:"allocated_addresses,omitempty"`
+	//RollupUtilization is the ratio of the allocated addresses to the allocatable (non-excluded) addresses
+	//(only for the pools with child pools)
+	RollupUtilization float64 `json:"rollup_utilization,omitempty"`
+	//Children contains the child pool statistics
+	Children []*Stats `json:"children,omitempty"`
+}
+
+// Stats returns the pool utilization statistics
+func (pool *Manager) Stats() (*Stats, error) {
+	pool, err := pool.load()
+	if err != nil {
+		return nil, err
+	}
+
+	layouts := pool.layout()
+
+	stats := layoutStats(layouts)
+	stats.Pool = pool.name
+	stats.BlockPrefix = pool.blockPrefix
+	stats.Next = pool.info.Next
+
+	if len(layouts) > 1 {
+		for _, l := range layouts {
+			stats.RangeStats = append(stats.RangeStats, layoutStats([]*rangeLayout{l}))
+		}
+	}
+
+	delegated := big.NewInt(0)
+	addresses := big.NewInt(0)
+	for _, block := range pool.store.ListBlocks() {
+		if block.Pool == "" {
+			addresses.Add(addresses, pool.sizeOf(block))
+			continue
+		}
+
+		delegated.Add(delegated, big.NewInt(0).Div(pool.sizeOf(block), pool.blockSize()))
+		if child, err := pool.Child(block.Pool); err == nil {
+			childStats, err := child.Stats()
+			if err != nil {
+				pool.logger.Warn("Can't get the child pool stats", "child", block.Pool, "error", err)
+				continue
+			}
+
+			stats.Children = append(stats.Children, childStats)
+			addresses.Add(addresses, childStats.allocatedAddresses(child))
+		}
+	}
+	stats.DelegatedBlocks = delegated.Int64()
+
+	if delegated.Sign() > 0 {
+		stats.AllocatedAddresses = addresses
+		allocatable := big.NewInt(stats.TotalBlocks - stats.ExcludedBlocks)
+		stats.RollupUtilization = ratio(addresses, allocatable.Mul(allocatable, pool.blockSize()))
+	}
+
+	return stats, nil
+}
+
+// allocatedAddresses returns the number of addresses allocated in the pool and in its child pools
+func (stats *Stats) allocatedAddresses(pool *Manager) *big.Int {
+	if stats.AllocatedAddresses != nil {
+		return stats.AllocatedAddresses
+	}
+
+	//without child pools all allocated blocks are the pool blocks
+	return big.NewInt(0).Mul(big.NewInt(stats.AllocatedBlocks), pool.blockSize())
+}
+
+// layoutStats computes the utilization statistics for the union of the range layouts
+func layoutStats(layouts []*rangeLayout) *Stats {
+	total := big.NewInt(0)
+	allocated := big.NewInt(0)
+	quarantined := big.NewInt(0)
+	used := big.NewInt(0)
+	largest := big.NewInt(0)
+	rangeSizes := big.NewInt(0)
+	var hwmOffset *big.Int
+	var hwm string
+	var ranges []string
+	var runCount int64
+	var blockSize *big.Int
+
+	stats := &Stats{}
+	for _, l := range layouts {
+		blockSize = l.blockSize
+		if l.blockSize.IsInt64() {
+			stats.BlockSize = l.blockSize.Int64()
+		}
+		ranges = append(ranges, l.rng.String())
+
+		var all []extent
+		all = append(all, l.allocated...)
+		all = append(all, l.quarantined...)
+		all = append(all, l.excluded...)
+
+		total.Add(total, l.totalBlocks())
+		allocated.Add(allocated, l.countBlocks(l.allocated))
+		quarantined.Add(quarantined, l.countBlocks(l.quarantined))
+		used.Add(used, l.countBlocks(all))
+
+		runs := l.freeRuns()
+		runCount += int64(len(runs))
+		for _, run := range runs {
+			if size := run.size(); size.Cmp(largest) > 0 {
+				largest = size
+			}
+		}
+
+		//the high-water mark is the position in the union of the (ordered) ranges
+		if merged := mergeExtents(l.allocated); len(merged) > 0 {
+			last := merged[len(merged)-1].end
+			hwm = intToIP(last, l.rng.ipv6).String()
+			hwmOffset = big.NewInt(0).Sub(last, l.rng.start)
+			hwmOffset.Add(hwmOffset, rangeSizes)
+		}
+
+		rangeSizes.Add(rangeSizes, l.rng.size())
+	}
+
+	excluded := big.NewInt(0).Sub(used, allocated)
+	excluded.Sub(excluded, quarantined)
+
+	stats.Range = strings.Join(ranges, ",")
+	stats.TotalBlocks = total.Int64()
+	stats.AllocatedBlocks = allocated.Int64()
+	stats.QuarantinedBlocks = quarantined.Int64()
+	stats.ExcludedBlocks = excluded.Int64()
+	stats.FreeBlocks = big.NewInt(0).Sub(total, used).Int64()
+	stats.Exhausted = stats.FreeBlocks == 0
+
+	if allocatable := stats.TotalBlocks - stats.ExcludedBlocks; allocatable > 0 {
+		stats.Utilization = float64(stats.AllocatedBlocks) / float64(allocatable)
+	}
+
+	stats.FreeRuns = runCount
+	if blockSize != nil {
+		stats.LargestFree = largest.Div(largest, blockSize).Int64()
+	}
+	if stats.FreeBlocks > 0 {
+		stats.Fragmentation = 1 - float64(stats.LargestFree)/float64(stats.FreeBlocks)
+	}
+
+	if hwmOffset != nil {
+		stats.HighWaterMark = hwm
+		stats.HighWaterMarkRatio = ratio(hwmOffset, rangeSizes.Sub(rangeSizes, big.NewInt(1)))
+	}
+
+	return stats
+}
+
+func ratio(a, b *big.Int) float64 {
+	if b.Sign() == 0 {
+		return 1
+	}
+
+	r, _ := big.NewRat(1, 1).SetFrac(a, b).Float64()
+	return r
+}
@@ -0,0 +1,247 @@
+package pool
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// testConsul is an in-memory Consul KV API with the session based locks (enough for the Store)
+type testConsul struct {
+	mu       sync.Mutex
+	index    uint64
+	pairs    map[string]*api.KVPair
+	sessions int
+	//leader is the Consul leader address (the empty address means no leader)
+	leader string
+}
+
+// newTestStore returns a Store backed by a new in-memory Consul KV API
+func newTestStore(t *testing.T) *Store {
+	_, store := newTestConsul(t)
+	return store
+}
+
+// newTestConsul returns a new in-memory Consul KV API and a Store backed by it
+func newTestConsul(t *testing.T) (*testConsul, *Store) {
+	consul := &testConsul{index: 1, pairs: map[string]*api.KVPair{}, leader: "127.0.0.1:8300"}
+	srv := httptest.NewServer(consul)
+	t.Cleanup(srv.Close)
+
+	return consul, NewStore(&api.Config{Address: srv.Listener.Addr().String()})
+}
+
+func (c *testConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/v1/kv/"):
+		c.serveKV(w, r, strings.TrimPrefix(r.URL.Path, "/v1/kv/"))
+	case r.URL.Path == "/v1/txn":
+		c.serveTxn(w, r)
+	case r.URL.Path == "/v1/status/leader":
+		c.mu.Lock()
+		leader := c.leader
+		c.mu.Unlock()
+		json.NewEncoder(w).Encode(leader)
+	case r.URL.Path == "/v1/session/create":
+		c.mu.Lock()
+		c.sessions++
+		id := "session-" + strconv.Itoa(c.sessions)
+		c.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]string{"ID": id})
+	case strings.HasPrefix(r.URL.Path, "/v1/session/renew/"):
+		id := strings.TrimPrefix(r.URL.Path, "/v1/session/renew/")
+		json.NewEncoder(w).Encode([]map[string]string{{"ID": id, "TTL": "15s"}})
+	case strings.HasPrefix(r.URL.Path, "/v1/session/destroy/"):
+		c.destroySession(strings.TrimPrefix(r.URL.Path, "/v1/session/destroy/"))
+		w.Write([]byte("true"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// wait blocks the query until the KV index is greater than the query index (or a short timeout)
+func (c *testConsul) wait(r *http.Request) {
+	index, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64)
+	for deadline := time.Now().Add(time.Second); index > 0 && time.Now().Before(deadline); {
+		c.mu.Lock()
+		changed := c.index > index
+		c.mu.Unlock()
+		if changed {
+			return
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func (c *testConsul) serveKV(w http.ResponseWriter, r *http.Request, key string) {
+	query := r.URL.Query()
+	_, recurse := query["recurse"]
+	if _, ok := query["keys"]; ok {
+		recurse = true
+	}
+
+	if r.Method == http.MethodGet {
+		c.wait(r)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(c.index, 10))
+	w.Header().Set("X-Consul-LastContact", "0")
+	w.Header().Set("X-Consul-KnownLeader", "true")
+
+	switch r.Method {
+	case http.MethodGet:
+		var pairs api.KVPairs
+		for k, pair := range c.pairs {
+			if k == key || (recurse && strings.HasPrefix(k, key)) {
+				pairs = append(pairs, pair)
+			}
+		}
+
+		if len(pairs) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		sort.Slice(pairs, func(i, j int) bool { return pairs[i].Key < pairs[j].Key })
+		if _, ok := query["keys"]; ok {
+			var keys []string
+			for _, pair := range pairs {
+				keys = append(keys, pair.Key)
+			}
+
+			json.NewEncoder(w).Encode(keys)
+			return
+		}
+
+		json.NewEncoder(w).Encode(pairs)
+	case http.MethodPut:
+		value, _ := ioutil.ReadAll(r.Body)
+		json.NewEncoder(w).Encode(c.put(key, value, query))
+	case http.MethodDelete:
+		json.NewEncoder(w).Encode(c.delete(key, recurse, query))
+	}
+}
+
+func (c *testConsul) put(key string, value []byte, query map[string][]string) bool {
+	pair, exists := c.pairs[key]
+	if cas := queryValue(query["cas"]); cas != "" {
+		index, _ := strconv.ParseUint(cas, 10, 64)
+		if (index == 0 && exists) || (index != 0 && (!exists || pair.ModifyIndex != index)) {
+			return false
+		}
+	}
+
+	session := queryValue(query["acquire"])
+	if session != "" && exists && pair.Session != "" && pair.Session != session {
+		return false
+	}
+
+	release := queryValue(query["release"])
+	if release != "" && (!exists || pair.Session != release) {
+		return false
+	}
+
+	c.index++
+	if !exists {
+		pair = &api.KVPair{Key: key, CreateIndex: c.index}
+		c.pairs[key] = pair
+	}
+
+	if session != "" {
+		pair.Session = session
+	}
+
+	if release != "" {
+		pair.Session = ""
+	}
+
+	pair.Flags, _ = strconv.ParseUint(queryValue(query["flags"]), 10, 64)
+	pair.Value = value
+	pair.ModifyIndex = c.index
+	return true
+}
+
+func (c *testConsul) delete(key string, recurse bool, query map[string][]string) bool {
+	if cas := queryValue(query["cas"]); cas != "" {
+		index, _ := strconv.ParseUint(cas, 10, 64)
+		if pair, ok := c.pairs[key]; !ok || pair.ModifyIndex != index {
+			return false
+		}
+	}
+
+	for k := range c.pairs {
+		if k == key || (recurse && strings.HasPrefix(k, key)) {
+			delete(c.pairs, k)
+		}
+	}
+
+	c.index++
+	return true
+}
+
+// serveTxn runs the read-only transactions (the transaction fails if a key doesn't exist)
+func (c *testConsul) serveTxn(w http.ResponseWriter, r *http.Request) {
+	var ops api.TxnOps
+	if err := json.NewDecoder(r.Body).Decode(&ops); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	resp := &api.TxnResponse{}
+	for i, op := range ops {
+		pair, ok := c.pairs[op.KV.Key]
+		if op.KV.Verb != api.KVGet || !ok {
+			resp.Errors = append(resp.Errors, &api.TxnError{OpIndex: i, What: "key doesn't exist"})
+			continue
+		}
+
+		resp.Results = append(resp.Results, &api.TxnResult{KV: pair})
+	}
+
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(c.index, 10))
+	w.Header().Set("X-Consul-LastContact", "0")
+	if len(resp.Errors) > 0 {
+		resp.Results = nil
+		w.WriteHeader(http.StatusConflict)
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+// destroySession releases the locks held by the session
+func (c *testConsul) destroySession(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, pair := range c.pairs {
+		if pair.Session == id {
+			pair.Session = ""
+		}
+	}
+
+	c.index++
+}
+
+func queryValue(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
@@ -0,0 +1,88 @@
+package pool
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// tokenRecorder is a Consul KV API without any keys that records the ACL tokens
+type tokenRecorder struct {
+	mu     sync.Mutex
+	tokens []string
+}
+
+func (r *tokenRecorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	r.tokens = append(r.tokens, req.Header.Get("X-Consul-Token"))
+	r.mu.Unlock()
+	http.NotFound(w, req)
+}
+
+func TestStoreConfigToken(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("file-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name   string
+		config *StoreConfig
+		want   string
+	}{
+		{name: "no token", config: &StoreConfig{}},
+		{name: "token", config: &StoreConfig{Token: "secret"}, want: "secret"},
+		{name: "token file", config: &StoreConfig{TokenFile: tokenFile}, want: "file-token"},
+		{name: "token over token file", config: &StoreConfig{Token: "secret", TokenFile: tokenFile}, want: "secret"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := &tokenRecorder{}
+			srv := httptest.NewServer(recorder)
+			defer srv.Close()
+
+			test.config.Address = srv.Listener.Addr().String()
+			NewStoreWithConfig(test.config).GetRecord("poc/test")
+
+			if len(recorder.tokens) != 1 || recorder.tokens[0] != test.want {
+				t.Errorf("ACL tokens = %q, want %q", recorder.tokens, test.want)
+			}
+		})
+	}
+}
+
+func TestStoreConfigTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(&tokenRecorder{})
+	defer srv.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, ca, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	//the TLS options switch the default scheme to https
+	for _, config := range []*StoreConfig{
+		{Address: srv.Listener.Addr().String(), CAFile: caFile},
+		{Address: srv.Listener.Addr().String(), CAFile: caFile, TLSServerName: "example.com"},
+	} {
+		if record := NewStoreWithConfig(config).GetRecord("poc/test"); record != nil {
+			t.Errorf("GetRecord() = %q, want no record", record)
+		}
+	}
+
+	//the server certificate doesn't have the name
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("GetRecord() with another TLS server name succeeded")
+			}
+		}()
+
+		NewStoreWithConfig(&StoreConfig{Address: srv.Listener.Addr().String(), CAFile: caFile, TLSServerName: "consul.internal"}).
+			GetRecord("poc/test")
+	}()
+}
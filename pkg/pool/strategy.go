@@ -0,0 +1,259 @@
+package pool
+
+import (
+	"errors"
+	"math/big"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// Allocation strategy names
+const (
+	StrategySequential     = "sequential"
+	StrategySequentialWrap = "sequential-wrap"
+	StrategyFirstFit       = "first-fit"
+	StrategyRandom         = "random"
+	StrategyBestFit        = "best-fit"
+)
+
+// Strategy errors
+var (
+	//
+	ErrUnknownStrategy = errors.New("Unknown allocation strategy")
+)
+
+// FreeRun is a contiguous range of free IP Blocks
+type FreeRun struct {
+	Start net.IP
+	//Size is the number of addresses in the free run (always a multiple of the block size)
+	Size *big.Int
+}
+
+// AllocationView describes the pool state used by the allocation strategies
+type AllocationView struct {
+	//BlockSize is the pool block size (number of addresses)
+	BlockSize *big.Int
+	//Size is the number of addresses to allocate (a multiple of the block size)
+	Size *big.Int
+	//Next is the sequential allocation boundary (nothing is allocated at or after it)
+	Next net.IP
+	//Free contains the free runs ordered by their start address
+	Free []*FreeRun
+}
+
+// Fit returns the first address in the free run where the requested size fits or nil if it doesn't fit.
+// Allocations larger than the block size are aligned to their size (so they form an aligned prefix).
+func (v *AllocationView) Fit(run *FreeRun) net.IP {
+	start := ipToInt(run.Start)
+	end := big.NewInt(0).Add(start, run.Size)
+
+	if v.Size.Cmp(v.BlockSize) > 0 {
+		start = alignUp(start, big.NewInt(0), v.Size)
+	}
+
+	if big.NewInt(0).Add(start, v.Size).Cmp(end) > 0 {
+		return nil
+	}
+
+	return intToIP(start, isIPv6(run.Start))
+}
+
+// AllocationStrategy selects the IP Block to allocate
+type AllocationStrategy interface {
+	//Name returns the strategy name
+	Name() string
+	//Select returns the start address of the IP Block to allocate (or nil if nothing fits)
+	Select(view *AllocationView) net.IP
+}
+
+// NewStrategy creates a new allocation strategy by its name
+// (the seed is used by the random strategy; 0 means a time based seed)
+func NewStrategy(name string, seed int64) (AllocationStrategy, error) {
+	switch name {
+	case "", StrategySequential:
+		return &SequentialStrategy{}, nil
+	case StrategySequentialWrap:
+		return &SequentialStrategy{WrapAround: true}, nil
+	case StrategyFirstFit:
+		return &FirstFitStrategy{}, nil
+	case StrategyRandom:
+		return NewRandomStrategy(seed), nil
+	case StrategyBestFit:
+		return &BestFitStrategy{}, nil
+	}
+
+	return nil, ErrUnknownStrategy
+}
+
+// SequentialStrategy allocates the blocks one after another starting at the Next boundary
+// (the freed blocks before Next are not allocated again unless the strategy wraps around)
+type SequentialStrategy struct {
+	//WrapAround allocates the lowest free block when there's nothing free after Next
+	WrapAround bool
+}
+
+// Name returns the strategy name
+func (s *SequentialStrategy) Name() string {
+	if s.WrapAround {
+		return StrategySequentialWrap
+	}
+
+	return StrategySequential
+}
+
+// Select returns the first free block at or after the Next boundary
+func (s *SequentialStrategy) Select(view *AllocationView) net.IP {
+	if view.Next != nil {
+		next := ipToInt(view.Next)
+		for _, run := range view.Free {
+			runEnd := big.NewInt(0).Add(ipToInt(run.Start), run.Size)
+			if runEnd.Cmp(next) <= 0 {
+				continue
+			}
+
+			candidate := run
+			if ipToInt(run.Start).Cmp(next) < 0 {
+				//Next is always block aligned
+				candidate = &FreeRun{
+					Start: view.Next,
+					Size:  big.NewInt(0).Sub(runEnd, next),
+				}
+			}
+
+			if ip := view.Fit(candidate); ip != nil {
+				return ip
+			}
+		}
+	}
+
+	if !s.WrapAround {
+		return nil
+	}
+
+	return (&FirstFitStrategy{}).Select(view)
+}
+
+// FirstFitStrategy allocates the lowest free block
+type FirstFitStrategy struct{}
+
+// Name returns the strategy name
+func (s *FirstFitStrategy) Name() string {
+	return StrategyFirstFit
+}
+
+// Select returns the lowest free block
+func (s *FirstFitStrategy) Select(view *AllocationView) net.IP {
+	for _, run := range view.Free {
+		if ip := view.Fit(run); ip != nil {
+			return ip
+		}
+	}
+
+	return nil
+}
+
+// BestFitStrategy allocates from the smallest free run that fits the requested size
+// (keeping the larger free runs available for larger allocations)
+type BestFitStrategy struct{}
+
+// Name returns the strategy name
+func (s *BestFitStrategy) Name() string {
+	return StrategyBestFit
+}
+
+// Select returns the first fitting block in the smallest free run
+func (s *BestFitStrategy) Select(view *AllocationView) net.IP {
+	var best net.IP
+	var bestSize *big.Int
+	for _, run := range view.Free {
+		ip := view.Fit(run)
+		if ip == nil {
+			continue
+		}
+
+		if bestSize == nil || run.Size.Cmp(bestSize) < 0 {
+			best = ip
+			bestSize = run.Size
+		}
+	}
+
+	return best
+}
+
+// RandomStrategy allocates a random free block
+// (the same seed produces the same allocation sequence for the same pool state)
+type RandomStrategy struct {
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+// NewRandomStrategy creates a new random allocation strategy (0 means a time based seed)
+func NewRandomStrategy(seed int64) *RandomStrategy {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	return &RandomStrategy{
+		rnd: rand.New(rand.NewSource(seed)),
+	}
+}
+
+// Name returns the strategy name
+func (s *RandomStrategy) Name() string {
+	return StrategyRandom
+}
+
+// Select returns a random free (and aligned) block
+func (s *RandomStrategy) Select(view *AllocationView) net.IP {
+	//every free run has (size - request size)/alignment + 1 candidate positions
+	align := view.BlockSize
+	if view.Size.Cmp(view.BlockSize) > 0 {
+		align = view.Size
+	}
+
+	type candidates struct {
+		start *big.Int
+		count *big.Int
+		ipv6  bool
+	}
+
+	var all []candidates
+	total := big.NewInt(0)
+	for _, run := range view.Free {
+		ip := view.Fit(run)
+		if ip == nil {
+			continue
+		}
+
+		start := ipToInt(ip)
+		runEnd := big.NewInt(0).Add(ipToInt(run.Start), run.Size)
+		count := big.NewInt(0).Sub(runEnd, start)
+		count.Sub(count, view.Size)
+		count.Div(count, align)
+		count.Add(count, big.NewInt(1))
+
+		all = append(all, candidates{start: start, count: count, ipv6: isIPv6(ip)})
+		total.Add(total, count)
+	}
+
+	if total.Sign() == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	pick := big.NewInt(0).Rand(s.rnd, total)
+	s.mu.Unlock()
+
+	for _, c := range all {
+		if pick.Cmp(c.count) < 0 {
+			offset := big.NewInt(0).Mul(pick, align)
+			return intToIP(offset.Add(offset, c.start), c.ipv6)
+		}
+
+		pick.Sub(pick, c.count)
+	}
+
+	return nil
+}
@@ -0,0 +1,134 @@
+package pool
+
+import (
+	"math/big"
+	"net"
+	"testing"
+)
+
+// testView returns an allocation view for the 4 address blocks
+func testView(size int64, next string, free ...*FreeRun) *AllocationView {
+	return &AllocationView{BlockSize: big.NewInt(4), Size: big.NewInt(size), Next: net.ParseIP(next), Free: free}
+}
+
+func freeRun(start string, size int64) *FreeRun {
+	return &FreeRun{Start: net.ParseIP(start), Size: big.NewInt(size)}
+}
+
+func TestStrategySelect(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		strategy AllocationStrategy
+		view     *AllocationView
+		want     string
+	}{
+		{
+			name:     "first fit lowest run",
+			strategy: &FirstFitStrategy{},
+			view:     testView(4, "", freeRun("169.254.60.8", 4), freeRun("169.254.60.16", 16)),
+			want:     "169.254.60.8",
+		},
+		{
+			name:     "first fit skips the small runs",
+			strategy: &FirstFitStrategy{},
+			view:     testView(8, "", freeRun("169.254.60.8", 4), freeRun("169.254.60.16", 16)),
+			want:     "169.254.60.16",
+		},
+		{
+			name:     "first fit aligns the large blocks",
+			strategy: &FirstFitStrategy{},
+			view:     testView(8, "", freeRun("169.254.60.4", 16)),
+			want:     "169.254.60.8",
+		},
+		{
+			name:     "first fit nothing fits",
+			strategy: &FirstFitStrategy{},
+			view:     testView(16, "", freeRun("169.254.60.4", 16)),
+		},
+		{
+			name:     "best fit smallest run",
+			strategy: &BestFitStrategy{},
+			view:     testView(4, "", freeRun("169.254.60.0", 16), freeRun("169.254.60.20", 4), freeRun("169.254.60.32", 8)),
+			want:     "169.254.60.20",
+		},
+		{
+			name:     "best fit first of the smallest runs",
+			strategy: &BestFitStrategy{},
+			view:     testView(4, "", freeRun("169.254.60.0", 8), freeRun("169.254.60.20", 8)),
+			want:     "169.254.60.0",
+		},
+		{
+			name:     "best fit skips the small runs",
+			strategy: &BestFitStrategy{},
+			view:     testView(8, "", freeRun("169.254.60.4", 4), freeRun("169.254.60.16", 8), freeRun("169.254.60.32", 32)),
+			want:     "169.254.60.16",
+		},
+		{
+			name:     "best fit nothing fits",
+			strategy: &BestFitStrategy{},
+			view:     testView(8, "", freeRun("169.254.60.4", 4), freeRun("169.254.60.12", 4)),
+		},
+		{
+			name:     "sequential at next",
+			strategy: &SequentialStrategy{},
+			view:     testView(4, "169.254.60.8", freeRun("169.254.60.0", 4), freeRun("169.254.60.4", 12)),
+			want:     "169.254.60.8",
+		},
+		{
+			name:     "sequential after next",
+			strategy: &SequentialStrategy{},
+			view:     testView(4, "169.254.60.8", freeRun("169.254.60.0", 4), freeRun("169.254.60.16", 4)),
+			want:     "169.254.60.16",
+		},
+		{
+			name:     "sequential doesn't reuse the freed blocks",
+			strategy: &SequentialStrategy{},
+			view:     testView(4, "169.254.60.16", freeRun("169.254.60.0", 4)),
+		},
+		{
+			name:     "sequential wrap around",
+			strategy: &SequentialStrategy{WrapAround: true},
+			view:     testView(4, "169.254.60.16", freeRun("169.254.60.4", 4)),
+			want:     "169.254.60.4",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got := test.strategy.Select(test.view)
+			if (got == nil && test.want != "") || (got != nil && got.String() != test.want) {
+				t.Errorf("Select() = %v, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRandomStrategySeed(t *testing.T) {
+	sequence := func(seed int64) []string {
+		strategy := NewRandomStrategy(seed)
+		var selected []string
+		for i := 0; i < 8; i++ {
+			ip := strategy.Select(testView(4, "", freeRun("169.254.60.0", 64), freeRun("169.254.61.0", 256)))
+			if ip == nil {
+				t.Fatal("Select() = nil, want a free block")
+			}
+
+			selected = append(selected, ip.String())
+		}
+
+		return selected
+	}
+
+	first, second := sequence(42), sequence(42)
+	if !equalStrings(first, second) {
+		t.Errorf("the same seed selected %v and %v, want the same sequence", first, second)
+	}
+
+	if other := sequence(7); equalStrings(first, other) {
+		t.Errorf("seeds 42 and 7 selected the same sequence %v", first)
+	}
+
+	for _, ip := range first {
+		if ipToInt(net.ParseIP(ip)).Int64()%4 != 0 {
+			t.Errorf("Select() = %s, want a block aligned address", ip)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package pool
+
+import (
+	"errors"
+)
+
+// Update errors
+var (
+	//
+	ErrVersionMismatch = errors.New("Block was changed by another request")
+	//
+	ErrKeyInUse = errors.New("Block Key is used by another block")
+)
+
+// BlockUpdate contains the IP Block fields to change (the nil fields are not changed)
+type BlockUpdate struct {
+	Key *string
+	//Labels replace the block labels (an empty map removes all labels)
+	Labels      map[string]string
+	Description *string
+}
+
+// UpdateBlock changes the Block Key, the labels or the description of the IP Block
+// selected by its starting address or its Block Key.
+// If the version is not 0 it must match the current block version (the Consul ModifyIndex of the block record),
+// so concurrent updates don't overwrite each other. The block tenant doesn't change with the Block Key.
+func (pool *Manager) UpdateBlock(ipBlock, blockKey string, version uint64, update *BlockUpdate) (*BlockInfo, error) {
+	if update.Labels != nil && !ValidLabels(update.Labels) {
+		return nil, ErrInvalidLabels
+	}
+
+	lock := pool.acquireLock("Pool.UpdateBlock")
+	defer lock.Unlock()
+
+	block := pool.Lookup(ipBlock, blockKey)
+	if block == nil {
+		return nil, ErrBlockNotFound
+	}
+
+	if err := pool.checkHolder(block); err != nil {
+		return nil, err
+	}
+
+	if version != 0 && block.Version != version {
+		return nil, ErrVersionMismatch
+	}
+
+	if update.Key != nil && *update.Key != block.Key {
+		//the key is shared with the paired block (or it's the child pool name for the delegated blocks)
+		if err := standalone(block); err != nil {
+			return nil, err
+		}
+
+		if *update.Key != "" && pool.store.FindBlock(pool.namespace, *update.Key) != nil {
+			return nil, ErrKeyInUse
+		}
+
+		block.Key = *update.Key
+	}
+
+	if update.Labels != nil {
+		block.Labels = update.Labels
+		if len(update.Labels) == 0 {
+			block.Labels = nil
+		}
+	}
+
+	if update.Description != nil {
+		block.Description = *update.Description
+	}
+
+	//NOTE: the CAS write also protects the record if the pool lock session is lost (the lock channel is not watched)
+	if !pool.store.SaveBlockCAS(block, block.Version) {
+		return nil, ErrVersionMismatch
+	}
+
+	pool.logger.Info("Updated IP block", "block", block.Start)
+	return pool.store.GetBlock(block.Start), nil
+}
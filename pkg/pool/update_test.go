@@ -0,0 +1,63 @@
+package pool
+
+import (
+	"testing"
+)
+
+func TestUpdateBlock(t *testing.T) {
+	pool := New(&Config{StartRange: "169.254.60.0", EndRange: "169.254.60.15", PoolBlockSize: 4}, newTestStore(t))
+	block, err := pool.Allocate("vm-1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := pool.Allocate("vm-2", false); err != nil {
+		t.Fatal(err)
+	}
+
+	description := "web tier"
+	update := &BlockUpdate{Labels: map[string]string{"env": "prod"}, Description: &description}
+	if _, err := pool.UpdateBlock(block.Start, "", block.Version, update); err != ErrHolderRequired {
+		t.Errorf("UpdateBlock() without the holder token error = %v, want %v", err, ErrHolderRequired)
+	}
+
+	holder := pool.WithHolder(block.HolderToken)
+	current := pool.Lookup(block.Start, "")
+	updated, err := holder.UpdateBlock(block.Start, "", current.Version, update)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if updated.Labels["env"] != "prod" || updated.Description != description || updated.Version <= current.Version {
+		t.Errorf("UpdateBlock() = %+v, want the new labels, description and version", updated)
+	}
+
+	//the update with the old version doesn't overwrite the newer block
+	if _, err := holder.UpdateBlock(block.Start, "", current.Version, &BlockUpdate{Labels: map[string]string{}}); err != ErrVersionMismatch {
+		t.Errorf("UpdateBlock() with the old version error = %v, want %v", err, ErrVersionMismatch)
+	}
+
+	key := "vm-2"
+	if _, err := holder.UpdateBlock(block.Start, "", 0, &BlockUpdate{Key: &key}); err != ErrKeyInUse {
+		t.Errorf("UpdateBlock() with a used key error = %v, want %v", err, ErrKeyInUse)
+	}
+
+	//the version 0 matches any version and the empty labels remove all labels
+	key = "vm-3"
+	updated, err = holder.UpdateBlock("", "vm-1", 0, &BlockUpdate{Key: &key, Labels: map[string]string{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if updated.Key != "vm-3" || updated.Labels != nil || updated.Description != description {
+		t.Errorf("UpdateBlock() = %+v, want the new key without labels", updated)
+	}
+
+	if pool.Lookup("", "vm-1") != nil || pool.Lookup("", "vm-3") == nil {
+		t.Error("UpdateBlock() didn't change the Block Key")
+	}
+
+	if _, err := holder.UpdateBlock("", "vm-1", 0, &BlockUpdate{}); err != ErrBlockNotFound {
+		t.Errorf("UpdateBlock() of the old key error = %v, want %v", err, ErrBlockNotFound)
+	}
+}
@@ -0,0 +1,131 @@
+package pool
+
+import (
+	"errors"
+	"net"
+)
+
+// IP address status values (reported by Whois)
+const (
+	AddressStatusAllocated  = "allocated"
+	AddressStatusFree       = "free"
+	AddressStatusExcluded   = "excluded"
+	AddressStatusQuarantine = "quarantined"
+	AddressStatusDelegated  = "delegated"
+	AddressStatusOutOfRange = "out-of-range"
+)
+
+// Whois errors
+var (
+	//
+	ErrInvalidIP = errors.New("Invalid IP address")
+)
+
+// WhoisInfo describes the IP address status and the IP Block allocation that contains it
+type WhoisInfo struct {
+	IP         string          `json:"ip"`
+	Status     string          `json:"status"`
+	Range      string          `json:"range,omitempty"`
+	Exclusion  string          `json:"exclusion,omitempty"`
+	Quarantine *QuarantineInfo `json:"quarantine,omitempty"`
+	Block      *BlockInfo      `json:"block,omitempty"`
+	Address    *AddressInfo    `json:"address,omitempty"`
+	//Pool is the pool name the status is reported for (the default pool has no name)
+	Pool string `json:"pool,omitempty"`
+	//Child is the address status in the child pool (for the delegated IP Blocks)
+	Child *WhoisInfo `json:"child,omitempty"`
+}
+
+// Whois returns the status of an arbitrary IP address
+// and the IP Block allocation that contains it (if it's allocated)
+func (pool *Manager) Whois(address string) (*WhoisInfo, error) {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return nil, ErrInvalidIP
+	}
+
+	info := &WhoisInfo{
+		IP:     ip.String(),
+		Status: AddressStatusOutOfRange,
+		Pool:   pool.name,
+	}
+
+	pool, err := pool.load()
+	if err != nil {
+		return nil, err
+	}
+
+	ipVal := ipToInt(ip)
+
+	var poolRange *ipRange
+	for _, r := range pool.ranges() {
+		if r.ipv6 == isIPv6(ip) && r.contains(ipVal) {
+			poolRange = r
+			break
+		}
+	}
+
+	if poolRange == nil {
+		return info, nil
+	}
+
+	info.Range = poolRange.String()
+	info.Status = AddressStatusFree
+
+	if blockInfo := pool.findBlockContaining(ip); blockInfo != nil {
+		info.Status = AddressStatusAllocated
+		if pool.namespace != "" && !pool.inNamespace(blockInfo) {
+			//the IP Blocks from the other namespaces are not visible
+			return info, nil
+		}
+
+		info.Block = blockInfo
+
+		if blockInfo.Pool != "" {
+			info.Status = AddressStatusDelegated
+			if child, err := pool.Child(blockInfo.Pool); err == nil {
+				info.Child, _ = child.Whois(address)
+			}
+			return info, nil
+		}
+
+		info.Address = pool.store.GetAddress(blockInfo.Start, ip.String())
+		return info, nil
+	}
+
+	if q := pool.addressQuarantined(ip); q != nil {
+		info.Status = AddressStatusQuarantine
+		info.Quarantine = q
+		return info, nil
+	}
+
+	for _, r := range pool.excluded {
+		if r.ipv6 == poolRange.ipv6 && r.contains(ipVal) {
+			info.Status = AddressStatusExcluded
+			info.Exclusion = r.String()
+			break
+		}
+	}
+
+	return info, nil
+}
+
+// findBlockContaining returns the IP Block that contains the IP address (or nil if it's not allocated)
+func (pool *Manager) findBlockContaining(ip net.IP) *BlockInfo {
+	ipVal := ipToInt(ip)
+
+	//NOTE: the IP Blocks can have different sizes (the delegated blocks), so all blocks are checked
+	for _, blockInfo := range pool.store.ListBlocks() {
+		blockStart := net.ParseIP(blockInfo.Start)
+		if blockStart == nil || isIPv6(blockStart) != isIPv6(ip) {
+			continue
+		}
+
+		blockRange := newExtent(ipToInt(blockStart), pool.sizeOf(blockInfo))
+		if blockRange.start.Cmp(ipVal) <= 0 && blockRange.end.Cmp(ipVal) >= 0 {
+			return blockInfo
+		}
+	}
+
+	return nil
+}